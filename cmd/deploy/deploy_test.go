@@ -240,3 +240,27 @@ targets:
 	os.Args = []string{"deploy", "--tag", "123", "dummy"}
 	main()
 }
+
+func TestDeploy_ClusterWithoutContext(t *testing.T) {
+	exitFunc = func(code int) {
+		assert.Equal(t, -4, code)
+	}
+	oldPwd, _ := os.Getwd()
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	yaml := `
+targets:
+  dummy:
+    namespace: none
+    clusters:
+      - name: eu
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+
+	err := os.Chdir(name)
+	assert.NoError(t, err)
+	defer func() { _ = os.Chdir(oldPwd) }()
+
+	os.Args = []string{"deploy", "--tag", "123", "dummy"}
+	main()
+}
@@ -100,3 +100,14 @@ func Verbose(ctx log.Interface) bool {
 	}
 	return false
 }
+
+// Redirect makes the Handler of ctx write to w instead, i.e. to keep stdout free for machine-readable output
+func Redirect(ctx log.Interface, w io.Writer) {
+	if logger, ok := ctx.(*log.Logger); ok {
+		if handler, ok := logger.Handler.(*Handler); ok {
+			handler.mu.Lock()
+			defer handler.mu.Unlock()
+			handler.Writer = w
+		}
+	}
+}
@@ -88,6 +88,19 @@ func Test_Verbose(t *testing.T) {
 	assert.False(t, Verbose(invalidLog{}))
 }
 
+func Test_Redirect(t *testing.T) {
+	handler := New(os.Stdout)
+	log.SetHandler(handler)
+	buff := &bytes.Buffer{}
+
+	Redirect(log.Log, buff)
+	log.Info("redirected")
+
+	assert.Equal(t, buff, handler.Writer)
+	assert.Equal(t, "\x1b[0mredirected\x1b[0m", buff.String())
+	Redirect(invalidLog{}, os.Stdout)
+}
+
 type checkLocker struct {
 	lockCalled   int
 	unlockCalled int
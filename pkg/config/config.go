@@ -31,7 +31,9 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"dario.cat/mergo"
 	"github.com/apex/log"
@@ -44,13 +46,18 @@ import (
 )
 
 type Config struct {
-	VCS                 *VCSConfig        `yaml:"vcs"`
-	CI                  *CIConfig         `yaml:"ci"`
-	Registry            *RegistryConfig   `yaml:"registry"`
-	Cache               *CacheConfig      `yaml:"cache"`
-	Targets             map[string]Target `yaml:"targets"`
-	Git                 Git               `yaml:"git"`
-	Gitops              map[string]Gitops `yaml:"gitops"`
+	VCS                 *VCSConfig           `yaml:"vcs"`
+	CI                  *CIConfig            `yaml:"ci"`
+	Registry            *RegistryConfig      `yaml:"registry"`
+	Cache               *CacheConfig         `yaml:"cache"`
+	Templating          *TemplatingConfig    `yaml:"templating"`
+	Helm                *HelmConfig          `yaml:"helm"`
+	Validation          *ValidationConfig    `yaml:"validation"`
+	Policy              *PolicyConfig        `yaml:"policy"`
+	Notifications       *NotificationsConfig `yaml:"notifications"`
+	Targets             map[string]Target    `yaml:"targets"`
+	Git                 Git                  `yaml:"git"`
+	Gitops              map[string]Gitops    `yaml:"gitops"`
 	AvailableCI         []ci.CI
 	AvailableRegistries []registry.Registry
 }
@@ -80,20 +87,95 @@ type RegistryConfig struct {
 }
 
 type Target struct {
+	Context           string            `yaml:"context"`
+	Namespace         string            `yaml:"namespace,omitempty"`
+	Kubeconfig        string            `yaml:"kubeconfig,omitempty"`
+	Variables         map[string]string `yaml:"variables,omitempty"`
+	RollbackOnFailure bool              `yaml:"rollbackOnFailure,omitempty"`
+	Prune             bool              `yaml:"prune,omitempty"`
+	Native            bool              `yaml:"native,omitempty"`
+	Hooks             Hooks             `yaml:"hooks,omitempty"`
+	Clusters          []Cluster         `yaml:"clusters,omitempty"`
+	Strategy          string            `yaml:"strategy,omitempty"`
+	PinDigest         bool              `yaml:"pinDigest,omitempty"`
+	VerifyImage       bool              `yaml:"verifyImage,omitempty"`
+}
+
+// Cluster is one of several clusters a target is deployed to, empty values are taken from the target
+type Cluster struct {
+	Name       string `yaml:"name"`
 	Context    string `yaml:"context"`
 	Namespace  string `yaml:"namespace,omitempty"`
 	Kubeconfig string `yaml:"kubeconfig,omitempty"`
 }
 
+// Hooks are commands to run in the different phases of a deploy
+type Hooks struct {
+	PreApply    []string `yaml:"preApply,omitempty"`
+	PostApply   []string `yaml:"postApply,omitempty"`
+	PostRollout []string `yaml:"postRollout,omitempty"`
+	OnFailure   []string `yaml:"onFailure,omitempty"`
+}
+
 type Git struct {
 	Name  string `yaml:"name"`
 	Email string `yaml:"email"`
 	Key   string `yaml:"key"`
+	// Agent uses the keys of the running ssh-agent instead of a key file.
+	Agent bool `yaml:"agent"`
+	// KnownHosts is the known_hosts file used to verify the SSH host key, defaults to $SSH_KNOWN_HOSTS or
+	// ~/.ssh/known_hosts.
+	KnownHosts string `yaml:"knownHosts"`
+	// User is the username for HTTP(S) authentication.
+	User string `yaml:"user"`
+	// Token is the password or access token for HTTPS authentication, environment variables are expanded.
+	// It is never sent over http.
+	Token string `yaml:"token"`
+	// PushAttempts is how many times promote pushes when the push is rejected since the branch has been updated
+	// concurrently, defaults to 5.
+	PushAttempts int `yaml:"pushAttempts"`
+	// PushBackoff is how long to wait before the first retry, doubled for each following one, defaults to 1s.
+	PushBackoff string `yaml:"pushBackoff"`
+	// Shallow makes promote clone only the latest commit of a single branch.
+	Shallow bool `yaml:"shallow"`
+	// CacheDir keeps clones in the directory between promotes, fetching and resetting them instead of cloning again.
+	CacheDir string `yaml:"cacheDir"`
 }
 
 type Gitops struct {
-	URL  string `yaml:"url,omitempty"`
-	Path string `yaml:"path,omitempty"`
+	URL           string            `yaml:"url,omitempty"`
+	Path          string            `yaml:"path,omitempty"`
+	Variables     map[string]string `yaml:"variables,omitempty"`
+	KeepEncrypted bool              `yaml:"keepEncrypted,omitempty"`
+	PinDigest     bool              `yaml:"pinDigest,omitempty"`
+	VerifyImage   bool              `yaml:"verifyImage,omitempty"`
+	PullRequest   *PullRequest      `yaml:"pullRequest,omitempty"`
+	// Branch is the branch to commit to, defaults to the default branch of the repository.
+	Branch string `yaml:"branch,omitempty"`
+	// File is a Go template for the path of the generated file relative to Path, defaults to {{ .App }}/deploy.yaml.
+	File string `yaml:"file,omitempty"`
+	// Split writes one file per Kubernetes object, replacing everything in the directory of File, which must
+	// depend on the application.
+	Split bool `yaml:"split,omitempty"`
+}
+
+// PullRequest configures promoting through a pull/merge request instead of pushing to the branch directly.
+type PullRequest struct {
+	// Provider is github, gitlab or gitea, determined from the URL if empty.
+	Provider string `yaml:"provider,omitempty"`
+	// API is the base URL of the API, i.e. https://gitea.example.org/api/v1, determined from the URL if empty.
+	API string `yaml:"api,omitempty"`
+	// Repository is the path of the repository, i.e. org/gitops, determined from the URL if empty.
+	Repository string `yaml:"repository,omitempty"`
+	// Token authenticates to the API, environment variables are expanded. Defaults to the GITHUB_TOKEN,
+	// GITLAB_TOKEN or GITEA_TOKEN environment variable depending on the provider.
+	Token string `yaml:"token,omitempty"`
+	// Base is the branch to merge into, defaults to the default branch of the repository.
+	Base string `yaml:"base,omitempty"`
+	// Title is a Go template for the title, replacing the default one.
+	Title string `yaml:"title,omitempty"`
+	// Body is a Go template for the description, replacing the default one.
+	Body string `yaml:"body,omitempty"`
 }
 
 // CacheConfig configures buildkit layer cache storage.
@@ -105,6 +187,32 @@ type CacheConfig struct {
 	GoMounts bool `yaml:"go_mounts" env:"BUILDTOOLS_CACHE_GO_MOUNTS"`
 }
 
+// TemplatingConfig configures how deployment descriptors are rendered by deploy and promote.
+type TemplatingConfig struct {
+	// Enabled renders descriptors as Go templates. The templates are executed first and the ${COMMIT},
+	// ${TIMESTAMP} and ${IMAGE} placeholders are replaced afterwards, so their values are never executed as templates.
+	Enabled bool `yaml:"enabled" env:"BUILDTOOLS_TEMPLATING_ENABLED"`
+}
+
+// HelmConfig configures deployment of a Helm chart by deploy and promote.
+type HelmConfig struct {
+	// Chart is the path to the chart, relative to the project directory. Defaults to the k8s directory
+	// which is used as a chart if it contains a Chart.yaml.
+	Chart string `yaml:"chart" env:"BUILDTOOLS_HELM_CHART"`
+}
+
+// ValidationConfig configures offline validation of the rendered descriptors.
+type ValidationConfig struct {
+	// Enabled validates the descriptors before anything is applied by deploy or pushed by promote.
+	Enabled bool `yaml:"enabled" env:"BUILDTOOLS_VALIDATION_ENABLED"`
+	// APIVersionsFor is the version of Kubernetes, i.e. 1.29, in which the apiVersions of built-in kinds must be
+	// served. The apiVersions aren't checked if empty. Fields are always validated against the bundled schemas.
+	APIVersionsFor string `yaml:"apiVersionsFor" env:"BUILDTOOLS_VALIDATION_API_VERSIONS_FOR"`
+	// Schemas is a directory, relative to the project directory, with CustomResourceDefinitions used to
+	// validate custom resources.
+	Schemas string `yaml:"schemas" env:"BUILDTOOLS_VALIDATION_SCHEMAS"`
+}
+
 // ECRCache configures ECR-based layer caching for buildkit builds.
 // This enables remote caching using ECR registry-based cache storage.
 // See: https://aws.amazon.com/blogs/containers/announcing-remote-cache-support-in-amazon-ecr-for-buildkit-clients/
@@ -169,6 +277,64 @@ func (c *ECRCache) extractRegion() string {
 	return ""
 }
 
+// PolicyConfig configures rules the rendered descriptors must follow before they are applied by deploy or pushed
+// by promote. Rules from all configuration files are enforced, so a parent .buildtools.yaml can enforce rules for
+// all projects below it.
+type PolicyConfig struct {
+	// NoLatestTag forbids images without a tag or with the latest tag.
+	NoLatestTag bool `yaml:"noLatestTag" env:"BUILDTOOLS_POLICY_NO_LATEST_TAG"`
+	// Resources requires cpu and memory requests and limits for all containers.
+	Resources bool `yaml:"resources" env:"BUILDTOOLS_POLICY_RESOURCES"`
+	// NoPrivileged forbids privileged containers.
+	NoPrivileged bool `yaml:"noPrivileged" env:"BUILDTOOLS_POLICY_NO_PRIVILEGED"`
+	// RequiredLabels are labels which all objects must have.
+	RequiredLabels []string `yaml:"requiredLabels" env:"BUILDTOOLS_POLICY_REQUIRED_LABELS"`
+	// Rules are custom rules, written as CEL expressions.
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule is a custom rule, the CEL expression is evaluated for each object (available as object) of the
+// given kinds, or all objects if no kinds are given, and must be true for the object to be accepted.
+type PolicyRule struct {
+	Name       string   `yaml:"name"`
+	Kinds      []string `yaml:"kinds,omitempty"`
+	Expression string   `yaml:"expression"`
+	Message    string   `yaml:"message,omitempty"`
+}
+
+// Configured returns true if any rule is enabled
+func (p *PolicyConfig) Configured() bool {
+	return p.NoLatestTag || p.Resources || p.NoPrivileged || len(p.RequiredLabels) > 0 || len(p.Rules) > 0
+}
+
+// NotificationsConfig configures notifications which are sent when deploy or promote has completed.
+type NotificationsConfig struct {
+	// Slack are Slack incoming webhooks.
+	Slack []Notification `yaml:"slack"`
+	// Teams are Microsoft Teams incoming webhooks.
+	Teams []Notification `yaml:"teams"`
+	// Webhooks are generic webhooks, which receive the result as JSON.
+	Webhooks []Notification `yaml:"webhooks"`
+}
+
+// Notification is a webhook to notify, environment variables in the URL and headers are expanded when sending.
+type Notification struct {
+	URL string `yaml:"url"`
+	// Targets limits the notification to deploys and promotes to these targets, all targets if empty.
+	Targets []string `yaml:"targets,omitempty"`
+	// On is when to notify, always (the default), success or failure.
+	On string `yaml:"on,omitempty"`
+	// Message is a Go template for the message, replacing the default one.
+	Message string `yaml:"message,omitempty"`
+	// Headers are added to the request, i.e. for authorization.
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// Configured returns true if any notification is configured
+func (n *NotificationsConfig) Configured() bool {
+	return len(n.Slack) > 0 || len(n.Teams) > 0 || len(n.Webhooks) > 0
+}
+
 const envBuildtoolsContent = "BUILDTOOLS_CONTENT"
 
 func Load(dir string) (*Config, error) {
@@ -228,6 +394,11 @@ func InitEmptyConfig() *Config {
 		Cache: &CacheConfig{
 			ECR: &ECRCache{},
 		},
+		Templating:    &TemplatingConfig{},
+		Helm:          &HelmConfig{},
+		Validation:    &ValidationConfig{},
+		Policy:        &PolicyConfig{},
+		Notifications: &NotificationsConfig{},
 	}
 	c.AvailableCI = []ci.CI{c.CI.Azure, c.CI.Buildkite, c.CI.Gitlab, c.CI.TeamCity, c.CI.Github}
 	c.AvailableRegistries = []registry.Registry{c.Registry.Dockerhub, c.Registry.ACR, c.Registry.ECR, c.Registry.Gitea, c.Registry.Github, c.Registry.Gitlab, c.Registry.Quay, c.Registry.GCR}
@@ -341,6 +512,8 @@ func parseConfig(content []byte, config *Config) error {
 	if err := UnmarshalStrict(content, temp); err != nil {
 		return err
 	} else {
+		mergeTargetVariables(config, temp)
+		mergePolicy(config, temp)
 		if err := mergo.Merge(config, temp); err != nil {
 			return err
 		}
@@ -348,6 +521,59 @@ func parseConfig(content []byte, config *Config) error {
 	}
 }
 
+// mergeTargetVariables adds variables from targets and gitops in parent which are not already set
+// for the same target in config, since mergo doesn't merge fields of structs stored in maps
+func mergeTargetVariables(config, parent *Config) {
+	for name, target := range parent.Targets {
+		if existing, exists := config.Targets[name]; exists {
+			existing.Variables = MergeVariables(existing.Variables, target.Variables)
+			config.Targets[name] = existing
+		}
+	}
+	for name, gitops := range parent.Gitops {
+		if existing, exists := config.Gitops[name]; exists {
+			existing.Variables = MergeVariables(existing.Variables, gitops.Variables)
+			config.Gitops[name] = existing
+		}
+	}
+}
+
+// mergePolicy adds the required labels and rules from parent to config, since mergo doesn't merge slices.
+// Rules in parent replace rules with the same name in config, so that they can't be overridden by a project.
+func mergePolicy(config, parent *Config) {
+	if parent.Policy == nil {
+		return
+	}
+	for _, label := range parent.Policy.RequiredLabels {
+		if !slices.Contains(config.Policy.RequiredLabels, label) {
+			config.Policy.RequiredLabels = append(config.Policy.RequiredLabels, label)
+		}
+	}
+	for _, rule := range parent.Policy.Rules {
+		if i := slices.IndexFunc(config.Policy.Rules, func(r PolicyRule) bool { return r.Name == rule.Name }); i >= 0 {
+			config.Policy.Rules[i] = rule
+		} else {
+			config.Policy.Rules = append(config.Policy.Rules, rule)
+		}
+	}
+}
+
+// MergeVariables returns a new map with all variables in overrides, together with the
+// variables in defaults that are not present in overrides
+func MergeVariables(overrides, defaults map[string]string) map[string]string {
+	if len(overrides) == 0 && len(defaults) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(overrides)+len(defaults))
+	for k, v := range defaults {
+		result[k] = v
+	}
+	for k, v := range overrides {
+		result[k] = v
+	}
+	return result
+}
+
 func UnmarshalStrict(content []byte, out interface{}) error {
 	reader := bytes.NewReader(content)
 	decoder := yaml.NewDecoder(reader)
@@ -371,6 +597,28 @@ func validate(config *Config) error {
 		}
 	}
 
+	if config.Notifications != nil {
+		for _, notifications := range [][]Notification{config.Notifications.Slack, config.Notifications.Teams, config.Notifications.Webhooks} {
+			for _, n := range notifications {
+				if n.URL == "" {
+					return fmt.Errorf("notification url is missing, please check configuration")
+				}
+				if !slices.Contains([]string{"", "always", "success", "failure"}, n.On) {
+					return fmt.Errorf("invalid notification on '%s', must be one of always, success or failure", n.On)
+				}
+			}
+		}
+	}
+
+	if config.Git.PushAttempts < 0 {
+		return fmt.Errorf("invalid git pushAttempts %d, must not be negative", config.Git.PushAttempts)
+	}
+	if config.Git.PushBackoff != "" {
+		if _, err := time.ParseDuration(config.Git.PushBackoff); err != nil {
+			return fmt.Errorf("invalid git pushBackoff '%s': %w", config.Git.PushBackoff, err)
+		}
+	}
+
 	// Validate ECR cache configuration
 	if config.Cache != nil && config.Cache.ECR != nil {
 		if err := config.Cache.ECR.Validate(); err != nil {
@@ -715,3 +715,463 @@ func TestCacheConfig_GoMounts_Default(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, cfg.Cache.GoMounts)
 }
+
+func TestTemplatingConfig_YAML(t *testing.T) {
+	yaml := `
+templating:
+  enabled: true
+`
+	name := filepath.Join(t.TempDir(), ".buildtools.yaml")
+	_ = os.WriteFile(name, []byte(yaml), 0o644)
+
+	cfg, err := Load(filepath.Dir(name))
+	assert.NoError(t, err)
+	assert.True(t, cfg.Templating.Enabled)
+}
+
+func TestTemplatingConfig_Env(t *testing.T) {
+	t.Setenv("BUILDTOOLS_TEMPLATING_ENABLED", "true")
+
+	cfg, err := Load(t.TempDir())
+	assert.NoError(t, err)
+	assert.True(t, cfg.Templating.Enabled)
+}
+
+func TestTemplatingConfig_Default(t *testing.T) {
+	cfg, err := Load(t.TempDir())
+	assert.NoError(t, err)
+	assert.False(t, cfg.Templating.Enabled)
+}
+
+func TestHelmConfig_YAML(t *testing.T) {
+	yaml := `
+helm:
+  chart: deploy/chart
+`
+	name := filepath.Join(t.TempDir(), ".buildtools.yaml")
+	_ = os.WriteFile(name, []byte(yaml), 0o644)
+
+	cfg, err := Load(filepath.Dir(name))
+	assert.NoError(t, err)
+	assert.Equal(t, "deploy/chart", cfg.Helm.Chart)
+}
+
+func TestHelmConfig_Env(t *testing.T) {
+	t.Setenv("BUILDTOOLS_HELM_CHART", "chart")
+
+	cfg, err := Load(t.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, "chart", cfg.Helm.Chart)
+}
+
+func TestValidationConfig_YAML(t *testing.T) {
+	yaml := `
+validation:
+  enabled: true
+  apiVersionsFor: "1.29"
+  schemas: k8s/schemas
+`
+	name := filepath.Join(t.TempDir(), ".buildtools.yaml")
+	_ = os.WriteFile(name, []byte(yaml), 0o644)
+
+	cfg, err := Load(filepath.Dir(name))
+	assert.NoError(t, err)
+	assert.Equal(t, &ValidationConfig{Enabled: true, APIVersionsFor: "1.29", Schemas: "k8s/schemas"}, cfg.Validation)
+}
+
+func TestValidationConfig_Env(t *testing.T) {
+	t.Setenv("BUILDTOOLS_VALIDATION_ENABLED", "true")
+	t.Setenv("BUILDTOOLS_VALIDATION_API_VERSIONS_FOR", "1.30")
+	t.Setenv("BUILDTOOLS_VALIDATION_SCHEMAS", "crds")
+
+	cfg, err := Load(t.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, &ValidationConfig{Enabled: true, APIVersionsFor: "1.30", Schemas: "crds"}, cfg.Validation)
+}
+
+func TestPolicyConfig_YAML(t *testing.T) {
+	yaml := `
+policy:
+  noLatestTag: true
+  resources: true
+  noPrivileged: true
+  requiredLabels:
+    - team
+  rules:
+    - name: replicas
+      kinds:
+        - Deployment
+      expression: object.spec.replicas >= 2
+      message: at least 2 replicas are required
+`
+	name := filepath.Join(t.TempDir(), ".buildtools.yaml")
+	_ = os.WriteFile(name, []byte(yaml), 0o644)
+
+	cfg, err := Load(filepath.Dir(name))
+	assert.NoError(t, err)
+	assert.Equal(t, &PolicyConfig{
+		NoLatestTag:    true,
+		Resources:      true,
+		NoPrivileged:   true,
+		RequiredLabels: []string{"team"},
+		Rules: []PolicyRule{
+			{Name: "replicas", Kinds: []string{"Deployment"}, Expression: "object.spec.replicas >= 2", Message: "at least 2 replicas are required"},
+		},
+	}, cfg.Policy)
+	assert.True(t, cfg.Policy.Configured())
+}
+
+func TestPolicyConfig_Env(t *testing.T) {
+	t.Setenv("BUILDTOOLS_POLICY_NO_LATEST_TAG", "true")
+	t.Setenv("BUILDTOOLS_POLICY_REQUIRED_LABELS", "team,app")
+
+	cfg, err := Load(t.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, &PolicyConfig{NoLatestTag: true, RequiredLabels: []string{"team", "app"}}, cfg.Policy)
+}
+
+func TestPolicyConfig_Default(t *testing.T) {
+	cfg, err := Load(t.TempDir())
+	assert.NoError(t, err)
+	assert.False(t, cfg.Policy.Configured())
+}
+
+func TestLoad_YAML_Policy_DirStructure(t *testing.T) {
+	name := t.TempDir()
+	yaml := `
+policy:
+  noPrivileged: true
+  requiredLabels:
+    - team
+  rules:
+    - name: replicas
+      expression: object.spec.replicas >= 2
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+	subdir := filepath.Join(name, "sub")
+	_ = os.Mkdir(subdir, 0o777)
+	yaml2 := `
+policy:
+  noPrivileged: false
+  noLatestTag: true
+  requiredLabels:
+    - app
+    - team
+  rules:
+    - name: replicas
+      expression: "true"
+    - name: namespace
+      expression: has(object.metadata.namespace)
+`
+	_ = os.WriteFile(filepath.Join(subdir, ".buildtools.yaml"), []byte(yaml2), 0o777)
+
+	cfg, err := Load(subdir)
+	assert.NoError(t, err)
+	assert.Equal(t, &PolicyConfig{
+		NoLatestTag:    true,
+		NoPrivileged:   true,
+		RequiredLabels: []string{"app", "team"},
+		Rules: []PolicyRule{
+			{Name: "replicas", Expression: "object.spec.replicas >= 2"},
+			{Name: "namespace", Expression: "has(object.metadata.namespace)"},
+		},
+	}, cfg.Policy)
+}
+
+func TestNotificationsConfig_YAML(t *testing.T) {
+	yaml := `
+notifications:
+  slack:
+    - url: https://hooks.slack.com/services/T0/B0/abc
+      targets:
+        - prod
+      on: failure
+      message: "{{ .App }} failed"
+  teams:
+    - url: https://example.webhook.office.com/webhookb2/abc
+  webhooks:
+    - url: https://example.org/deploys
+      headers:
+        Authorization: Bearer $TOKEN
+`
+	name := filepath.Join(t.TempDir(), ".buildtools.yaml")
+	_ = os.WriteFile(name, []byte(yaml), 0o644)
+
+	cfg, err := Load(filepath.Dir(name))
+	assert.NoError(t, err)
+	assert.Equal(t, &NotificationsConfig{
+		Slack:    []Notification{{URL: "https://hooks.slack.com/services/T0/B0/abc", Targets: []string{"prod"}, On: "failure", Message: "{{ .App }} failed"}},
+		Teams:    []Notification{{URL: "https://example.webhook.office.com/webhookb2/abc"}},
+		Webhooks: []Notification{{URL: "https://example.org/deploys", Headers: map[string]string{"Authorization": "Bearer $TOKEN"}}},
+	}, cfg.Notifications)
+	assert.True(t, cfg.Notifications.Configured())
+}
+
+func TestNotificationsConfig_Default(t *testing.T) {
+	cfg, err := Load(t.TempDir())
+	assert.NoError(t, err)
+	assert.False(t, cfg.Notifications.Configured())
+}
+
+func TestNotificationsConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name:    "missing url",
+			yaml:    "notifications:\n  slack:\n    - on: failure\n",
+			wantErr: "notification url is missing, please check configuration",
+		},
+		{
+			name:    "invalid on",
+			yaml:    "notifications:\n  webhooks:\n    - url: https://example.org\n      on: sometimes\n",
+			wantErr: "invalid notification on 'sometimes', must be one of always, success or failure",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), ".buildtools.yaml")
+			_ = os.WriteFile(name, []byte(tt.yaml), 0o644)
+
+			_, err := Load(filepath.Dir(name))
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestLoad_YAML_Notifications_DirStructure(t *testing.T) {
+	name := t.TempDir()
+	yaml := `
+notifications:
+  slack:
+    - url: https://hooks.slack.com/services/T0/B0/parent
+  webhooks:
+    - url: https://example.org/deploys
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+	subdir := filepath.Join(name, "sub")
+	_ = os.Mkdir(subdir, 0o777)
+	yaml2 := `
+notifications:
+  slack:
+    - url: https://hooks.slack.com/services/T0/B0/project
+`
+	_ = os.WriteFile(filepath.Join(subdir, ".buildtools.yaml"), []byte(yaml2), 0o777)
+
+	cfg, err := Load(subdir)
+	assert.NoError(t, err)
+	assert.Equal(t, &NotificationsConfig{
+		Slack:    []Notification{{URL: "https://hooks.slack.com/services/T0/B0/project"}},
+		Webhooks: []Notification{{URL: "https://example.org/deploys"}},
+	}, cfg.Notifications)
+}
+
+func TestLoad_YAML_GitAuth(t *testing.T) {
+	yaml := `
+git:
+  name: Buildtools
+  email: git@example.org
+  agent: true
+  knownHosts: ~/.ssh/gitops_known_hosts
+  user: deployer
+  token: $GITOPS_TOKEN
+  pushAttempts: 10
+  pushBackoff: 500ms
+  shallow: true
+  cacheDir: ~/.cache/gitops
+`
+	name := filepath.Join(t.TempDir(), ".buildtools.yaml")
+	_ = os.WriteFile(name, []byte(yaml), 0o644)
+
+	cfg, err := Load(filepath.Dir(name))
+	assert.NoError(t, err)
+	assert.Equal(t, Git{
+		Name:         "Buildtools",
+		Email:        "git@example.org",
+		Agent:        true,
+		KnownHosts:   "~/.ssh/gitops_known_hosts",
+		User:         "deployer",
+		Token:        "$GITOPS_TOKEN",
+		PushAttempts: 10,
+		PushBackoff:  "500ms",
+		Shallow:      true,
+		CacheDir:     "~/.cache/gitops",
+	}, cfg.Git)
+}
+
+func TestGitConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name:    "negative push attempts",
+			yaml:    "git:\n  pushAttempts: -1\n",
+			wantErr: "invalid git pushAttempts -1, must not be negative",
+		},
+		{
+			name:    "invalid push backoff",
+			yaml:    "git:\n  pushBackoff: soon\n",
+			wantErr: `invalid git pushBackoff 'soon': time: invalid duration "soon"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), ".buildtools.yaml")
+			_ = os.WriteFile(name, []byte(tt.yaml), 0o644)
+
+			_, err := Load(filepath.Dir(name))
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestLoad_YAML_GitopsLayout(t *testing.T) {
+	yaml := `
+gitops:
+  prod:
+    url: git@github.com:org/gitops.git
+    branch: release
+    file: "{{ .Target }}/{{ .App }}/manifests.yaml"
+    split: true
+`
+	name := filepath.Join(t.TempDir(), ".buildtools.yaml")
+	_ = os.WriteFile(name, []byte(yaml), 0o644)
+
+	cfg, err := Load(filepath.Dir(name))
+	assert.NoError(t, err)
+	assert.Equal(t, Gitops{
+		URL:    "git@github.com:org/gitops.git",
+		Branch: "release",
+		File:   "{{ .Target }}/{{ .App }}/manifests.yaml",
+		Split:  true,
+	}, cfg.Gitops["prod"])
+}
+
+func TestLoad_YAML_GitopsPullRequest(t *testing.T) {
+	yaml := `
+gitops:
+  prod:
+    url: git@git.example.org:org/gitops.git
+    pullRequest:
+      provider: gitea
+      api: https://git.example.org/api/v1
+      token: $PROMOTE_TOKEN
+      base: main
+      title: "Release {{ .App }}"
+  test:
+    url: git@github.com:org/gitops.git
+`
+	name := filepath.Join(t.TempDir(), ".buildtools.yaml")
+	_ = os.WriteFile(name, []byte(yaml), 0o644)
+
+	cfg, err := Load(filepath.Dir(name))
+	assert.NoError(t, err)
+	assert.Equal(t, &PullRequest{Provider: "gitea", API: "https://git.example.org/api/v1", Token: "$PROMOTE_TOKEN", Base: "main", Title: "Release {{ .App }}"}, cfg.Gitops["prod"].PullRequest)
+	assert.Nil(t, cfg.Gitops["test"].PullRequest)
+}
+
+func TestLoad_YAML_TargetOptions(t *testing.T) {
+	yaml := `
+targets:
+  prod:
+    context: abc
+    rollbackOnFailure: true
+    prune: true
+    native: true
+    pinDigest: true
+    verifyImage: true
+    hooks:
+      preApply:
+        - ./migrate.sh
+      onFailure:
+        - ./notify.sh failed
+    strategy: canary
+    clusters:
+      - name: canary
+        context: eu-canary
+      - context: eu-west
+        namespace: eu
+        kubeconfig: /tmp/eu
+  test:
+    context: def
+`
+	name := filepath.Join(t.TempDir(), ".buildtools.yaml")
+	_ = os.WriteFile(name, []byte(yaml), 0o644)
+
+	cfg, err := Load(filepath.Dir(name))
+	assert.NoError(t, err)
+	assert.True(t, cfg.Targets["prod"].RollbackOnFailure)
+	assert.False(t, cfg.Targets["test"].RollbackOnFailure)
+	assert.True(t, cfg.Targets["prod"].Prune)
+	assert.False(t, cfg.Targets["test"].Prune)
+	assert.True(t, cfg.Targets["prod"].Native)
+	assert.False(t, cfg.Targets["test"].Native)
+	assert.True(t, cfg.Targets["prod"].PinDigest)
+	assert.False(t, cfg.Targets["test"].PinDigest)
+	assert.True(t, cfg.Targets["prod"].VerifyImage)
+	assert.False(t, cfg.Targets["test"].VerifyImage)
+	assert.Equal(t, Hooks{PreApply: []string{"./migrate.sh"}, OnFailure: []string{"./notify.sh failed"}}, cfg.Targets["prod"].Hooks)
+	assert.Equal(t, Hooks{}, cfg.Targets["test"].Hooks)
+	assert.Equal(t, "canary", cfg.Targets["prod"].Strategy)
+	assert.Equal(t, []Cluster{
+		{Name: "canary", Context: "eu-canary"},
+		{Context: "eu-west", Namespace: "eu", Kubeconfig: "/tmp/eu"},
+	}, cfg.Targets["prod"].Clusters)
+	assert.Empty(t, cfg.Targets["test"].Clusters)
+}
+
+func TestLoad_YAML_Variables_DirStructure(t *testing.T) {
+	name := t.TempDir()
+	yaml := `
+targets:
+  test:
+    context: abc
+    variables:
+      REPLICAS: "1"
+      HOST: test.example.org
+  prod:
+    context: def
+    variables:
+      REPLICAS: "3"
+gitops:
+  test:
+    url: git@example.org:test/gitops.git
+    variables:
+      REPLICAS: "1"
+      HOST: test.example.org
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+	subdir := filepath.Join(name, "sub")
+	_ = os.Mkdir(subdir, 0o777)
+	yaml2 := `
+targets:
+  test:
+    context: ghi
+    variables:
+      HOST: sub.example.org
+  prod:
+    context: jkl
+gitops:
+  test:
+    url: git@example.org:test/gitops.git
+    variables:
+      EXTRA: value
+`
+	_ = os.WriteFile(filepath.Join(subdir, ".buildtools.yaml"), []byte(yaml2), 0o777)
+
+	cfg, err := Load(subdir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"REPLICAS": "1", "HOST": "sub.example.org"}, cfg.Targets["test"].Variables)
+	assert.Equal(t, map[string]string{"REPLICAS": "3"}, cfg.Targets["prod"].Variables)
+	assert.Equal(t, map[string]string{"REPLICAS": "1", "HOST": "test.example.org", "EXTRA": "value"}, cfg.Gitops["test"].Variables)
+}
+
+func TestMergeVariables(t *testing.T) {
+	assert.Nil(t, MergeVariables(nil, nil))
+	assert.Equal(t, map[string]string{"A": "1"}, MergeVariables(nil, map[string]string{"A": "1"}))
+	assert.Equal(t, map[string]string{"A": "2", "B": "1"}, MergeVariables(map[string]string{"A": "2"}, map[string]string{"A": "1", "B": "1"}))
+}
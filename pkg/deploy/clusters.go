@@ -0,0 +1,192 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+const (
+	// strategySequential deploys to one cluster at a time in the configured order, stopping at the first failure
+	strategySequential = "sequential"
+	// strategyParallel deploys to all clusters at the same time
+	strategyParallel = "parallel"
+	// strategyCanary deploys to the first cluster, and then to the rest in parallel if it succeeded
+	strategyCanary = "canary"
+)
+
+// cluster is one of the clusters a target is deployed to
+type cluster struct {
+	name   string
+	target *config.Target
+}
+
+// clusterResult is the outcome of deploying to a cluster
+type clusterResult struct {
+	cluster string
+	err     error
+	skipped bool
+}
+
+// clustersFor returns a target for each cluster configured for env, where context, namespace and kubeconfig
+// default to the values of env
+func clustersFor(env *config.Target) ([]cluster, error) {
+	var clusters []cluster
+	names := map[string]bool{}
+	for _, c := range env.Clusters {
+		target := *env
+		target.Clusters = nil
+		if c.Context != "" {
+			target.Context = c.Context
+		}
+		if c.Namespace != "" {
+			target.Namespace = c.Namespace
+		}
+		if c.Kubeconfig != "" {
+			target.Kubeconfig = c.Kubeconfig
+		}
+		name := c.Name
+		if name == "" {
+			name = target.Context
+		}
+		if target.Context == "" {
+			return nil, fmt.Errorf("context is mandatory for cluster '%s'", name)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("cluster '%s' is configured more than once", name)
+		}
+		names[name] = true
+		if target.Context == "in-cluster" {
+			target.Context = ""
+		}
+		clusters = append(clusters, cluster{name: name, target: &target})
+	}
+	return clusters, nil
+}
+
+// deployClusters deploys to clusters using strategy and returns the result for each cluster in the configured order
+func deployClusters(clusters []cluster, strategy string, deploy func(cluster) error) ([]clusterResult, error) {
+	switch strategy {
+	case "", strategySequential:
+		results := make([]clusterResult, len(clusters))
+		failed := false
+		for i, c := range clusters {
+			results[i] = clusterResult{cluster: c.name, skipped: failed}
+			if !failed {
+				results[i].err = deploy(c)
+				failed = results[i].err != nil
+			}
+		}
+		return results, nil
+	case strategyParallel:
+		return deployParallel(clusters, deploy), nil
+	case strategyCanary:
+		if len(clusters) == 0 {
+			return nil, nil
+		}
+		canary := clusterResult{cluster: clusters[0].name, err: deploy(clusters[0])}
+		if canary.err != nil {
+			results := []clusterResult{canary}
+			for _, c := range clusters[1:] {
+				results = append(results, clusterResult{cluster: c.name, skipped: true})
+			}
+			return results, nil
+		}
+		return append([]clusterResult{canary}, deployParallel(clusters[1:], deploy)...), nil
+	}
+	return nil, fmt.Errorf("unknown strategy '%s', must be one of %s, %s or %s", strategy, strategySequential, strategyParallel, strategyCanary)
+}
+
+func deployParallel(clusters []cluster, deploy func(cluster) error) []clusterResult {
+	results := make([]clusterResult, len(clusters))
+	var wg sync.WaitGroup
+	for i, c := range clusters {
+		wg.Add(1)
+		go func(i int, c cluster) {
+			defer wg.Done()
+			results[i] = clusterResult{cluster: c.name, err: deploy(c)}
+		}(i, c)
+	}
+	wg.Wait()
+	return results
+}
+
+// report logs the result for each cluster and returns an error if the deploy failed for any of them
+func report(results []clusterResult) error {
+	var errs []error
+	for _, result := range results {
+		switch {
+		case result.skipped:
+			log.Warnf("Deploy to cluster <yellow>%s</yellow> skipped\n", result.cluster)
+		case result.err != nil:
+			log.Errorf("Deploy to cluster <red>%s</red> failed: %v\n", result.cluster, result.err)
+			errs = append(errs, fmt.Errorf("%s: %w", result.cluster, result.err))
+		default:
+			log.Infof("Deploy to cluster <green>%s</green> succeeded\n", result.cluster)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &clustersError{errs: errs, total: len(results)}
+}
+
+// clustersError is returned when the deploy failed for one or more clusters
+type clustersError struct {
+	errs  []error
+	total int
+}
+
+func (e *clustersError) Error() string {
+	return fmt.Sprintf("deploy failed for %d of %d clusters", len(e.errs), e.total)
+}
+
+// Is reports differences only if they are the reason the deploy failed for all clusters
+func (e *clustersError) Is(target error) bool {
+	if target != ErrDifferences {
+		return false
+	}
+	for _, err := range e.errs {
+		if !errors.Is(err, ErrDifferences) {
+			return false
+		}
+	}
+	return true
+}
+
+// clusterFile adds the name of the cluster to a file name, i.e. diagnostics.json becomes diagnostics-eu.json
+func clusterFile(name, clusterName string) string {
+	if name == "" {
+		return ""
+	}
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(name, ext), clusterName, ext)
+}
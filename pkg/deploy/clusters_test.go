@@ -0,0 +1,391 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+func TestClustersFor(t *testing.T) {
+	env := &config.Target{
+		Context:    "default",
+		Namespace:  "apps",
+		Kubeconfig: "/tmp/kubeconfig",
+		Native:     true,
+		Clusters: []config.Cluster{
+			{Name: "canary", Context: "eu-canary"},
+			{Context: "eu-west", Namespace: "eu"},
+			{Name: "us", Context: "us-east", Kubeconfig: "/tmp/us"},
+			{Name: "local", Context: "in-cluster"},
+		},
+	}
+
+	clusters, err := clustersFor(env)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []cluster{
+		{name: "canary", target: &config.Target{Context: "eu-canary", Namespace: "apps", Kubeconfig: "/tmp/kubeconfig", Native: true}},
+		{name: "eu-west", target: &config.Target{Context: "eu-west", Namespace: "eu", Kubeconfig: "/tmp/kubeconfig", Native: true}},
+		{name: "us", target: &config.Target{Context: "us-east", Namespace: "apps", Kubeconfig: "/tmp/us", Native: true}},
+		{name: "local", target: &config.Target{Context: "", Namespace: "apps", Kubeconfig: "/tmp/kubeconfig", Native: true}},
+	}, clusters)
+}
+
+func TestClustersFor_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		clusters []config.Cluster
+		wantErr  string
+	}{
+		{
+			name:     "missing context",
+			clusters: []config.Cluster{{Name: "eu"}},
+			wantErr:  "context is mandatory for cluster 'eu'",
+		},
+		{
+			name:     "duplicate name",
+			clusters: []config.Cluster{{Context: "eu"}, {Name: "eu", Context: "eu-west"}},
+			wantErr:  "cluster 'eu' is configured more than once",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := clustersFor(&config.Target{Clusters: tt.clusters})
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func testClusters(names ...string) []cluster {
+	var clusters []cluster
+	for _, name := range names {
+		clusters = append(clusters, cluster{name: name, target: &config.Target{Context: name}})
+	}
+	return clusters
+}
+
+type recorder struct {
+	sync.Mutex
+	deployed []string
+	failing  map[string]error
+}
+
+func (r *recorder) deploy(c cluster) error {
+	r.Lock()
+	defer r.Unlock()
+	r.deployed = append(r.deployed, c.name)
+	return r.failing[c.name]
+}
+
+func TestDeployClusters(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		name         string
+		strategy     string
+		failing      map[string]error
+		wantDeployed []string
+		want         []clusterResult
+	}{
+		{
+			name:         "sequential",
+			strategy:     "",
+			wantDeployed: []string{"a", "b", "c"},
+			want:         []clusterResult{{cluster: "a"}, {cluster: "b"}, {cluster: "c"}},
+		},
+		{
+			name:         "sequential stops at first failure",
+			strategy:     "sequential",
+			failing:      map[string]error{"b": failed},
+			wantDeployed: []string{"a", "b"},
+			want:         []clusterResult{{cluster: "a"}, {cluster: "b", err: failed}, {cluster: "c", skipped: true}},
+		},
+		{
+			name:         "parallel",
+			strategy:     "parallel",
+			failing:      map[string]error{"a": failed},
+			wantDeployed: []string{"a", "b", "c"},
+			want:         []clusterResult{{cluster: "a", err: failed}, {cluster: "b"}, {cluster: "c"}},
+		},
+		{
+			name:         "canary",
+			strategy:     "canary",
+			failing:      map[string]error{"c": failed},
+			wantDeployed: []string{"a", "b", "c"},
+			want:         []clusterResult{{cluster: "a"}, {cluster: "b"}, {cluster: "c", err: failed}},
+		},
+		{
+			name:         "canary fails",
+			strategy:     "canary",
+			failing:      map[string]error{"a": failed},
+			wantDeployed: []string{"a"},
+			want:         []clusterResult{{cluster: "a", err: failed}, {cluster: "b", skipped: true}, {cluster: "c", skipped: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{failing: tt.failing}
+			results, err := deployClusters(testClusters("a", "b", "c"), tt.strategy, r.deploy)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, results)
+			assert.ElementsMatch(t, tt.wantDeployed, r.deployed)
+			if tt.strategy == "canary" {
+				assert.Equal(t, "a", r.deployed[0])
+			}
+		})
+	}
+}
+
+func TestDeployClusters_UnknownStrategy(t *testing.T) {
+	r := &recorder{}
+	_, err := deployClusters(testClusters("a"), "random", r.deploy)
+	assert.EqualError(t, err, "unknown strategy 'random', must be one of sequential, parallel or canary")
+	assert.Empty(t, r.deployed)
+}
+
+func TestReport(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+
+	err := report([]clusterResult{
+		{cluster: "a"},
+		{cluster: "b", err: fmt.Errorf("diff: %w", ErrDifferences)},
+		{cluster: "c", err: errors.New("failed to rollout deployment/image")},
+		{cluster: "d", skipped: true},
+	})
+
+	assert.EqualError(t, err, "deploy failed for 2 of 4 clusters")
+	assert.False(t, errors.Is(err, ErrDifferences))
+	logMock.Check(t, []string{
+		"info: Deploy to cluster <green>a</green> succeeded\n",
+		"error: Deploy to cluster <red>b</red> failed: diff: differences found between deployment descriptors and live objects\n",
+		"error: Deploy to cluster <red>c</red> failed: failed to rollout deployment/image\n",
+		"warn: Deploy to cluster <yellow>d</yellow> skipped\n",
+	})
+}
+
+func TestReport_OnlyDifferences(t *testing.T) {
+	log.SetHandler(mocks.New())
+
+	err := report([]clusterResult{
+		{cluster: "a", err: ErrDifferences},
+		{cluster: "b", err: ErrDifferences},
+	})
+
+	assert.True(t, errors.Is(err, ErrDifferences))
+}
+
+func TestReport_Success(t *testing.T) {
+	log.SetHandler(mocks.New())
+
+	assert.NoError(t, report([]clusterResult{{cluster: "a"}, {cluster: "b"}}))
+}
+
+func TestClusterFile(t *testing.T) {
+	assert.Equal(t, "", clusterFile("", "eu"))
+	assert.Equal(t, "out/diagnostics-eu.json", clusterFile("out/diagnostics.json", "eu"))
+	assert.Equal(t, "diagnostics-eu", clusterFile("diagnostics", "eu"))
+}
+
+func TestDeployToClusters_ParallelKubectl(t *testing.T) {
+	ready := `{"observedGeneration":1,"replicas":1,"updatedReplicas":1,"availableReplicas":1}`
+	failed := `{"observedGeneration":1,"replicas":1,"conditions":[{"type":"Progressing","status":"False","reason":"ProgressDeadlineExceeded"}]}`
+	var servers []string
+	for _, status := range []string{ready, failed, ready, failed} {
+		server := httptest.NewServer(apiServer(status))
+		t.Cleanup(server.Close)
+		servers = append(servers, server.URL)
+	}
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	_ = os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- {name: ok-1, cluster: {server: %[1]s}}
+- {name: failing-1, cluster: {server: %[2]s}}
+- {name: ok-2, cluster: {server: %[3]s}}
+- {name: failing-2, cluster: {server: %[4]s}}
+contexts:
+- {name: ok-1, context: {cluster: ok-1, namespace: default}}
+- {name: failing-1, context: {cluster: failing-1, namespace: default}}
+- {name: ok-2, context: {cluster: ok-2, namespace: default}}
+- {name: failing-2, context: {cluster: failing-2, namespace: default}}
+users: []
+`, servers[0], servers[1], servers[2], servers[3])), 0o666)
+	dir := t.TempDir()
+	_ = os.Mkdir(filepath.Join(dir, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(dir, "k8s", "deploy.yaml"), []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  selector:
+    matchLabels:
+      app: app
+  template:
+    metadata:
+      labels:
+        app: app
+    spec:
+      containers:
+      - name: app
+        image: ${IMAGE}
+`), 0o666)
+	env := &config.Target{
+		Kubeconfig: kubeconfig,
+		Strategy:   strategyParallel,
+		Clusters: []config.Cluster{
+			{Context: "ok-1"}, {Context: "failing-1"}, {Context: "ok-2"}, {Context: "failing-2"},
+		},
+	}
+
+	log.SetHandler(mocks.New())
+	result := newResult("prod")
+	err := deployToClusters(dir, "registry", "app", "2019-05-13T17:22:36Z01:00", env, Args{
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "10s",
+		result:  result,
+	})
+
+	assert.EqualError(t, err, "deploy failed for 2 of 4 clusters")
+	var statuses []string
+	for _, c := range result.Clusters {
+		statuses = append(statuses, fmt.Sprintf("%s: %s", c.Cluster, c.Status))
+	}
+	assert.Equal(t, []string{"ok-1: succeeded", "failing-1: failed", "ok-2: succeeded", "failing-2: failed"}, statuses)
+}
+
+func TestDeployToClusters_ParallelKubectlRolloutsOverlap(t *testing.T) {
+	// the deployments only become ready once the rollouts in both clusters have been checked, which never happens
+	// unless the rollouts are waited for at the same time
+	var polled [2]atomic.Bool
+	var servers []string
+	for i := range polled {
+		server := httptest.NewServer(rolloutServer(func() string {
+			polled[i].Store(true)
+			if polled[0].Load() && polled[1].Load() {
+				return `{"observedGeneration":1,"replicas":1,"updatedReplicas":1,"availableReplicas":1}`
+			}
+			return `{"observedGeneration":1,"replicas":1,"updatedReplicas":0}`
+		}))
+		t.Cleanup(server.Close)
+		servers = append(servers, server.URL)
+	}
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	_ = os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- {name: eu, cluster: {server: %[1]s}}
+- {name: us, cluster: {server: %[2]s}}
+contexts:
+- {name: eu, context: {cluster: eu, namespace: default}}
+- {name: us, context: {cluster: us, namespace: default}}
+users: []
+`, servers[0], servers[1])), 0o666)
+	dir := t.TempDir()
+	_ = os.Mkdir(filepath.Join(dir, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(dir, "k8s", "deploy.yaml"), []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n"), 0o666)
+	env := &config.Target{
+		Kubeconfig: kubeconfig,
+		Strategy:   strategyParallel,
+		Clusters:   []config.Cluster{{Context: "eu"}, {Context: "us"}},
+	}
+
+	log.SetHandler(mocks.New())
+	result := newResult("prod")
+	err := deployToClusters(dir, "registry", "app", "2019-05-13T17:22:36Z01:00", env, Args{
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "10s",
+		result:  result,
+	})
+
+	assert.NoError(t, err)
+	var statuses []string
+	for _, c := range result.Clusters {
+		statuses = append(statuses, fmt.Sprintf("%s: %s", c.Cluster, c.Status))
+	}
+	assert.Equal(t, []string{"eu: succeeded", "us: succeeded"}, statuses)
+}
+
+// apiServer is a minimal Kubernetes API server for a Deployment named app with the given status
+func apiServer(status string) http.Handler {
+	return rolloutServer(func() string { return status })
+}
+
+// rolloutServer is a minimal Kubernetes API server for a Deployment named app, with the status returned by status
+// each time the deployment is read
+func rolloutServer(status func() string) http.Handler {
+	deployment := func(r *http.Request) string {
+		current := `{"observedGeneration":1}`
+		if r.Method == http.MethodGet {
+			current = status()
+		}
+		return fmt.Sprintf(`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"app","namespace":"default","generation":1,"resourceVersion":"1"},"status":%s}`, current)
+	}
+	mux := http.NewServeMux()
+	respond := func(path, body string) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprint(w, body)
+		})
+	}
+	respond("/api", `{"kind":"APIVersions","versions":["v1"]}`)
+	respond("/apis", `{"kind":"APIGroupList","groups":[{"name":"apps","versions":[{"groupVersion":"apps/v1","version":"v1"}],"preferredVersion":{"groupVersion":"apps/v1","version":"v1"}}]}`)
+	respond("/api/v1", `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"pods","namespaced":true,"kind":"Pod","verbs":["get","list"]},{"name":"events","namespaced":true,"kind":"Event","verbs":["get","list"]}]}`)
+	respond("/apis/apps/v1", `{"kind":"APIResourceList","groupVersion":"apps/v1","resources":[{"name":"deployments","namespaced":true,"kind":"Deployment","verbs":["get","list","watch","patch"]}]}`)
+	// the openapi spec is only used to check that server side field validation is supported
+	respond("/openapi/v3", `{"paths":{"apis/apps/v1":{"serverRelativeURL":"/openapi/v3/apis/apps/v1"}}}`)
+	respond("/openapi/v3/apis/apps/v1", `{"openapi":"3.0.0","paths":{"/apis/apps/v1/namespaces/{namespace}/deployments/{name}":{"patch":{"x-kubernetes-group-version-kind":{"group":"apps","version":"v1","kind":"Deployment"},"parameters":[{"name":"fieldValidation","in":"query"}]}}}}`)
+	respond("/api/v1/namespaces/default/pods", `{"kind":"PodList","apiVersion":"v1","items":[]}`)
+	respond("/api/v1/namespaces/default/events", `{"kind":"EventList","apiVersion":"v1","items":[]}`)
+	mux.HandleFunc("/apis/apps/v1/namespaces/default/deployments/app", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, deployment(r))
+	})
+	mux.HandleFunc("/apis/apps/v1/namespaces/default/deployments", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("watch") == "true" {
+			// the initial events of a watch list, the deployment followed by a bookmark
+			_, _ = fmt.Fprintf(w, "{\"type\":\"ADDED\",\"object\":%s}\n", deployment(r))
+			_, _ = fmt.Fprint(w, `{"type":"BOOKMARK","object":{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"resourceVersion":"1","annotations":{"k8s.io/initial-events-end":"true"}}}}`+"\n")
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"kind":"DeploymentList","apiVersion":"apps/v1","metadata":{"resourceVersion":"1"},"items":[%s]}`, deployment(r))
+	})
+	return mux
+}
@@ -23,37 +23,63 @@
 package deploy
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/apex/log"
 
-	"github.com/buildtool/build-tools/pkg/file"
-
 	"github.com/buildtool/build-tools/pkg/args"
 	"github.com/buildtool/build-tools/pkg/ci"
 	"github.com/buildtool/build-tools/pkg/cli"
 	"github.com/buildtool/build-tools/pkg/config"
 	"github.com/buildtool/build-tools/pkg/kubectl"
+	"github.com/buildtool/build-tools/pkg/policy"
+	"github.com/buildtool/build-tools/pkg/registry"
+	"github.com/buildtool/build-tools/pkg/render"
+	"github.com/buildtool/build-tools/pkg/schema"
+	"github.com/buildtool/build-tools/pkg/templating"
 	"github.com/buildtool/build-tools/pkg/version"
 )
 
 type Args struct {
 	args.Globals
-	Target    string `arg:"" name:"target" help:"the target in the .buildtools.yaml"`
-	Context   string `name:"context" short:"c" help:"override the context for default deployment target" default:""`
-	Namespace string `name:"namespace" short:"n" help:"override the namespace for default deployment target" default:""`
-	Tag       string `name:"tag" help:"override the tag to deploy, not using the CI or VCS evaluated value" default:""`
-	Timeout   string `name:"timeout" short:"t" help:"override the default deployment timeout (2 minutes). 0 means forever, all other values should contain a corresponding time unit (e.g. 1s, 2m, 3h)" default:"2m"`
-	NoWait    bool   `name:"no-wait" help:"don't wait for deployment to become ready"`
+	Target            string            `arg:"" name:"target" help:"the target in the .buildtools.yaml"`
+	Context           string            `name:"context" short:"c" help:"override the context for default deployment target" default:""`
+	Namespace         string            `name:"namespace" short:"n" help:"override the namespace for default deployment target" default:""`
+	Tag               string            `name:"tag" help:"override the tag to deploy, not using the CI or VCS evaluated value" default:""`
+	Timeout           string            `name:"timeout" short:"t" help:"override the default deployment timeout (2 minutes). 0 means forever, all other values should contain a corresponding time unit (e.g. 1s, 2m, 3h)" default:"2m"`
+	NoWait            bool              `name:"no-wait" help:"don't wait for deployment to become ready"`
+	RollbackOnFailure bool              `name:"rollback-on-failure" help:"roll back applied objects to their previous versions if the rollout fails"`
+	Prune             bool              `name:"prune" help:"delete objects from earlier deploys of the target which are no longer in the deployment descriptors"`
+	PruneDryRun       bool              `name:"prune-dry-run" help:"list objects which would be pruned without deleting them"`
+	DryRun            string            `name:"dry-run" enum:"none,client,server" help:"only validate the deployment descriptors, without applying them (none, client or server)" default:"none"`
+	Diff              bool              `name:"diff" help:"show differences between the deployment descriptors and the live objects using a server side dry run, exits with an error if there are any"`
+	Variables         map[string]string `name:"var" help:"set a variable (KEY=VALUE) for substitution in deployment descriptors, overriding the target configuration"`
+	DiagnosticsFile   string            `name:"diagnostics-file" help:"write diagnostics for failed rollouts as JSON to this file"`
+	Validate          bool              `name:"validate" help:"validate the deployment descriptors against the Kubernetes schemas before applying them"`
+	PinDigest         bool              `name:"pin-digest" help:"resolve the tag to its digest in the registry and reference the image by digest"`
+	VerifyImage       bool              `name:"verify-image" help:"check that the image exists in the registry before deploying"`
+	Output            string            `name:"output" enum:"text,json" help:"output format, json writes the result of the deploy to stdout and logs to stderr (text or json)" default:"text"`
+	templating        bool
+	chart             string
+	hooks             config.Hooks
+	validator         *schema.Validator
+	checker           *policy.Checker
+	image             string
+	// result is collected by DoDeploy, for the json output and notifications
+	result *Result
+	// outcome is where Deploy records what happened if set
+	outcome *Outcome
 }
 
-func DoDeploy(dir string, info version.Info, osArgs ...string) int {
+func DoDeploy(dir string, info version.Info, osArgs ...string) (code int) {
 	var deployArgs Args
 	err := args.ParseArgs(dir, osArgs, info, &deployArgs)
 	if err != nil {
@@ -63,9 +89,20 @@ func DoDeploy(dir string, info version.Info, osArgs ...string) int {
 			return 0
 		}
 	}
+	result := newResult(deployArgs.Target)
+	deployArgs.result = result
+	if deployArgs.Output == "json" {
+		cli.Redirect(log.Log, os.Stderr)
+		defer func() {
+			if err := result.write(code); err != nil {
+				log.Errorf("Failed to write result: %v\n", err)
+			}
+		}()
+	}
 
 	if cfg, err := config.Load(dir); err != nil {
 		log.Error(err.Error())
+		result.failed(err)
 		return -1
 	} else {
 		var env *config.Target
@@ -73,11 +110,13 @@ func DoDeploy(dir string, info version.Info, osArgs ...string) int {
 			log.Warnf("%v\n", err)
 			env = &config.Target{}
 		}
+		clusters := len(env.Clusters) > 0 && deployArgs.Context == ""
 		if deployArgs.Context != "" {
 			env.Context = deployArgs.Context
 		}
-		if env.Context == "" {
+		if env.Context == "" && !clusters {
 			log.Errorf("context is mandatory, not found in configuration for %s and not passed as parameter\n", deployArgs.Target)
+			result.failed(fmt.Errorf("context is mandatory, not found in configuration for %s and not passed as parameter", deployArgs.Target))
 			return -5
 		}
 		if env.Context == "in-cluster" {
@@ -86,11 +125,20 @@ func DoDeploy(dir string, info version.Info, osArgs ...string) int {
 		}
 		if deployArgs.Namespace != "" {
 			env.Namespace = deployArgs.Namespace
+			for i := range env.Clusters {
+				env.Clusters[i].Namespace = deployArgs.Namespace
+			}
 		}
 		currentCI := cfg.CurrentCI()
+		if cfg.Notifications.Configured() {
+			defer func() {
+				notifyCompleted(cfg.Notifications, currentCI, result, code)
+			}()
+		}
 		if deployArgs.Tag == "" {
 			if !ci.IsValid(currentCI) {
 				log.Errorf("Commit and/or branch information is <red>missing</red>. Perhaps your not in a Git repository or forgot to set environment variables?")
+				result.failed(errors.New("commit and/or branch information is missing"))
 				return -3
 			}
 			deployArgs.Tag = currentCI.Commit()
@@ -98,90 +146,493 @@ func DoDeploy(dir string, info version.Info, osArgs ...string) int {
 			log.Infof("Using passed tag <green>%s</green> to deploy", deployArgs.Tag)
 		}
 
+		deployArgs.Variables = config.MergeVariables(deployArgs.Variables, env.Variables)
+		deployArgs.Context = env.Context
+		deployArgs.Namespace = env.Namespace
+		deployArgs.RollbackOnFailure = deployArgs.RollbackOnFailure || env.RollbackOnFailure
+		deployArgs.Prune = deployArgs.Prune || env.Prune
+		deployArgs.templating = cfg.Templating.Enabled
+		deployArgs.chart = cfg.Helm.Chart
+		deployArgs.hooks = env.Hooks
+		if deployArgs.Validate || cfg.Validation.Enabled {
+			if deployArgs.validator, err = schema.NewFromConfig(dir, cfg.Validation); err != nil {
+				log.Error(err.Error())
+				result.failed(err)
+				return -1
+			}
+		}
+		if cfg.Policy.Configured() {
+			if deployArgs.checker, err = policy.New(cfg.Policy); err != nil {
+				log.Error(err.Error())
+				result.failed(err)
+				return -1
+			}
+		}
+
 		tstamp := time.Now().Format(time.RFC3339)
-		client := kubectl.New(env)
-		defer client.Cleanup()
-		if err := Deploy(dir, cfg.CurrentRegistry().RegistryUrl(), currentCI.BuildName(), tstamp, client, deployArgs); err != nil {
+		registryUrl := cfg.CurrentRegistry().RegistryUrl()
+		result.Image = deployImage(registryUrl, currentCI.BuildName(), deployArgs)
+		result.Commit = deployArgs.Tag
+		if !clusters {
+			deployArgs.outcome = &result.Outcome
+		}
+		if deployArgs.PinDigest || env.PinDigest {
+			if deployArgs.image, err = registry.Pin(cfg.CurrentRegistry(), deployImage(registryUrl, currentCI.BuildName(), deployArgs)); err != nil {
+				log.Error(err.Error())
+				result.failed(err)
+				return -4
+			}
+			log.Infof("Using image <green>%s</green>\n", deployArgs.image)
+			result.Image = deployArgs.image
+		} else if deployArgs.VerifyImage || env.VerifyImage {
+			image := deployImage(registryUrl, currentCI.BuildName(), deployArgs)
+			if _, err := cfg.CurrentRegistry().Resolve(image); err != nil {
+				log.Error(err.Error())
+				result.failed(err)
+				return -4
+			}
+			log.Infof("Found image <green>%s</green> in registry\n", image)
+		}
+		if clusters {
+			err = deployToClusters(dir, registryUrl, currentCI.BuildName(), tstamp, env, deployArgs)
+		} else {
+			err = deployTarget(dir, registryUrl, currentCI.BuildName(), tstamp, env, deployArgs)
+		}
+		if err != nil {
 			log.Error(err.Error())
+			result.failed(err)
+			if errors.Is(err, ErrDifferences) {
+				return -6
+			}
 			return -4
-
 		}
 	}
 	return 0
 }
 
-func Deploy(dir, registryUrl, buildName, timestamp string, client kubectl.Kubectl, deployArgs Args) error {
-	imageName := fmt.Sprintf("%s/%s:%s", registryUrl, buildName, deployArgs.Tag)
+// deployTarget creates a client for env and deploys to it
+func deployTarget(dir, registryUrl, buildName, timestamp string, env *config.Target, deployArgs Args) error {
+	var client kubectl.Kubectl
+	if env.Native {
+		var err error
+		if client, err = kubectl.NewNative(env); err != nil {
+			return err
+		}
+	} else {
+		client = kubectl.New(env)
+	}
+	defer client.Cleanup()
+	return Deploy(dir, registryUrl, buildName, timestamp, client, deployArgs)
+}
+
+// deployToClusters deploys to all clusters configured for env using the configured strategy
+func deployToClusters(dir, registryUrl, buildName, timestamp string, env *config.Target, deployArgs Args) error {
+	clusters, err := clustersFor(env)
+	if err != nil {
+		return err
+	}
+	// outcomes are created up front since clusters may be deployed to in parallel
+	outcomes := map[string]*Outcome{}
+	for _, c := range clusters {
+		outcome := &ClusterOutcome{Cluster: c.name, Outcome: Outcome{Status: statusSkipped}}
+		deployArgs.result.Clusters = append(deployArgs.result.Clusters, outcome)
+		outcomes[c.name] = &outcome.Outcome
+	}
+	results, err := deployClusters(clusters, env.Strategy, func(c cluster) error {
+		log.Infof("Deploying to cluster <green>%s</green>\n", c.name)
+		clusterArgs := deployArgs
+		clusterArgs.Context = c.target.Context
+		clusterArgs.Namespace = c.target.Namespace
+		clusterArgs.DiagnosticsFile = clusterFile(deployArgs.DiagnosticsFile, c.name)
+		clusterArgs.outcome = outcomes[c.name]
+		return deployTarget(dir, registryUrl, buildName, timestamp, c.target, clusterArgs)
+	})
+	if err != nil {
+		return err
+	}
+	return report(results)
+}
 
-	deploymentFiles := filepath.Join(dir, "k8s")
-	if err := processDir(deploymentFiles, deployArgs.Tag, timestamp, deployArgs.Target, imageName, client); err != nil {
+func Deploy(dir, registryUrl, buildName, timestamp string, client kubectl.Kubectl, deployArgs Args) (err error) {
+	started := now()
+	imageName := deployImage(registryUrl, buildName, deployArgs)
+	state := &applied{snapshot: deployArgs.RollbackOnFailure, diff: deployArgs.Diff, timeout: deployArgs.Timeout}
+	defer func() {
+		deployArgs.outcome.record(deployArgs, state, started, err)
+	}()
+	if deployArgs.DryRun != "" && deployArgs.DryRun != "none" {
+		state.dryRun = deployArgs.DryRun
+	}
+	if deployArgs.Diff {
+		state.dryRun = "server"
+	}
+	if state.dryRun != "" {
+		state.snapshot = false
+	}
+	pruning := deployArgs.Prune || deployArgs.PruneDryRun
+	if pruning {
+		state.labels = map[string]string{pruneLabel: pruneLabelValue(buildName, deployArgs.Target)}
+	}
+	h := newHooks(dir, deployArgs.Target, deployArgs.hooks, hookData{
+		image:      imageName,
+		commit:     deployArgs.Tag,
+		context:    deployArgs.Context,
+		namespace:  deployArgs.Namespace,
+		kubeconfig: client.Kubeconfig(),
+	}, state.dryRun != "")
+	defer func() {
+		if err != nil && !errors.Is(err, ErrDifferences) {
+			h.failed()
+		}
+	}()
+	d, err := renderDescriptors(state, dir, registryUrl, buildName, timestamp, deployArgs)
+	if err != nil {
+		return err
+	}
+	if deployArgs.validator != nil {
+		if err := d.validate(deployArgs.validator); err != nil {
+			return err
+		}
+	}
+	if deployArgs.checker != nil {
+		if err := d.check(deployArgs.checker); err != nil {
+			return err
+		}
+	}
+	if err := h.run(preApply); err != nil {
+		return err
+	}
+	if err := d.apply(state, h.env, client); err != nil {
+		return err
+	}
+	if err := h.run(postApply); err != nil {
 		return err
 	}
 
+	if state.dryRun != "" {
+		if pruning {
+			if err := prune(state, buildName, deployArgs.Target, true, client); err != nil {
+				return err
+			}
+		}
+		if state.differences > 0 {
+			return ErrDifferences
+		}
+		return nil
+	}
+
 	if deployArgs.NoWait {
 		log.Info("Not waiting for deployment to succeed\n")
+		if pruning {
+			return prune(state, buildName, deployArgs.Target, deployArgs.PruneDryRun, client)
+		}
 		return nil
 	}
 
-	if client.DeploymentExists(buildName) {
-		if !client.RolloutStatus(buildName, deployArgs.Timeout) {
-			log.Error("Rollout failed. Fetching events.\n")
-			log.Error(client.DeploymentEvents(buildName))
-			log.Error(client.PodEvents(buildName))
-			return fmt.Errorf("failed to rollout")
+	workloads, err := kubectl.Workloads(strings.Join(state.manifests, "\n---\n"))
+	if err != nil {
+		return err
+	}
+	if len(workloads) == 0 && client.DeploymentExists(buildName) {
+		workloads = append(workloads, kubectl.Object{APIVersion: "apps/v1", Kind: "Deployment", Name: buildName})
+	}
+	failed, diagnostics := waitForWorkloads(state, workloads, deployArgs.Timeout, client)
+	if deployArgs.DiagnosticsFile != "" && len(failed) > 0 {
+		if err := writeDiagnostics(deployArgs.DiagnosticsFile, diagnostics); err != nil {
+			log.Errorf("Failed to write diagnostics to <red>%s</red>: %v\n", deployArgs.DiagnosticsFile, err)
 		}
 	}
-	return nil
+	if len(failed) == 0 {
+		if err := h.run(postRollout); err != nil {
+			return err
+		}
+		if pruning {
+			return prune(state, buildName, deployArgs.Target, deployArgs.PruneDryRun, client)
+		}
+		return nil
+	}
+	var names []string
+	for _, workload := range failed {
+		names = append(names, workload.String())
+	}
+	if !deployArgs.RollbackOnFailure {
+		return fmt.Errorf("failed to rollout %s", strings.Join(names, ", "))
+	}
+	if err := rollback(state, failed, client); err != nil {
+		return fmt.Errorf("failed to rollout %s, rollback failed: %w", strings.Join(names, ", "), err)
+	}
+	state.rolledBack = true
+	return fmt.Errorf("failed to rollout %s, rolled back to previous versions", strings.Join(names, ", "))
 }
 
-func processDir(dir, commit, timestamp, target, imageName string, client kubectl.Kubectl) error {
-	files, err := file.FindFilesForTarget(dir, target)
-	if err != nil {
-		return err
+// applied keeps track of what has been applied during a deploy
+type applied struct {
+	manifests []string
+	// snapshot is set when the previous state of objects should be saved before applying them
+	snapshot bool
+	previous []revision
+	// labels are added to all applied objects
+	labels map[string]string
+	// dryRun is the dry run mode to use instead of applying, client or server
+	dryRun string
+	// diff is set when differences to the live objects should be printed during dry run
+	diff        bool
+	differences int
+	// timeout is how long to wait for custom resource definitions to be established
+	timeout string
+	// decrypted is set when any of the descriptors were decrypted with SOPS and must not be logged
+	decrypted bool
+	// results, rollouts, diagnostics, rolledBack and pruned are what happened, for the json output
+	results     []kubectl.ApplyResult
+	rollouts    []Rollout
+	diagnostics []*kubectl.Diagnostics
+	rolledBack  bool
+	pruned      []kubectl.Object
+}
+
+// revision is the state of an object before it was applied, manifest is empty if it didn't exist
+type revision struct {
+	object   kubectl.Object
+	manifest string
+}
+
+func (a *applied) hasRevision(object kubectl.Object) bool {
+	for _, rev := range a.previous {
+		if rev.object == object {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForWorkloads waits for each workload to become ready and returns the failed ones together with
+// diagnostics explaining why they failed
+func waitForWorkloads(state *applied, workloads []kubectl.Object, timeout string, client kubectl.Kubectl) ([]kubectl.Object, []*kubectl.Diagnostics) {
+	var failed []kubectl.Object
+	diagnostics := []*kubectl.Diagnostics{}
+	for _, workload := range workloads {
+		log.Infof("Waiting for <green>%s</green> to become ready\n", workload)
+		started := now()
+		ready := client.RolloutStatus(workload, timeout)
+		state.rollouts = append(state.rollouts, Rollout{Object: workload, Ready: ready, DurationSeconds: since(started)})
+		if ready {
+			log.Infof("<green>%s</green> is ready\n", workload)
+			continue
+		}
+		failed = append(failed, workload)
+		log.Errorf("Rollout of <red>%s</red> failed. Fetching diagnostics.\n", workload)
+		diagnostic, err := client.Diagnose(workload)
+		if err != nil {
+			log.Errorf("Unable to fetch diagnostics for <red>%s</red>: %v\n", workload, err)
+			continue
+		}
+		log.Error(diagnostic.String())
+		diagnostics = append(diagnostics, diagnostic)
 	}
-	scripts, err := file.FindScriptsForTarget(dir, target)
+	state.diagnostics = diagnostics
+	return failed, diagnostics
+}
+
+func writeDiagnostics(name string, diagnostics []*kubectl.Diagnostics) error {
+	content, err := json.MarshalIndent(diagnostics, "", "  ")
 	if err != nil {
 		return err
 	}
-	for _, info := range files {
-		if f, err := os.Open(filepath.Join(dir, info.Name())); err != nil {
-			return err
-		} else {
-			if err := processFile(f, commit, timestamp, imageName, client); err != nil {
-				return err
+	return os.WriteFile(name, append(content, '\n'), 0o644)
+}
+
+// rollback reverts the applied objects in reverse order. Failed workloads are rolled back to their
+// previous revision, other objects are restored to their previous state or deleted if they didn't exist.
+func rollback(state *applied, failed []kubectl.Object, client kubectl.Kubectl) error {
+	log.Info("Rolling back to previous versions\n")
+	var errs []error
+	for i := len(state.previous) - 1; i >= 0; i-- {
+		rev := state.previous[i]
+		var err error
+		switch {
+		case rev.manifest == "":
+			if err = client.Delete(rev.object); err == nil {
+				log.Infof("Deleted <yellow>%s</yellow> which didn't exist before\n", rev.object)
+			}
+		case rev.object.Kind != "Job" && slices.Contains(failed, rev.object):
+			if err = client.RolloutUndo(rev.object); err == nil {
+				log.Infof("Rolled back <yellow>%s</yellow> to previous revision\n", rev.object)
 			}
+		default:
+			if _, err = client.Apply(rev.manifest); err == nil {
+				log.Infof("Restored previous version of <yellow>%s</yellow>\n", rev.object)
+			}
+		}
+		if err != nil {
+			log.Errorf("Failed to roll back <red>%s</red>: %v\n", rev.object, err)
+			errs = append(errs, fmt.Errorf("%s: %w", rev.object, err))
 		}
 	}
-	for _, info := range scripts {
-		if err := execFile(filepath.Join(dir, info.Name())); err != nil {
+	return errors.Join(errs...)
+}
+
+// Render renders the deployment descriptors of the project in dir for the target in deployArgs, in the same way as
+// Deploy but without applying them
+func Render(dir, registryUrl, buildName, timestamp string, deployArgs Args, cfg *config.Config) (string, error) {
+	deployArgs.templating = cfg.Templating.Enabled
+	deployArgs.chart = cfg.Helm.Chart
+	d, err := renderDescriptors(&applied{}, dir, registryUrl, buildName, timestamp, deployArgs)
+	if err != nil {
+		return "", err
+	}
+	return d.Content(), nil
+}
+
+func renderDescriptors(state *applied, dir, registryUrl, buildName, timestamp string, deployArgs Args) (*descriptors, error) {
+	d, err := render.Render(dir, render.Options{
+		Name:       buildName,
+		Namespace:  deployArgs.Namespace,
+		Chart:      deployArgs.chart,
+		Templating: deployArgs.templating,
+		Labels:     state.labels,
+		Data: templating.Data{
+			Commit:    deployArgs.Tag,
+			Timestamp: timestamp,
+			Image:     deployImage(registryUrl, buildName, deployArgs),
+			Target:    deployArgs.Target,
+			Variables: deployArgs.Variables,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	state.decrypted = d.Decrypted
+	return &descriptors{d}, nil
+}
+
+// deployImage returns the image to deploy, referenced by digest if it has been pinned
+func deployImage(registryUrl, buildName string, deployArgs Args) string {
+	if deployArgs.image != "" {
+		return deployArgs.image
+	}
+	return fmt.Sprintf("%s/%s:%s", registryUrl, buildName, deployArgs.Tag)
+}
+
+// descriptors are the rendered deployment descriptors, together with the scripts to run after they have been applied
+type descriptors struct {
+	*render.Descriptors
+}
+
+// apply applies the descriptors and runs the scripts
+func (d *descriptors) apply(state *applied, env []string, client kubectl.Kubectl) error {
+	if err := apply(state, d.Contents, client); err != nil {
+		return err
+	}
+	for _, info := range d.Scripts {
+		if state.dryRun != "" {
+			log.Infof("Not executing script '<yellow>%s</yellow>' in dry run\n", info.Name())
+			continue
+		}
+		if err := execFile(filepath.Join(d.Dir, info.Name()), env); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func execFile(file string) error {
+// validate validates the descriptors against the Kubernetes schemas
+func (d *descriptors) validate(validator *schema.Validator) error {
+	log.Info("Validating deployment descriptors\n")
+	return validator.Validate(d.Content())
+}
+
+// check checks the descriptors against the policy rules
+func (d *descriptors) check(checker *policy.Checker) error {
+	log.Info("Checking deployment descriptors against policies\n")
+	return checker.Check(d.Content())
+}
+
+func execFile(file string, env []string) error {
 	cmd := exec.Command(file)
+	cmd.Env = env
 	cmd.Stdout = cli.NewWriter(log.Log)
 	cmd.Stderr = cli.NewWriter(log.Log)
 	return cmd.Run()
 }
 
-func processFile(file *os.File, commit, timestamp, image string, client kubectl.Kubectl) error {
-	if bytes, err := io.ReadAll(file); err != nil {
+// apply applies the rendered descriptors in a single batch, ordered by kind. If there are CustomResourceDefinitions,
+// they are applied in a separate batch first, and are waited for to be established before the rest is applied.
+func apply(state *applied, contents []string, client kubectl.Kubectl) error {
+	files := make([]kubectl.Document, len(contents))
+	for i, content := range contents {
+		files[i] = kubectl.Document{Content: content}
+	}
+	// documents are ordered across all files
+	docs, err := kubectl.Order(kubectl.Join(files))
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	split := 0
+	var crds []kubectl.Object
+	for i, doc := range docs {
+		if doc.IsCRD() {
+			split = i + 1
+			crds = append(crds, doc.Object)
+		}
+	}
+	if split == 0 || split == len(docs) {
+		return applyBatch(state, kubectl.Join(docs), client)
+	}
+	if err := applyBatch(state, kubectl.Join(docs[:split]), client); err != nil {
 		return err
+	}
+	if state.dryRun == "" {
+		log.Info("Waiting for custom resource definitions to be established\n")
+		if err := client.WaitForCondition(crds, "Established", state.timeout); err != nil {
+			return fmt.Errorf("custom resource definitions not established: %w", err)
+		}
+	}
+	return applyBatch(state, kubectl.Join(docs[split:]), client)
+}
+
+func applyBatch(state *applied, content string, client kubectl.Kubectl) error {
+	if state.snapshot {
+		if err := saveRevisions(state, content, client); err != nil {
+			return err
+		}
+	}
+	if state.decrypted {
+		log.Debug("trying to apply descriptors with decrypted secrets, not logging content\n")
 	} else {
-		content := string(bytes)
-		if len(strings.TrimSpace(content)) == 0 {
-			log.Debugf("ignoring empty file '<yellow>%s</yellow>'\n", filepath.Base(file.Name()))
-			return nil
-		}
-		r := strings.NewReplacer("${COMMIT}", commit, "${TIMESTAMP}", timestamp, "${IMAGE}", image)
-		kubeContent := r.Replace(content)
-		log.Debugf("trying to apply: \n---\n%s\n---\n", kubeContent)
-		if err := client.Apply(kubeContent); err != nil {
+		log.Debugf("trying to apply: \n---\n%s\n---\n", content)
+	}
+	if state.dryRun != "" {
+		if err := dryRun(state, content, client); err != nil {
 			return err
 		}
-		return nil
+	} else {
+		results, err := client.Apply(content)
+		state.results = append(state.results, results...)
+		if err != nil {
+			return err
+		}
+	}
+	state.manifests = append(state.manifests, content)
+	return nil
+}
+
+func saveRevisions(state *applied, content string, client kubectl.Kubectl) error {
+	objects, err := kubectl.Objects(content)
+	if err != nil {
+		return err
 	}
+	for _, object := range objects {
+		if state.hasRevision(object) {
+			continue
+		}
+		manifest, err := client.Get(object)
+		if err != nil {
+			return fmt.Errorf("failed to get current state of %s: %w", object, err)
+		}
+		state.previous = append(state.previous, revision{object: object, manifest: manifest})
+	}
+	return nil
 }
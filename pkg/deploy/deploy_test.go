@@ -25,16 +25,24 @@ package deploy
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/apex/log"
 	"github.com/stretchr/testify/assert"
 	mocks "gitlab.com/unboundsoftware/apex-mocks"
 
+	"github.com/buildtool/build-tools/pkg"
 	"github.com/buildtool/build-tools/pkg/args"
+	"github.com/buildtool/build-tools/pkg/config"
 	"github.com/buildtool/build-tools/pkg/kubectl"
+	"github.com/buildtool/build-tools/pkg/policy"
+	"github.com/buildtool/build-tools/pkg/schema"
+	"github.com/buildtool/build-tools/pkg/version"
 )
 
 func TestDeploy_MissingDeploymentFilesDir(t *testing.T) {
@@ -428,6 +436,89 @@ metadata:
 	})
 }
 
+func TestDeploy_PinnedImage(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+	}
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte("commit: ${COMMIT}\nimage: ${IMAGE}\n"), 0o777)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	err := Deploy(name, "registryUrl", "image", "2019-05-13T17:22:36Z01:00", client, Args{
+		Target:  "test",
+		Tag:     "abc123",
+		Timeout: "2m",
+		image:   "registryUrl/image@sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"commit: abc123\nimage: registryUrl/image@sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7\n"}, client.Inputs)
+}
+
+func TestDoDeploy_PinDigestImageNotFound(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = server.Client().Transport
+	defer func() { http.DefaultClient.Transport = transport }()
+	host := strings.TrimPrefix(server.URL, "https://")
+	name := t.TempDir()
+	yaml := fmt.Sprintf(`
+registry:
+  gitlab:
+    registry: %s/group
+targets:
+  prod:
+    context: prod
+    pinDigest: true
+`, host)
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	got := DoDeploy(name, version.Info{}, "prod", "--tag", "abc123")
+
+	assert.Equal(t, -4, got)
+	logMock.Check(t, []string{
+		"info: Using passed tag <green>abc123</green> to deploy",
+		fmt.Sprintf("error: image not found: '%s/group/deploy:abc123' doesn't exist in Gitlab, make sure that it has been built and pushed", host),
+	})
+}
+
+func TestDoDeploy_VerifyImageNotFound(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = server.Client().Transport
+	defer func() { http.DefaultClient.Transport = transport }()
+	host := strings.TrimPrefix(server.URL, "https://")
+	name := t.TempDir()
+	yaml := fmt.Sprintf(`
+registry:
+  gitlab:
+    registry: %s/group
+targets:
+  prod:
+    context: prod
+`, host)
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	got := DoDeploy(name, version.Info{}, "prod", "--tag", "abc123", "--verify-image")
+
+	assert.Equal(t, -4, got)
+	logMock.Check(t, []string{
+		"info: Using passed tag <green>abc123</green> to deploy",
+		fmt.Sprintf("error: image not found: '%s/group/deploy:abc123' doesn't exist in Gitlab, make sure that it has been built and pushed", host),
+	})
+}
+
 func TestDeploy_ReplacingCommitAndTimestampAndImage(t *testing.T) {
 	client := &kubectl.MockKubectl{
 		Responses: []error{nil},
@@ -478,6 +569,217 @@ metadata:
 	})
 }
 
+func TestDeploy_GoTemplates(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+		Status:    true,
+	}
+
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	yaml := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: dummy
+spec:
+  replicas: {{ if eq .Target "prod" }}3{{ else }}1{{ end }}
+  template:
+    spec:
+      containers:
+        - name: dummy
+          image: {{ .Image }}
+          env:
+            - name: COMMIT
+              value: {{ .Commit | quote }}
+`
+	deployFile := filepath.Join(name, "k8s", "deploy.yaml")
+	_ = os.WriteFile(deployFile, []byte(yaml), 0o777)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "2019-05-13T17:22:36Z01:00", client, Args{
+		Globals:    args.Globals{},
+		Target:     "prod",
+		Tag:        "abc123",
+		Timeout:    "2m",
+		templating: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(client.Inputs))
+	expectedInput := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: dummy
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: dummy
+          image: registryUrl/image:abc123
+          env:
+            - name: COMMIT
+              value: "abc123"
+`
+	assert.Equal(t, expectedInput, client.Inputs[0])
+	logMock.Check(t, []string{
+		"info: Waiting for <green>deployment/dummy</green> to become ready\n",
+		"info: <green>deployment/dummy</green> is ready\n",
+	})
+}
+
+func TestDeploy_Variables(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+	}
+
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	deployFile := filepath.Join(name, "k8s", "deploy.yaml")
+	_ = os.WriteFile(deployFile, []byte("replicas: ${REPLICAS}\nhost: {{ .Variables.HOST }}\n"), 0o777)
+
+	err := Deploy(name, "registryUrl", "image", "2019-05-13T17:22:36Z01:00", client, Args{
+		Globals:    args.Globals{},
+		Target:     "prod",
+		Tag:        "abc123",
+		Timeout:    "2m",
+		Variables:  map[string]string{"REPLICAS": "3", "HOST": "example.org"},
+		templating: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"replicas: 3\nhost: example.org\n"}, client.Inputs)
+}
+
+func TestDeploy_KustomizeOverlay(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+		Status:    true,
+	}
+
+	name := t.TempDir()
+	k8s := filepath.Join(name, "k8s")
+	_ = os.MkdirAll(filepath.Join(k8s, "base"), 0o777)
+	_ = os.MkdirAll(filepath.Join(k8s, "overlays", "prod"), 0o777)
+	_ = os.WriteFile(filepath.Join(k8s, "base", "kustomization.yaml"), []byte("resources:\n- deploy.yaml\n"), 0o666)
+	_ = os.WriteFile(filepath.Join(k8s, "base", "deploy.yaml"), []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: image
+  annotations:
+    commit: ${COMMIT}
+spec:
+  template:
+    spec:
+      containers:
+      - name: image
+        image: image
+`), 0o666)
+	_ = os.WriteFile(filepath.Join(k8s, "overlays", "prod", "kustomization.yaml"), []byte("resources:\n- ../../base\nnamespace: prod\n"), 0o666)
+	_ = os.WriteFile(filepath.Join(k8s, "ignored.yaml"), []byte("ignored"), 0o666)
+
+	err := Deploy(name, "registryUrl", "image", "2019-05-13T17:22:36Z01:00", client, Args{
+		Globals: args.Globals{},
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "2m",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  annotations:
+    commit: abc123
+  name: image
+  namespace: prod
+spec:
+  template:
+    spec:
+      containers:
+      - image: registryUrl/image:abc123
+        name: image
+`}, client.Inputs)
+}
+
+func TestDeploy_HelmChart(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+		Status:    true,
+	}
+
+	name := t.TempDir()
+	chart := filepath.Join(name, "deploy", "chart")
+	_ = os.MkdirAll(filepath.Join(chart, "templates"), 0o777)
+	_ = os.WriteFile(filepath.Join(chart, "Chart.yaml"), []byte("apiVersion: v2\nname: image\nversion: 0.1.0\n"), 0o666)
+	_ = os.WriteFile(filepath.Join(chart, "values.yaml"), []byte("replicas: 1\n"), 0o666)
+	_ = os.WriteFile(filepath.Join(chart, "values-prod.yaml"), []byte("replicas: 2\n"), 0o666)
+	_ = os.WriteFile(filepath.Join(chart, "templates", "deploy.yaml"), []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}
+  namespace: {{ .Release.Namespace }}
+  annotations:
+    commit: ${COMMIT}
+spec:
+  replicas: {{ .Values.replicas }}
+  template:
+    spec:
+      containers:
+      - name: image
+        image: {{ .Values.image.repository }}:{{ .Values.image.tag }}`), 0o666)
+
+	err := Deploy(name, "registryUrl", "image", "2019-05-13T17:22:36Z01:00", client, Args{
+		Globals:   args.Globals{},
+		Target:    "prod",
+		Namespace: "prod",
+		Tag:       "abc123",
+		Timeout:   "2m",
+		chart:     "deploy/chart",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`# Source: image/templates/deploy.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: image
+  namespace: prod
+  annotations:
+    commit: abc123
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: image
+        image: registryUrl/image:abc123
+`}, client.Inputs)
+}
+
+func TestDeploy_GoTemplatesError(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+	}
+
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	deployFile := filepath.Join(name, "k8s", "deploy.yaml")
+	_ = os.WriteFile(deployFile, []byte(`host: {{ required "host must be set" "" }}`), 0o777)
+
+	err := Deploy(name, "registryUrl", "image", "2019-05-13T17:22:36Z01:00", client, Args{
+		Globals:    args.Globals{},
+		Target:     "prod",
+		Tag:        "abc123",
+		Timeout:    "2m",
+		templating: true,
+	})
+	assert.EqualError(t, err, `template: deploy.yaml:1:9: executing "deploy.yaml" at <required "host must be set" "">: error calling required: host must be set`)
+	assert.Equal(t, 0, len(client.Inputs))
+}
+
 func TestDeploy_DeploymentExists(t *testing.T) {
 	client := &kubectl.MockKubectl{
 		Responses:  []error{nil},
@@ -508,16 +810,16 @@ metadata:
 		Timeout:   "2m",
 	})
 
-	assert.EqualError(t, err, "failed to rollout")
+	assert.EqualError(t, err, "failed to rollout deployment/registryUrl")
 	assert.Equal(t, 1, len(client.Inputs))
 	assert.Equal(t, yaml, client.Inputs[0])
 	logMock.Check(t, []string{
 		"debug: considering file '<yellow>deploy.yaml</yellow>' for target: <green></green>\n",
 		"debug: using file '<green>deploy.yaml</green>' for target: <green></green>\n",
 		"debug: trying to apply: \n---\n\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: dummy\n\n---\n",
-		"error: Rollout failed. Fetching events.\n",
-		"error: Deployment events",
-		"error: Pod events",
+		"info: Waiting for <green>deployment/registryUrl</green> to become ready\n",
+		"error: Rollout of <red>deployment/registryUrl</red> failed. Fetching diagnostics.\n",
+		"error: Diagnostics for deployment/registryUrl:\n  Pod registryUrl-1 (Running):\n",
 	})
 }
 
@@ -552,16 +854,364 @@ metadata:
 		Timeout:   "2m",
 	})
 
-	assert.EqualError(t, err, "failed to rollout")
+	assert.EqualError(t, err, "failed to rollout deployment/registryUrl")
 	assert.Equal(t, 1, len(client.Inputs))
 	assert.Equal(t, yaml, client.Inputs[0])
 	logMock.Check(t, []string{
 		"debug: considering file '<yellow>deploy.yaml</yellow>' for target: <green></green>\n",
 		"debug: using file '<green>deploy.yaml</green>' for target: <green></green>\n",
 		"debug: trying to apply: \n---\n\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: dummy\n\n---\n",
-		"error: Rollout failed. Fetching events.\n",
-		"error: Deployment events",
-		"error: Pod events",
+		"info: Waiting for <green>deployment/registryUrl</green> to become ready\n",
+		"error: Rollout of <red>deployment/registryUrl</red> failed. Fetching diagnostics.\n",
+		"error: Diagnostics for deployment/registryUrl:\n  Pod registryUrl-1 (Running):\n",
+	})
+}
+
+func TestDeploy_Workloads(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses:  []error{nil, nil},
+		Deployment: true,
+		Status:     true,
+		Failing:    []string{"migrate"},
+	}
+
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "db.yaml"), []byte(`apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: data
+`), 0o666)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "jobs.yaml"), []byte(`apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+`), 0o666)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Tag:     "abc123",
+		Timeout: "2m",
+	})
+
+	assert.EqualError(t, err, "failed to rollout job/migrate")
+	assert.Equal(t, []kubectl.Object{
+		{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "db", Namespace: "data"},
+		{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "agent"},
+		{APIVersion: "batch/v1", Kind: "Job", Name: "migrate"},
+	}, client.Waited)
+	logMock.Check(t, []string{
+		"info: Waiting for <green>statefulset/db</green> to become ready\n",
+		"info: <green>statefulset/db</green> is ready\n",
+		"info: Waiting for <green>daemonset/agent</green> to become ready\n",
+		"info: <green>daemonset/agent</green> is ready\n",
+		"info: Waiting for <green>job/migrate</green> to become ready\n",
+		"error: Rollout of <red>job/migrate</red> failed. Fetching diagnostics.\n",
+		"error: Diagnostics for job/migrate:\n  Pod migrate-1 (Running):\n",
+	})
+}
+
+func TestDeploy_DiagnosticsFile(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+		Status:    false,
+	}
+
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: image
+`), 0o666)
+	diagnosticsFile := filepath.Join(name, "diagnostics.json")
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals:         args.Globals{},
+		Tag:             "abc123",
+		Timeout:         "2m",
+		DiagnosticsFile: diagnosticsFile,
+	})
+
+	assert.EqualError(t, err, "failed to rollout deployment/image")
+	content, err := os.ReadFile(diagnosticsFile)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"workload":{"apiVersion":"apps/v1","kind":"Deployment","name":"image"},"pods":[{"name":"image-1","phase":"Running","containers":null}]}]`, string(content))
+	logMock.Check(t, []string{
+		"info: Waiting for <green>deployment/image</green> to become ready\n",
+		"error: Rollout of <red>deployment/image</red> failed. Fetching diagnostics.\n",
+		"error: Diagnostics for deployment/image:\n  Pod image-1 (Running):\n",
+	})
+}
+
+func TestDeploy_DiagnoseError(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses:     []error{nil},
+		Status:        false,
+		DiagnoseError: errors.New("forbidden"),
+	}
+
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: image
+`), 0o666)
+	diagnosticsFile := filepath.Join(name, "missing", "diagnostics.json")
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals:         args.Globals{},
+		Tag:             "abc123",
+		Timeout:         "2m",
+		DiagnosticsFile: diagnosticsFile,
+	})
+
+	assert.EqualError(t, err, "failed to rollout deployment/image")
+	logMock.Check(t, []string{
+		"info: Waiting for <green>deployment/image</green> to become ready\n",
+		"error: Rollout of <red>deployment/image</red> failed. Fetching diagnostics.\n",
+		"error: Unable to fetch diagnostics for <red>deployment/image</red>: forbidden\n",
+		fmt.Sprintf("error: Failed to write diagnostics to <red>%s</red>: open %s: no such file or directory\n", diagnosticsFile, diagnosticsFile),
+	})
+}
+
+func TestDeploy_OrderedBatch(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+		Status:    true,
+	}
+
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "app.yaml"), []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: image\n  namespace: apps\n"), 0o666)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "config.yaml"), []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: apps\n"), 0o666)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "namespace.yaml"), []byte("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: apps\n"), 0o666)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Tag:     "abc123",
+		Timeout: "2m",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`apiVersion: v1
+kind: Namespace
+metadata:
+  name: apps
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: apps
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: image
+  namespace: apps
+`}, client.Inputs)
+	assert.Empty(t, client.Conditions)
+	logMock.Check(t, []string{
+		"info: Waiting for <green>deployment/image</green> to become ready\n",
+		"info: <green>deployment/image</green> is ready\n",
+	})
+}
+
+func TestDeploy_CustomResourceDefinitions(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil, nil},
+		Status:    true,
+	}
+
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "crd.yaml"), []byte("apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: widgets.example.org\n"), 0o666)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "app.yaml"), []byte("apiVersion: example.org/v1\nkind: Widget\nmetadata:\n  name: widget\n---\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: apps\n---\napiVersion: v1\nkind: Service\nmetadata:\n  name: image\n"), 0o666)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Tag:     "abc123",
+		Timeout: "2m",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"apiVersion: v1\nkind: Namespace\nmetadata:\n  name: apps\n---\napiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: widgets.example.org\n",
+		"apiVersion: v1\nkind: Service\nmetadata:\n  name: image\n---\napiVersion: example.org/v1\nkind: Widget\nmetadata:\n  name: widget\n",
+	}, client.Inputs)
+	assert.Equal(t, []string{"Established: customresourcedefinition/widgets.example.org"}, client.Conditions)
+	logMock.Check(t, []string{
+		"info: Waiting for custom resource definitions to be established\n",
+	})
+}
+
+func TestDeploy_CustomResourceDefinitionsNotEstablished(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil, nil},
+		WaitError: errors.New("timed out waiting for the condition"),
+	}
+
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "crd.yaml"), []byte("apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: widgets.example.org\n---\napiVersion: example.org/v1\nkind: Widget\nmetadata:\n  name: widget\n"), 0o666)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Tag:     "abc123",
+		Timeout: "2m",
+	})
+
+	assert.EqualError(t, err, "custom resource definitions not established: timed out waiting for the condition")
+	assert.Equal(t, 1, len(client.Inputs))
+	logMock.Check(t, []string{
+		"info: Waiting for custom resource definitions to be established\n",
+	})
+}
+
+func TestDeploy_NoWorkloads(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+	}
+
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "ns.yaml"), []byte("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: dummy\n"), 0o666)
+
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Tag:     "abc123",
+		Timeout: "2m",
+	})
+
+	assert.NoError(t, err)
+	assert.Empty(t, client.Waited)
+}
+
+func TestDeploy_RollbackOnFailure(t *testing.T) {
+	previousConfig := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\ndata:\n  key: old\n"
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil, nil, nil},
+		Status:    true,
+		Failing:   []string{"image"},
+		Existing: map[string]string{
+			"configmap/config":  previousConfig,
+			"deployment/image":  "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: image\n",
+			"statefulset/cache": "apiVersion: apps/v1\nkind: StatefulSet\nmetadata:\n  name: cache\n",
+		},
+	}
+
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  key: new
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: image
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: image
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: cache
+`), 0o666)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals:           args.Globals{},
+		Tag:               "abc123",
+		Timeout:           "2m",
+		RollbackOnFailure: true,
+	})
+
+	assert.EqualError(t, err, "failed to rollout deployment/image, rolled back to previous versions")
+	assert.Equal(t, []kubectl.Object{{APIVersion: "apps/v1", Kind: "Deployment", Name: "image"}}, client.Undone)
+	assert.Equal(t, []kubectl.Object{{APIVersion: "v1", Kind: "Service", Name: "image"}}, client.Deleted)
+	assert.Equal(t, 3, len(client.Inputs))
+	assert.Equal(t, "apiVersion: apps/v1\nkind: StatefulSet\nmetadata:\n  name: cache\n", client.Inputs[1])
+	assert.Equal(t, previousConfig, client.Inputs[2])
+	logMock.Check(t, []string{
+		"info: Waiting for <green>deployment/image</green> to become ready\n",
+		"error: Rollout of <red>deployment/image</red> failed. Fetching diagnostics.\n",
+		"error: Diagnostics for deployment/image:\n  Pod image-1 (Running):\n",
+		"info: Waiting for <green>statefulset/cache</green> to become ready\n",
+		"info: <green>statefulset/cache</green> is ready\n",
+		"info: Rolling back to previous versions\n",
+		"info: Restored previous version of <yellow>statefulset/cache</yellow>\n",
+		"info: Rolled back <yellow>deployment/image</yellow> to previous revision\n",
+		"info: Deleted <yellow>service/image</yellow> which didn't exist before\n",
+		"info: Restored previous version of <yellow>configmap/config</yellow>\n",
+	})
+}
+
+func TestDeploy_RollbackOnFailure_Error(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+		Failing:   []string{"image"},
+		Existing: map[string]string{
+			"deployment/image": "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: image\n",
+		},
+		UndoError: errors.New("no rollout history found"),
+	}
+
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: image\n"), 0o666)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals:           args.Globals{},
+		Tag:               "abc123",
+		Timeout:           "2m",
+		RollbackOnFailure: true,
+	})
+
+	assert.EqualError(t, err, "failed to rollout deployment/image, rollback failed: deployment/image: no rollout history found")
+	logMock.Check(t, []string{
+		"info: Waiting for <green>deployment/image</green> to become ready\n",
+		"error: Rollout of <red>deployment/image</red> failed. Fetching diagnostics.\n",
+		"error: Diagnostics for deployment/image:\n  Pod image-1 (Running):\n",
+		"info: Rolling back to previous versions\n",
+		"error: Failed to roll back <red>deployment/image</red>: no rollout history found\n",
 	})
 }
 
@@ -603,3 +1253,116 @@ metadata:
 		"info: Not waiting for deployment to succeed\n",
 	})
 }
+
+func TestDeploy_EncryptedSecret(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join("..", "sops", "testdata", "age-key.txt"))
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+	}
+	encrypted, err := os.ReadFile(filepath.Join("..", "sops", "testdata", "secret.enc.yaml"))
+	assert.NoError(t, err)
+
+	name := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "secret.yaml"), encrypted, 0o666)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	err = Deploy(name, "image", "registryUrl", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "2m",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"apiVersion: v1\nkind: Secret\nmetadata:\n    name: db\nstringData:\n    password: s3cr3t\n"}, client.Inputs)
+	logMock.Check(t, []string{
+		"debug: considering file '<yellow>secret.yaml</yellow>' for target: <green>prod</green>\n",
+		"debug: using file '<green>secret.yaml</green>' for target: <green>prod</green>\n",
+		"debug: Decrypting '<green>secret.yaml</green>'\n",
+		"debug: trying to apply descriptors with decrypted secrets, not logging content\n",
+	})
+}
+
+func TestDeploy_EncryptedSecretWithoutKey(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GNUPGHOME", t.TempDir())
+	t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join(t.TempDir(), "missing.txt"))
+	client := &kubectl.MockKubectl{}
+	encrypted, err := os.ReadFile(filepath.Join("..", "sops", "testdata", "secret.enc.yaml"))
+	assert.NoError(t, err)
+
+	name := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "secret.yaml"), encrypted, 0o666)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err = Deploy(name, "image", "registryUrl", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "2m",
+	})
+	assert.ErrorContains(t, err, "failed to decrypt 'secret.yaml': ")
+	assert.Empty(t, client.Inputs)
+}
+
+func TestDeploy_ValidationFails(t *testing.T) {
+	name, hookLog := hookProject(t)
+	writeHook(t, name, preApply, "migrate.sh", recordingHook)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "config.yaml"), []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\ndata:\n  replicas: 3\n"), 0o666)
+	client := &kubectl.MockKubectl{}
+	validator, err := schema.New("", "")
+	assert.NoError(t, err)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err = Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals:   args.Globals{},
+		Target:    "prod",
+		Tag:       "abc123",
+		Timeout:   "2m",
+		validator: validator,
+	})
+
+	assert.EqualError(t, err, "validation failed:\n  configmap/config: .data.replicas: expected string, got &value.valueUnstructured{Value:3}")
+	assert.Empty(t, client.Inputs)
+	_, err = os.Stat(hookLog)
+	assert.True(t, os.IsNotExist(err))
+	logMock.Check(t, []string{
+		"info: Validating deployment descriptors\n",
+	})
+}
+
+func TestDeploy_PolicyViolation(t *testing.T) {
+	name, hookLog := hookProject(t)
+	writeHook(t, name, preApply, "migrate.sh", recordingHook)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "pod.yaml"), []byte("apiVersion: v1\nkind: Pod\nmetadata:\n  name: debug\nspec:\n  containers:\n    - name: debug\n      image: busybox\n"), 0o666)
+	client := &kubectl.MockKubectl{}
+	checker, err := policy.New(&config.PolicyConfig{NoLatestTag: true})
+	assert.NoError(t, err)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err = Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "2m",
+		checker: checker,
+	})
+
+	assert.EqualError(t, err, "policy check failed:\n  pod/debug: container 'debug' must use an image with a tag other than latest, not 'busybox' (noLatestTag)")
+	assert.Empty(t, client.Inputs)
+	_, err = os.Stat(hookLog)
+	assert.True(t, os.IsNotExist(err))
+	logMock.Check(t, []string{
+		"info: Checking deployment descriptors against policies\n",
+	})
+}
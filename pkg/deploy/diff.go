@@ -0,0 +1,161 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/pmezard/go-difflib/difflib"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/buildtool/build-tools/pkg/kubectl"
+)
+
+// ErrDifferences is returned by Deploy in diff mode when the descriptors differ from the live objects
+var ErrDifferences = errors.New("differences found between deployment descriptors and live objects")
+
+// dryRun applies content using the dry run mode in state instead of changing the cluster,
+// printing a diff against the live objects in diff mode
+func dryRun(state *applied, content string, client kubectl.Kubectl) error {
+	out, err := client.DryRun(content, state.dryRun)
+	if err != nil {
+		return err
+	}
+	manifests, err := kubectl.Manifests(out)
+	if err != nil {
+		return err
+	}
+	for _, manifest := range manifests {
+		if !state.diff {
+			log.Infof("<green>%s</green> would be applied (%s dry run)\n", manifest.Object, state.dryRun)
+			state.results = append(state.results, kubectl.ApplyResult{Object: manifest.Object, Result: "dry-run"})
+			continue
+		}
+		changed, err := diff(manifest, client)
+		if err != nil {
+			return err
+		}
+		result := kubectl.ApplyResult{Object: manifest.Object, Result: "unchanged"}
+		if changed {
+			state.differences++
+			result.Result = "changed"
+		}
+		state.results = append(state.results, result)
+	}
+	return nil
+}
+
+// diff prints a unified diff between the live object and the result of applying manifest
+func diff(manifest kubectl.Manifest, client kubectl.Kubectl) (bool, error) {
+	live, err := client.Get(manifest.Object)
+	if err != nil {
+		return false, fmt.Errorf("failed to get current state of %s: %w", manifest.Object, err)
+	}
+	merged := manifest.Content
+	if manifest.Object.Kind == "Secret" {
+		if live, merged, err = maskSecret(live, merged); err != nil {
+			return false, err
+		}
+	}
+	result, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        lines(live),
+		B:        lines(merged),
+		FromFile: fmt.Sprintf("live/%s", manifest.Object),
+		ToFile:   fmt.Sprintf("merged/%s", manifest.Object),
+		Context:  3,
+	})
+	if err != nil {
+		return false, err
+	}
+	if result == "" {
+		log.Debugf("no changes for <green>%s</green>\n", manifest.Object)
+		return false, nil
+	}
+	log.Infof("%s", result)
+	return true, nil
+}
+
+func lines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	result := strings.SplitAfter(content, "\n")
+	if result[len(result)-1] == "" {
+		result = result[:len(result)-1]
+	}
+	return result
+}
+
+// maskSecret replaces the values of a live and merged Secret with placeholders, so that they aren't printed while
+// changed values still show up in the diff
+func maskSecret(live, merged string) (string, string, error) {
+	var before, after map[string]interface{}
+	if err := sigsyaml.Unmarshal([]byte(live), &before); err != nil {
+		return "", "", err
+	}
+	if err := sigsyaml.Unmarshal([]byte(merged), &after); err != nil {
+		return "", "", err
+	}
+	for _, field := range []string{"data", "stringData"} {
+		oldValues, _ := before[field].(map[string]interface{})
+		newValues, _ := after[field].(map[string]interface{})
+		for key, value := range oldValues {
+			newValue, exists := newValues[key]
+			switch {
+			case !exists:
+				oldValues[key] = "***"
+			case newValue == value:
+				oldValues[key] = "***"
+				newValues[key] = "***"
+			default:
+				oldValues[key] = "*** (before)"
+				newValues[key] = "*** (after)"
+			}
+		}
+		for key := range newValues {
+			if _, exists := oldValues[key]; !exists {
+				newValues[key] = "***"
+			}
+		}
+	}
+	maskedLive, err := marshal(before)
+	if err != nil {
+		return "", "", err
+	}
+	maskedMerged, err := marshal(after)
+	if err != nil {
+		return "", "", err
+	}
+	return maskedLive, maskedMerged, nil
+}
+
+func marshal(object map[string]interface{}) (string, error) {
+	if object == nil {
+		return "", nil
+	}
+	out, err := sigsyaml.Marshal(object)
+	return string(out), err
+}
@@ -0,0 +1,198 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/args"
+	"github.com/buildtool/build-tools/pkg/kubectl"
+)
+
+const configMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  key: new
+`
+
+func writeDiffDescriptors(t *testing.T) string {
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "config.yaml"), []byte(configMap), 0o666)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "setup-prod.sh"), []byte("#!/bin/sh\nexit 1\n"), 0o777)
+	return name
+}
+
+func TestDeploy_DryRun(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Deployment: true,
+	}
+	name := writeDiffDescriptors(t)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "2m",
+		DryRun:  "client",
+	})
+
+	assert.NoError(t, err)
+	assert.Empty(t, client.Inputs)
+	assert.Empty(t, client.Waited)
+	assert.Equal(t, []string{"client: " + configMap}, client.DryRuns)
+	logMock.Check(t, []string{
+		"info: <green>configmap/config</green> would be applied (client dry run)\n",
+		"info: Not executing script '<yellow>setup-prod.sh</yellow>' in dry run\n",
+	})
+}
+
+func TestDeploy_Diff(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Existing: map[string]string{
+			"configmap/config": "apiVersion: v1\ndata:\n  key: old\nkind: ConfigMap\nmetadata:\n  name: config\n",
+		},
+		DryRunOut: map[string]string{
+			configMap: `apiVersion: v1
+data:
+  key: new
+kind: ConfigMap
+metadata:
+  managedFields:
+  - manager: kubectl
+  name: config
+  resourceVersion: "124"
+`,
+		},
+	}
+	name := writeDiffDescriptors(t)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "2m",
+		DryRun:  "none",
+		Diff:    true,
+	})
+
+	assert.ErrorIs(t, err, ErrDifferences)
+	assert.Equal(t, []string{"server: " + configMap}, client.DryRuns)
+	logMock.Check(t, []string{
+		"info: --- live/configmap/config\n+++ merged/configmap/config\n@@ -1,6 +1,6 @@\n apiVersion: v1\n data:\n-  key: old\n+  key: new\n kind: ConfigMap\n metadata:\n   name: config\n",
+		"info: Not executing script '<yellow>setup-prod.sh</yellow>' in dry run\n",
+	})
+}
+
+func TestDeploy_DiffNewObject(t *testing.T) {
+	client := &kubectl.MockKubectl{}
+	name := writeDiffDescriptors(t)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Tag:     "abc123",
+		Diff:    true,
+	})
+
+	assert.ErrorIs(t, err, ErrDifferences)
+	logMock.Check(t, []string{
+		"info: --- live/configmap/config\n+++ merged/configmap/config\n@@ -0,0 +1,6 @@\n+apiVersion: v1\n+data:\n+  key: new\n+kind: ConfigMap\n+metadata:\n+  name: config\n",
+	})
+}
+
+func TestDeploy_DiffNoChanges(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Existing: map[string]string{
+			"configmap/config": "apiVersion: v1\ndata:\n  key: new\nkind: ConfigMap\nmetadata:\n  name: config\n",
+		},
+	}
+	name := writeDiffDescriptors(t)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Tag:     "abc123",
+		Diff:    true,
+	})
+
+	assert.NoError(t, err)
+	logMock.Check(t, []string{
+		"debug: considering file '<yellow>config.yaml</yellow>' for target: <green></green>\n",
+		"debug: using file '<green>config.yaml</green>' for target: <green></green>\n",
+		"debug: considering script '<yellow>setup-prod.sh</yellow>' for target: <green></green>\n",
+		"debug: not using script '<red>setup-prod.sh</red>' for target: <green></green>\n",
+		"debug: trying to apply: \n---\n" + configMap + "\n---\n",
+		"debug: no changes for <green>configmap/config</green>\n",
+	})
+}
+
+func TestDeploy_DiffSecret(t *testing.T) {
+	secret := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\ndata:\n  password: bmV3\n  user: YWRtaW4=\n  token: dG9rZW4=\n"
+	client := &kubectl.MockKubectl{
+		Existing: map[string]string{
+			"secret/db": "apiVersion: v1\ndata:\n  password: b2xk\n  user: YWRtaW4=\n  key: a2V5\nkind: Secret\nmetadata:\n  name: db\n",
+		},
+		DryRunOut: map[string]string{
+			secret: "apiVersion: v1\ndata:\n  password: bmV3\n  user: YWRtaW4=\n  token: dG9rZW4=\nkind: Secret\nmetadata:\n  name: db\n",
+		},
+	}
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "secret.yaml"), []byte(secret), 0o666)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "2m",
+		DryRun:  "none",
+		Diff:    true,
+	})
+
+	assert.ErrorIs(t, err, ErrDifferences)
+	logMock.Check(t, []string{
+		"info: --- live/secret/db\n+++ merged/secret/db\n@@ -1,7 +1,7 @@\n apiVersion: v1\n data:\n-  key: '***'\n-  password: '*** (before)'\n+  password: '*** (after)'\n+  token: '***'\n   user: '***'\n kind: Secret\n metadata:\n",
+	})
+}
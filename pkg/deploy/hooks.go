@@ -0,0 +1,159 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/apex/log"
+
+	"github.com/buildtool/build-tools/pkg/cli"
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/file"
+)
+
+// phase is a point during a deploy where hooks are run
+type phase string
+
+const (
+	preApply    phase = "pre-apply"
+	postApply   phase = "post-apply"
+	postRollout phase = "post-rollout"
+	onFailure   phase = "on-failure"
+)
+
+// hooks runs the scripts in a directory per phase (i.e. k8s/hooks/pre-apply) followed by
+// the commands configured for the target
+type hooks struct {
+	dir        string
+	workDir    string
+	target     string
+	configured config.Hooks
+	env        []string
+	dryRun     bool
+}
+
+func newHooks(dir, target string, configured config.Hooks, data hookData, dryRun bool) hooks {
+	env := append(os.Environ(),
+		fmt.Sprintf("IMAGE=%s", data.image),
+		fmt.Sprintf("COMMIT=%s", data.commit),
+		fmt.Sprintf("TARGET=%s", target),
+		fmt.Sprintf("CONTEXT=%s", data.context),
+		fmt.Sprintf("NAMESPACE=%s", data.namespace),
+	)
+	if data.kubeconfig != "" {
+		env = append(env, fmt.Sprintf("KUBECONFIG=%s", data.kubeconfig))
+	}
+	return hooks{
+		dir:        filepath.Join(dir, "k8s", "hooks"),
+		workDir:    dir,
+		target:     target,
+		configured: configured,
+		env:        env,
+		dryRun:     dryRun,
+	}
+}
+
+// hookData is passed to hooks as environment variables
+type hookData struct {
+	image      string
+	commit     string
+	context    string
+	namespace  string
+	kubeconfig string
+}
+
+func (h hooks) commands(p phase) []string {
+	switch p {
+	case preApply:
+		return h.configured.PreApply
+	case postApply:
+		return h.configured.PostApply
+	case postRollout:
+		return h.configured.PostRollout
+	case onFailure:
+		return h.configured.OnFailure
+	}
+	return nil
+}
+
+func (h hooks) scripts(p phase) ([]string, error) {
+	dir := filepath.Join(h.dir, string(p))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+	files, err := file.FindHooksForTarget(dir, h.target)
+	if err != nil {
+		return nil, err
+	}
+	var scripts []string
+	for _, f := range files {
+		scripts = append(scripts, filepath.Join(dir, f.Name()))
+	}
+	return scripts, nil
+}
+
+// run runs the hooks for phase in order, stopping at the first failing hook
+func (h hooks) run(p phase) error {
+	scripts, err := h.scripts(p)
+	if err != nil {
+		return err
+	}
+	for _, script := range scripts {
+		name := filepath.Base(script)
+		if h.dryRun {
+			log.Infof("Not executing %s hook '<yellow>%s</yellow>' in dry run\n", p, name)
+			continue
+		}
+		log.Infof("Running %s hook '<green>%s</green>'\n", p, name)
+		if err := execFile(script, h.env); err != nil {
+			return fmt.Errorf("%s hook '%s' failed: %w", p, name, err)
+		}
+	}
+	for _, command := range h.commands(p) {
+		if h.dryRun {
+			log.Infof("Not executing %s hook '<yellow>%s</yellow>' in dry run\n", p, command)
+			continue
+		}
+		log.Infof("Running %s hook '<green>%s</green>'\n", p, command)
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Dir = h.workDir
+		cmd.Env = h.env
+		cmd.Stdout = cli.NewWriter(log.Log)
+		cmd.Stderr = cli.NewWriter(log.Log)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook '%s' failed: %w", p, command, err)
+		}
+	}
+	return nil
+}
+
+// failed runs the on-failure hooks after a failed deploy, errors are only logged since the deploy has already failed
+func (h hooks) failed() {
+	if err := h.run(onFailure); err != nil {
+		log.Errorf("%v\n", err)
+	}
+}
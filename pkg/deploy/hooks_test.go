@@ -0,0 +1,201 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/args"
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/kubectl"
+)
+
+const recordingHook = "#!/bin/sh\necho \"$(basename $0) $IMAGE $COMMIT $TARGET $CONTEXT $NAMESPACE $KUBECONFIG\" >> $HOOK_LOG\n"
+
+func writeHook(t *testing.T, dir string, p phase, name, content string) {
+	t.Helper()
+	hookDir := filepath.Join(dir, "k8s", "hooks", string(p))
+	assert.NoError(t, os.MkdirAll(hookDir, 0o777))
+	assert.NoError(t, os.WriteFile(filepath.Join(hookDir, name), []byte(content), 0o777))
+}
+
+func hookProject(t *testing.T) (string, string) {
+	t.Helper()
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: image\n"), 0o666)
+	hookLog := filepath.Join(name, "hooks.log")
+	t.Setenv("HOOK_LOG", hookLog)
+	return name, hookLog
+}
+
+func TestDeploy_Hooks(t *testing.T) {
+	name, hookLog := hookProject(t)
+	writeHook(t, name, preApply, "migrate.sh", recordingHook)
+	writeHook(t, name, preApply, "seed-prod.sh", recordingHook)
+	writeHook(t, name, preApply, "seed-test.sh", recordingHook)
+	writeHook(t, name, postApply, "annotate.sh", recordingHook)
+	writeHook(t, name, postRollout, "smoke.sh", recordingHook)
+	writeHook(t, name, onFailure, "notify.sh", recordingHook)
+	client := &kubectl.MockKubectl{
+		Responses:      []error{nil},
+		Status:         true,
+		KubeconfigFile: "/tmp/kubeconfig",
+	}
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals:   args.Globals{},
+		Target:    "prod",
+		Context:   "prod-cluster",
+		Namespace: "apps",
+		Tag:       "abc123",
+		Timeout:   "2m",
+		hooks: config.Hooks{
+			PreApply:    []string{"echo configured $TARGET >> $HOOK_LOG"},
+			PostRollout: []string{"test -f k8s/deploy.yaml && echo configured-post-rollout >> $HOOK_LOG"},
+		},
+	})
+
+	assert.NoError(t, err)
+	content, err := os.ReadFile(hookLog)
+	assert.NoError(t, err)
+	assert.Equal(t, `migrate.sh registryUrl/image:abc123 abc123 prod prod-cluster apps /tmp/kubeconfig
+seed-prod.sh registryUrl/image:abc123 abc123 prod prod-cluster apps /tmp/kubeconfig
+configured prod
+annotate.sh registryUrl/image:abc123 abc123 prod prod-cluster apps /tmp/kubeconfig
+smoke.sh registryUrl/image:abc123 abc123 prod prod-cluster apps /tmp/kubeconfig
+configured-post-rollout
+`, string(content))
+	logMock.Check(t, []string{
+		"info: Running pre-apply hook '<green>migrate.sh</green>'\n",
+		"info: Running pre-apply hook '<green>seed-prod.sh</green>'\n",
+		"info: Running pre-apply hook '<green>echo configured $TARGET >> $HOOK_LOG</green>'\n",
+		"info: Running post-apply hook '<green>annotate.sh</green>'\n",
+		"info: Waiting for <green>deployment/image</green> to become ready\n",
+		"info: <green>deployment/image</green> is ready\n",
+		"info: Running post-rollout hook '<green>smoke.sh</green>'\n",
+		"info: Running post-rollout hook '<green>test -f k8s/deploy.yaml && echo configured-post-rollout >> $HOOK_LOG</green>'\n",
+	})
+}
+
+func TestDeploy_PreApplyHookFails(t *testing.T) {
+	name, hookLog := hookProject(t)
+	writeHook(t, name, preApply, "migrate.sh", "#!/bin/sh\nexit 3\n")
+	writeHook(t, name, onFailure, "notify.sh", recordingHook)
+	client := &kubectl.MockKubectl{Status: true}
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "2m",
+	})
+
+	assert.EqualError(t, err, "pre-apply hook 'migrate.sh' failed: exit status 3")
+	assert.Empty(t, client.Inputs)
+	content, err := os.ReadFile(hookLog)
+	assert.NoError(t, err)
+	assert.Equal(t, "notify.sh registryUrl/image:abc123 abc123 prod   \n", string(content))
+	logMock.Check(t, []string{
+		"info: Running pre-apply hook '<green>migrate.sh</green>'\n",
+		"info: Running on-failure hook '<green>notify.sh</green>'\n",
+	})
+}
+
+func TestDeploy_OnFailureHooks(t *testing.T) {
+	name, hookLog := hookProject(t)
+	writeHook(t, name, postRollout, "smoke.sh", recordingHook)
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+		Status:    false,
+	}
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "2m",
+		hooks: config.Hooks{
+			OnFailure: []string{"echo failed >> $HOOK_LOG", "exit 1", "echo not reached >> $HOOK_LOG"},
+		},
+	})
+
+	assert.EqualError(t, err, "failed to rollout deployment/image")
+	content, err := os.ReadFile(hookLog)
+	assert.NoError(t, err)
+	assert.Equal(t, "failed\n", string(content))
+	logMock.Check(t, []string{
+		"info: Waiting for <green>deployment/image</green> to become ready\n",
+		"error: Rollout of <red>deployment/image</red> failed. Fetching diagnostics.\n",
+		"error: Diagnostics for deployment/image:\n  Pod image-1 (Running):\n",
+		"info: Running on-failure hook '<green>echo failed >> $HOOK_LOG</green>'\n",
+		"info: Running on-failure hook '<green>exit 1</green>'\n",
+		"error: on-failure hook 'exit 1' failed: exit status 1\n",
+	})
+}
+
+func TestDeploy_HooksDryRun(t *testing.T) {
+	name, hookLog := hookProject(t)
+	writeHook(t, name, preApply, "migrate.sh", recordingHook)
+	client := &kubectl.MockKubectl{
+		DryRunOut: map[string]string{},
+	}
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "2m",
+		DryRun:  "client",
+		hooks: config.Hooks{
+			PostApply: []string{"echo post-apply >> $HOOK_LOG"},
+		},
+	})
+
+	assert.NoError(t, err)
+	_, err = os.Stat(hookLog)
+	assert.True(t, os.IsNotExist(err))
+	logMock.Check(t, []string{
+		"info: Not executing pre-apply hook '<yellow>migrate.sh</yellow>' in dry run\n",
+		"info: <green>deployment/image</green> would be applied (client dry run)\n",
+		"info: Not executing post-apply hook '<yellow>echo post-apply >> $HOOK_LOG</yellow>' in dry run\n",
+	})
+}
@@ -0,0 +1,145 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deploy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/apex/log"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/buildtool/build-tools/pkg/kubectl"
+)
+
+// pruneLabel is added to all applied objects when pruning, to be able to find objects which are no longer deployed
+const pruneLabel = "build-tools/deploy"
+
+// pruneKinds are always searched for objects to prune, in addition to the kinds in the applied descriptors.
+// Cluster scoped kinds like Namespaces are left out on purpose.
+var pruneKinds = []kubectl.Object{
+	{APIVersion: "v1", Kind: "ConfigMap"},
+	{APIVersion: "v1", Kind: "Secret"},
+	{APIVersion: "v1", Kind: "Service"},
+	{APIVersion: "v1", Kind: "ServiceAccount"},
+	{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+	{APIVersion: "apps/v1", Kind: "Deployment"},
+	{APIVersion: "apps/v1", Kind: "StatefulSet"},
+	{APIVersion: "apps/v1", Kind: "DaemonSet"},
+	{APIVersion: "batch/v1", Kind: "Job"},
+	{APIVersion: "batch/v1", Kind: "CronJob"},
+	{APIVersion: "networking.k8s.io/v1", Kind: "Ingress"},
+	{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+	{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+	{APIVersion: "policy/v1", Kind: "PodDisruptionBudget"},
+	{APIVersion: "autoscaling/v2", Kind: "HorizontalPodAutoscaler"},
+	{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"},
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// pruneLabelValue identifies the application and target, hashed if too long to be a label value
+func pruneLabelValue(buildName, target string) string {
+	value := buildName
+	if target != "" {
+		value = fmt.Sprintf("%s.%s", buildName, target)
+	}
+	value = strings.Trim(invalidLabelChars.ReplaceAllString(value, "-"), "-_.")
+	if len(value) > 63 {
+		value = fmt.Sprintf("%x", sha256.Sum256([]byte(value)))[:63]
+	}
+	return value
+}
+
+// prune deletes objects labeled for buildName and target which were not applied in this deploy
+func prune(state *applied, buildName, target string, dryRun bool, client kubectl.Kubectl) error {
+	current, err := kubectl.Objects(strings.Join(state.manifests, "\n---\n"))
+	if err != nil {
+		return err
+	}
+	var kinds []string
+	for _, kind := range pruneKinds {
+		kinds = append(kinds, kind.Type())
+	}
+	namespaces := []string{""}
+	for _, object := range current {
+		if kind := object.Type(); !slices.Contains(kinds, kind) {
+			kinds = append(kinds, kind)
+		}
+		if !slices.Contains(namespaces, object.Namespace) {
+			namespaces = append(namespaces, object.Namespace)
+		}
+	}
+	selector := fmt.Sprintf("%s=%s", pruneLabel, pruneLabelValue(buildName, target))
+	var stale []kubectl.Object
+	for _, namespace := range namespaces {
+		found, err := client.List(kinds, selector, namespace)
+		if err != nil {
+			return fmt.Errorf("failed to find objects to prune: %w", err)
+		}
+		for _, object := range found {
+			if !slices.Contains(stale, object) && !isApplied(object, current) {
+				stale = append(stale, object)
+			}
+		}
+	}
+	if len(stale) == 0 {
+		log.Info("Nothing to prune\n")
+		return nil
+	}
+	for _, object := range stale {
+		if dryRun {
+			log.Infof("Would prune <yellow>%s</yellow> in namespace <yellow>%s</yellow>\n", object, object.Namespace)
+			continue
+		}
+		if err := client.Delete(object); err != nil {
+			return fmt.Errorf("failed to prune %s: %w", object, err)
+		}
+		log.Infof("Pruned <yellow>%s</yellow> in namespace <yellow>%s</yellow>\n", object, object.Namespace)
+		state.pruned = append(state.pruned, object)
+	}
+	return nil
+}
+
+// isApplied checks if object is among the applied objects, where objects without namespace are
+// considered to match any namespace to never delete something that was just applied.
+func isApplied(object kubectl.Object, current []kubectl.Object) bool {
+	for _, c := range current {
+		if c.Kind == object.Kind && c.Name == object.Name && (c.Namespace == "" || c.Namespace == object.Namespace) && sameGroup(c, object) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameGroup compares only the group of the apiVersions since the cluster may list an object in another version
+// than it was applied with. Objects with unknown apiVersion are considered to be in the same group.
+func sameGroup(a, b kubectl.Object) bool {
+	if a.APIVersion == "" || b.APIVersion == "" {
+		return true
+	}
+	return schema.FromAPIVersionAndKind(a.APIVersion, a.Kind).Group == schema.FromAPIVersionAndKind(b.APIVersion, b.Kind).Group
+}
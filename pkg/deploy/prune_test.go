@@ -0,0 +1,202 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/args"
+	"github.com/buildtool/build-tools/pkg/kubectl"
+)
+
+func TestPruneLabelValue(t *testing.T) {
+	assert.Equal(t, "image.prod", pruneLabelValue("image", "prod"))
+	assert.Equal(t, "image", pruneLabelValue("image", ""))
+	assert.Equal(t, "my-image.prod-eu", pruneLabelValue("my/image", "prod eu"))
+	long := pruneLabelValue(strings.Repeat("a", 60), "prod")
+	assert.Equal(t, 63, len(long))
+	assert.Regexp(t, "^[0-9a-f]+$", long)
+	assert.Equal(t, long, pruneLabelValue(strings.Repeat("a", 60), "prod"))
+}
+
+func writePruneDescriptors(t *testing.T) string {
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config-v2
+---
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: image
+  namespace: monitoring
+`), 0o666)
+	return name
+}
+
+func TestDeploy_Prune(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+		Listed: map[string][]kubectl.Object{
+			"": {
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "config-v1", Namespace: "default"},
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "config-v2", Namespace: "default"},
+				{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Name: "image", Namespace: "default"},
+			},
+			"monitoring": {
+				{APIVersion: "monitoring.coreos.com/v1", Kind: "ServiceMonitor", Name: "image", Namespace: "monitoring"},
+				{APIVersion: "example.org/v1", Kind: "ServiceMonitor", Name: "image", Namespace: "monitoring"},
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "config-v2", Namespace: "monitoring"},
+			},
+		},
+	}
+	name := writePruneDescriptors(t)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	outcome := &Outcome{}
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Target:  "prod",
+		Tag:     "abc123",
+		Timeout: "2m",
+		Prune:   true,
+		outcome: outcome,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`apiVersion: v1
+kind: ConfigMap
+metadata:
+  labels:
+    build-tools/deploy: image.prod
+  name: config-v2
+---
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  labels:
+    build-tools/deploy: image.prod
+  name: image
+  namespace: monitoring
+`}, client.Inputs)
+	kinds := "ConfigMap.v1.,Secret.v1.,Service.v1.,ServiceAccount.v1.,PersistentVolumeClaim.v1.," +
+		"Deployment.v1.apps,StatefulSet.v1.apps,DaemonSet.v1.apps,Job.v1.batch,CronJob.v1.batch," +
+		"Ingress.v1.networking.k8s.io,Role.v1.rbac.authorization.k8s.io,RoleBinding.v1.rbac.authorization.k8s.io," +
+		"PodDisruptionBudget.v1.policy,HorizontalPodAutoscaler.v2.autoscaling,NetworkPolicy.v1.networking.k8s.io," +
+		"ServiceMonitor.v1.monitoring.coreos.com"
+	assert.Equal(t, []string{
+		kinds + " -l build-tools/deploy=image.prod -n ",
+		kinds + " -l build-tools/deploy=image.prod -n monitoring",
+	}, client.ListCalls)
+	assert.Equal(t, []kubectl.Object{
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "config-v1", Namespace: "default"},
+		{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Name: "image", Namespace: "default"},
+		{APIVersion: "example.org/v1", Kind: "ServiceMonitor", Name: "image", Namespace: "monitoring"},
+	}, client.Deleted)
+	assert.Equal(t, client.Deleted, outcome.Pruned)
+	logMock.Check(t, []string{
+		"info: Pruned <yellow>configmap/config-v1</yellow> in namespace <yellow>default</yellow>\n",
+		"info: Pruned <yellow>ingress/image</yellow> in namespace <yellow>default</yellow>\n",
+		"info: Pruned <yellow>servicemonitor/image</yellow> in namespace <yellow>monitoring</yellow>\n",
+	})
+}
+
+func TestDeploy_PruneDryRun(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+		Listed: map[string][]kubectl.Object{
+			"": {{Kind: "ConfigMap", Name: "config-v1", Namespace: "default"}},
+		},
+	}
+	name := writePruneDescriptors(t)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals:     args.Globals{},
+		Target:      "prod",
+		Tag:         "abc123",
+		NoWait:      true,
+		PruneDryRun: true,
+	})
+
+	assert.NoError(t, err)
+	assert.Empty(t, client.Deleted)
+	logMock.Check(t, []string{
+		"info: Not waiting for deployment to succeed\n",
+		"info: Would prune <yellow>configmap/config-v1</yellow> in namespace <yellow>default</yellow>\n",
+	})
+}
+
+func TestDeploy_PruneNothing(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+	}
+	name := writePruneDescriptors(t)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Tag:     "abc123",
+		Timeout: "2m",
+		Prune:   true,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(client.ListCalls))
+	logMock.Check(t, []string{"info: Nothing to prune\n"})
+}
+
+func TestDeploy_PruneNotAfterFailedRollout(t *testing.T) {
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil},
+		Failing:   []string{"image"},
+	}
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: image\n"), 0o666)
+
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Globals: args.Globals{},
+		Tag:     "abc123",
+		Timeout: "2m",
+		Prune:   true,
+	})
+
+	assert.EqualError(t, err, "failed to rollout deployment/image")
+	assert.Empty(t, client.ListCalls)
+}
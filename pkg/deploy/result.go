@@ -0,0 +1,167 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/buildtool/build-tools/pkg/ci"
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/kubectl"
+	"github.com/buildtool/build-tools/pkg/notify"
+)
+
+const (
+	statusSucceeded   = "succeeded"
+	statusFailed      = "failed"
+	statusDifferences = "differences"
+	statusSkipped     = "skipped"
+)
+
+// stdout is where the result is written with --output json, logs are written to stderr instead
+var stdout io.Writer = os.Stdout
+
+var now = time.Now
+
+// Result is the machine-readable result of a deploy, written with --output json
+type Result struct {
+	Target string `json:"target"`
+	Image  string `json:"image,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	Outcome
+	// Clusters contains the outcome for each cluster when deploying to multiple clusters
+	Clusters []*ClusterOutcome `json:"clusters,omitempty"`
+	started  time.Time
+}
+
+// Outcome is what happened when deploying to a cluster
+type Outcome struct {
+	Context         string                 `json:"context,omitempty"`
+	Namespace       string                 `json:"namespace,omitempty"`
+	DryRun          string                 `json:"dryRun,omitempty"`
+	Status          string                 `json:"status"`
+	Error           string                 `json:"error,omitempty"`
+	DurationSeconds float64                `json:"durationSeconds"`
+	Applied         []kubectl.ApplyResult  `json:"applied,omitempty"`
+	Rollouts        []Rollout              `json:"rollouts,omitempty"`
+	Diagnostics     []*kubectl.Diagnostics `json:"diagnostics,omitempty"`
+	RolledBack      bool                   `json:"rolledBack,omitempty"`
+	Pruned          []kubectl.Object       `json:"pruned,omitempty"`
+}
+
+// ClusterOutcome is the outcome of deploying to one of multiple clusters
+type ClusterOutcome struct {
+	Cluster string `json:"cluster"`
+	Outcome
+}
+
+// Rollout is the rollout status of a workload
+type Rollout struct {
+	kubectl.Object
+	Ready           bool    `json:"ready"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+func newResult(target string) *Result {
+	return &Result{Target: target, started: now()}
+}
+
+// failed records err as the reason for the deploy failing
+func (r *Result) failed(err error) {
+	r.Error = err.Error()
+}
+
+// complete sets the status given by the exit code and the duration of the deploy
+func (r *Result) complete(code int) {
+	switch code {
+	case 0:
+		r.Status = statusSucceeded
+	case -6:
+		r.Status = statusDifferences
+	default:
+		r.Status = statusFailed
+	}
+	r.DurationSeconds = since(r.started)
+}
+
+// write writes the result as JSON to stdout, with the status given by the exit code
+func (r *Result) write(code int) error {
+	r.complete(code)
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// record records the outcome of Deploy from state, the outcome can be nil if Deploy isn't called by DoDeploy
+func (o *Outcome) record(deployArgs Args, state *applied, started time.Time, err error) {
+	if o == nil {
+		return
+	}
+	o.Context = deployArgs.Context
+	o.Namespace = deployArgs.Namespace
+	o.DryRun = state.dryRun
+	o.Applied = state.results
+	o.Rollouts = state.rollouts
+	o.Diagnostics = state.diagnostics
+	o.RolledBack = state.rolledBack
+	o.Pruned = state.pruned
+	o.DurationSeconds = since(started)
+	switch {
+	case err == nil:
+		o.Status = statusSucceeded
+	case errors.Is(err, ErrDifferences):
+		o.Status = statusDifferences
+	default:
+		o.Status = statusFailed
+		o.Error = err.Error()
+	}
+}
+
+func since(started time.Time) float64 {
+	return now().Sub(started).Seconds()
+}
+
+// notifyCompleted sends notifications about the completed deploy, failing to do so doesn't fail the deploy
+func notifyCompleted(cfg *config.NotificationsConfig, currentCI ci.CI, result *Result, code int) {
+	result.complete(code)
+	err := notify.Send(cfg, notify.Event{
+		Command:  "deploy",
+		Target:   result.Target,
+		App:      currentCI.BuildName(),
+		Commit:   result.Commit,
+		Branch:   currentCI.Branch(),
+		Image:    result.Image,
+		Outcome:  result.Status,
+		Error:    result.Error,
+		Duration: time.Duration(result.DurationSeconds * float64(time.Second)).Round(time.Second),
+	})
+	if err != nil {
+		log.Warnf("Failed to send notifications: %v\n", err)
+	}
+}
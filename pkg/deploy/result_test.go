@@ -0,0 +1,333 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg"
+	"github.com/buildtool/build-tools/pkg/kubectl"
+	"github.com/buildtool/build-tools/pkg/version"
+)
+
+func fixedNow(t *testing.T) {
+	current := now
+	now = func() time.Time { return time.Date(2024, 5, 13, 17, 22, 36, 0, time.UTC) }
+	t.Cleanup(func() { now = current })
+}
+
+func captureStdout(t *testing.T) *bytes.Buffer {
+	current := stdout
+	buffer := &bytes.Buffer{}
+	stdout = buffer
+	t.Cleanup(func() { stdout = current })
+	return buffer
+}
+
+func TestDeploy_Outcome(t *testing.T) {
+	fixedNow(t)
+	client := &kubectl.MockKubectl{
+		Responses: []error{nil, nil},
+		Failing:   []string{"image"},
+		Status:    true,
+		Existing: map[string]string{
+			"deployment/image": "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: image\n",
+		},
+	}
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte("apiVersion: v1\nkind: Service\nmetadata:\n  name: image\n---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: image\n---\napiVersion: apps/v1\nkind: StatefulSet\nmetadata:\n  name: cache\n"), 0o666)
+
+	log.SetHandler(mocks.New())
+	outcome := &Outcome{}
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Context:           "prod",
+		Namespace:         "apps",
+		Tag:               "abc123",
+		Timeout:           "2m",
+		RollbackOnFailure: true,
+		outcome:           outcome,
+	})
+
+	assert.EqualError(t, err, "failed to rollout deployment/image, rolled back to previous versions")
+	assert.Equal(t, &Outcome{
+		Context:   "prod",
+		Namespace: "apps",
+		Status:    "failed",
+		Error:     "failed to rollout deployment/image, rolled back to previous versions",
+		Applied: []kubectl.ApplyResult{
+			{Object: kubectl.Object{APIVersion: "v1", Kind: "Service", Name: "image"}, Result: "serverside-applied"},
+			{Object: kubectl.Object{APIVersion: "apps/v1", Kind: "Deployment", Name: "image"}, Result: "serverside-applied"},
+			{Object: kubectl.Object{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "cache"}, Result: "serverside-applied"},
+		},
+		Rollouts: []Rollout{
+			{Object: kubectl.Object{APIVersion: "apps/v1", Kind: "Deployment", Name: "image"}, Ready: false},
+			{Object: kubectl.Object{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "cache"}, Ready: true},
+		},
+		Diagnostics: []*kubectl.Diagnostics{{Workload: kubectl.Object{APIVersion: "apps/v1", Kind: "Deployment", Name: "image"}, Pods: []kubectl.PodDiagnostics{{Name: "image-1", Phase: "Running"}}}},
+		RolledBack:  true,
+	}, outcome)
+}
+
+func TestDeploy_OutcomeDryRun(t *testing.T) {
+	fixedNow(t)
+	client := &kubectl.MockKubectl{}
+	name := t.TempDir()
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n"), 0o666)
+
+	log.SetHandler(mocks.New())
+	outcome := &Outcome{}
+	err := Deploy(name, "registryUrl", "image", "20190513-17:22:36", client, Args{
+		Tag:     "abc123",
+		Timeout: "2m",
+		DryRun:  "client",
+		outcome: outcome,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Outcome{
+		DryRun:  "client",
+		Status:  "succeeded",
+		Applied: []kubectl.ApplyResult{{Object: kubectl.Object{APIVersion: "v1", Kind: "ConfigMap", Name: "config"}, Result: "dry-run"}},
+	}, outcome)
+}
+
+func TestDoDeploy_OutputJSON(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	fixedNow(t)
+	out := captureStdout(t)
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = server.Client().Transport
+	defer func() { http.DefaultClient.Transport = transport }()
+	host := strings.TrimPrefix(server.URL, "https://")
+	name := t.TempDir()
+	yaml := fmt.Sprintf(`
+registry:
+  gitlab:
+    registry: %s/group
+targets:
+  prod:
+    context: prod
+`, host)
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	got := DoDeploy(name, version.Info{}, "prod", "--tag", "abc123", "--verify-image", "--output", "json")
+
+	assert.Equal(t, -4, got)
+	assert.JSONEq(t, fmt.Sprintf(`{
+  "target": "prod",
+  "image": "%[1]s/group/deploy:abc123",
+  "commit": "abc123",
+  "status": "failed",
+  "error": "image not found: '%[1]s/group/deploy:abc123' doesn't exist in Gitlab, make sure that it has been built and pushed",
+  "durationSeconds": 0
+}`, host), out.String())
+	logMock.Check(t, []string{
+		"info: Using passed tag <green>abc123</green> to deploy",
+		fmt.Sprintf("error: image not found: '%s/group/deploy:abc123' doesn't exist in Gitlab, make sure that it has been built and pushed", host),
+	})
+}
+
+func TestDoDeploy_OutputJSON_RolloutFailed(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	fixedNow(t)
+	out := captureStdout(t)
+	server := httptest.NewServer(apiServer(`{"observedGeneration":1,"replicas":1,"conditions":[{"type":"Progressing","status":"False","reason":"ProgressDeadlineExceeded"}]}`))
+	defer server.Close()
+	name := t.TempDir()
+	kubeconfig := filepath.Join(name, "kubeconfig")
+	_ = os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- {name: prod, cluster: {server: %s}}
+contexts:
+- {name: prod, context: {cluster: prod, namespace: default}}
+users: []
+`, server.URL)), 0o666)
+	yaml := fmt.Sprintf(`
+registry:
+  dockerhub:
+    namespace: group
+targets:
+  prod:
+    context: prod
+    kubeconfig: %s
+`, kubeconfig)
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+	_ = os.Mkdir(filepath.Join(name, "k8s"), 0o777)
+	_ = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n"), 0o666)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	got := DoDeploy(name, version.Info{}, "prod", "--tag", "abc123", "--output", "json")
+
+	assert.Equal(t, -4, got)
+	result := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &result))
+	assert.Equal(t, "failed", result["status"])
+	assert.Contains(t, logMock.Logged, "error: deployment \"app\" exceeded its progress deadline\n")
+}
+
+func TestDoDeploy_OutputJSON_NoContext(t *testing.T) {
+	fixedNow(t)
+	out := captureStdout(t)
+	name := t.TempDir()
+
+	log.SetHandler(mocks.New())
+	got := DoDeploy(name, version.Info{}, "prod", "--output", "json")
+
+	assert.Equal(t, -5, got)
+	assert.Equal(t, `{
+  "target": "prod",
+  "status": "failed",
+  "error": "context is mandatory, not found in configuration for prod and not passed as parameter",
+  "durationSeconds": 0
+}
+`, out.String())
+}
+
+func TestDoDeploy_Notifications(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	fixedNow(t)
+	var notifications []string
+	hooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		notifications = append(notifications, r.URL.Path+" "+string(body))
+	}))
+	defer hooks.Close()
+	registryServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer registryServer.Close()
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = registryServer.Client().Transport
+	defer func() { http.DefaultClient.Transport = transport }()
+	host := strings.TrimPrefix(registryServer.URL, "https://")
+	name := t.TempDir()
+	yaml := fmt.Sprintf(`
+registry:
+  gitlab:
+    registry: %s/group
+targets:
+  prod:
+    context: prod
+notifications:
+  slack:
+    - url: %[2]s/slack
+      on: failure
+  webhooks:
+    - url: %[2]s/webhook
+      targets:
+        - prod
+      message: "{{ .App }} not deployed"
+    - url: %[2]s/staging
+      targets:
+        - staging
+`, host, hooks.URL)
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+
+	log.SetHandler(mocks.New())
+	got := DoDeploy(name, version.Info{}, "prod", "--tag", "abc123", "--verify-image")
+
+	assert.Equal(t, -4, got)
+	notFound := fmt.Sprintf("image not found: '%s/group/deploy:abc123' doesn't exist in Gitlab, make sure that it has been built and pushed", host)
+	assert.Equal(t, []string{
+		fmt.Sprintf(`/slack {"text":"deploy (abc123) deploy to prod failed in 0s: %s"}`, notFound),
+		fmt.Sprintf(`/webhook {"command":"deploy","target":"prod","app":"deploy","commit":"abc123","image":"%s/group/deploy:abc123","outcome":"failed","error":"%s","durationSeconds":0,"message":"deploy not deployed"}`, host, notFound),
+	}, notifications)
+}
+
+func TestDoDeploy_NotificationFailed(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	name := t.TempDir()
+	yaml := `
+notifications:
+  slack:
+    - url: http://[::1
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	got := DoDeploy(name, version.Info{}, "prod", "--tag", "abc123", "--context", "missing")
+
+	assert.Equal(t, -4, got)
+	logMock.Check(t, []string{
+		"warn: no target matching prod found\n",
+		"info: Using passed tag <green>abc123</green> to deploy",
+		"error: open " + filepath.Join(name, "k8s") + ": no such file or directory",
+		"warn: Failed to send notifications: slack: parse \"http://[::1\": missing ']' in host\n",
+	})
+}
+
+func TestDoDeploy_OutputText(t *testing.T) {
+	out := captureStdout(t)
+	name := t.TempDir()
+
+	log.SetHandler(mocks.New())
+	got := DoDeploy(name, version.Info{}, "prod")
+
+	assert.Equal(t, -5, got)
+	assert.Empty(t, out.String())
+}
+
+func TestResult_Write(t *testing.T) {
+	fixedNow(t)
+	out := captureStdout(t)
+	result := newResult("prod")
+	result.Clusters = []*ClusterOutcome{
+		{Cluster: "eu", Outcome: Outcome{Context: "eu", Status: "succeeded", Pruned: []kubectl.Object{{Kind: "ConfigMap", Name: "old", Namespace: "apps"}}}},
+		{Cluster: "us", Outcome: Outcome{Status: "skipped"}},
+	}
+
+	assert.NoError(t, result.write(-6))
+	assert.JSONEq(t, `{
+  "target": "prod",
+  "status": "differences",
+  "durationSeconds": 0,
+  "clusters": [
+    {"cluster": "eu", "context": "eu", "status": "succeeded", "durationSeconds": 0, "pruned": [{"kind": "ConfigMap", "name": "old", "namespace": "apps"}]},
+    {"cluster": "us", "status": "skipped", "durationSeconds": 0}
+  ]
+}`, out.String())
+}
@@ -39,6 +39,11 @@ func FindScriptsForTarget(dir, target string) ([]os.DirEntry, error) {
 	return filesForTarget(dir, target, "script", ".sh", true)
 }
 
+// FindHooksForTarget finds hook scripts in dir, where a target specific script replaces a common script with the same base name
+func FindHooksForTarget(dir, target string) ([]os.DirEntry, error) {
+	return filesForTarget(dir, target, "hook", ".sh", false)
+}
+
 func filesForTarget(dir, target, filetype, suffix string, strict bool) ([]os.DirEntry, error) {
 	files, err := os.ReadDir(dir)
 	if err != nil {
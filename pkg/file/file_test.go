@@ -173,3 +173,33 @@ func TestFindScriptsForTarget(t *testing.T) {
 		})
 	}
 }
+
+func TestFindHooksForTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     string
+		target  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "non existing directory", dir: "testdata/not_existing", target: "local", want: []string{}, wantErr: true},
+		{name: "only common files", dir: "testdata/only_common_files", target: "local", want: []string{"setup.sh"}},
+		{name: "specific config files", dir: "testdata/specific_config_files", target: "local", want: []string{"setup-local.sh"}},
+		{name: "specific and common config files - specific", dir: "testdata/specific_and_common_config_files", target: "local", want: []string{"setup-local.sh"}},
+		{name: "specific and common config files - common", dir: "testdata/specific_and_common_config_files", target: "prod", want: []string{"setup.sh"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FindHooksForTarget(tt.dir, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FindHooksForTarget() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			files := make([]string, len(got))
+			for i, f := range got {
+				files[i] = f.Name()
+			}
+			assert.Equal(t, tt.want, files)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package helm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/releaseutil"
+)
+
+// Release contains the information needed to render a chart
+type Release struct {
+	// Name is the release name, normally the name of the application
+	Name string
+	// Namespace is the namespace the release is rendered for
+	Namespace string
+	// Target is used to find the values-<target>.yaml file in the chart
+	Target string
+	// Image is the full image name (registry/name:tag or registry/name@digest)
+	Image string
+}
+
+// IsChart returns true if dir contains a Helm chart
+func IsChart(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, chartutil.ChartfileName))
+	return err == nil && !info.IsDir()
+}
+
+// Render renders the chart in dir in-process for release and returns the resulting resources as yaml.
+// The values from values-<target>.yaml in the chart are used on top of the chart defaults and
+// image.repository, image.tag and image.digest are set from the image of the release.
+func Render(dir string, release Release) (string, error) {
+	chrt, err := loader.Load(dir)
+	if err != nil {
+		return "", fmt.Errorf("helm: %w", err)
+	}
+	values, err := targetValues(dir, release.Target)
+	if err != nil {
+		return "", err
+	}
+	repository, tag, digest := splitImage(release.Image)
+	values = chartutil.CoalesceTables(map[string]interface{}{
+		"image": map[string]interface{}{
+			"repository": repository,
+			"tag":        tag,
+			"digest":     digest,
+		},
+	}, values)
+
+	namespace := release.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	options := chartutil.ReleaseOptions{
+		Name:      release.Name,
+		Namespace: namespace,
+		Revision:  1,
+		IsInstall: true,
+	}
+	renderValues, err := chartutil.ToRenderValues(chrt, values, options, chartutil.DefaultCapabilities)
+	if err != nil {
+		return "", fmt.Errorf("helm: %w", err)
+	}
+	files, err := engine.Render(chrt, renderValues)
+	if err != nil {
+		return "", fmt.Errorf("helm: %w", err)
+	}
+	for name := range files {
+		if strings.HasSuffix(name, "NOTES.txt") {
+			delete(files, name)
+		}
+	}
+	hooks, manifests, err := releaseutil.SortManifests(files, nil, releaseutil.InstallOrder)
+	if err != nil {
+		return "", fmt.Errorf("helm: %w", err)
+	}
+	for _, hook := range hooks {
+		log.Warnf("ignoring helm hook '<yellow>%s</yellow>' in %s\n", hook.Name, hook.Path)
+	}
+	var out strings.Builder
+	for _, manifest := range manifests {
+		_, _ = fmt.Fprintf(&out, "---\n# Source: %s\n%s\n", manifest.Name, manifest.Content)
+	}
+	return out.String(), nil
+}
+
+func targetValues(dir, target string) (map[string]interface{}, error) {
+	if target == "" {
+		return map[string]interface{}{}, nil
+	}
+	valuesFile := filepath.Join(dir, fmt.Sprintf("values-%s.yaml", target))
+	if _, err := os.Stat(valuesFile); os.IsNotExist(err) {
+		log.Debugf("no values file '<yellow>%s</yellow>' for target: <green>%s</green>\n", filepath.Base(valuesFile), target)
+		return map[string]interface{}{}, nil
+	}
+	log.Debugf("using values file '<green>%s</green>' for target: <green>%s</green>\n", filepath.Base(valuesFile), target)
+	values, err := chartutil.ReadValuesFile(valuesFile)
+	if err != nil {
+		return nil, fmt.Errorf("helm: %w", err)
+	}
+	return values.AsMap(), nil
+}
+
+// splitImage splits image into repository, tag and digest
+func splitImage(image string) (repository, tag, digest string) {
+	repository = image
+	if i := strings.LastIndex(repository, "@"); i > 0 {
+		repository, digest = repository[:i], repository[i+1:]
+	}
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, tag = repository[:i], repository[i+1:]
+	}
+	return repository, tag, digest
+}
@@ -0,0 +1,272 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package helm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+)
+
+func writeChart(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o777))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o666))
+	}
+}
+
+var chart = map[string]string{
+	"Chart.yaml": `apiVersion: v2
+name: app
+version: 0.1.0
+`,
+	"values.yaml": `replicas: 1
+image:
+  repository: nginx
+  tag: latest
+`,
+	"values-prod.yaml": `replicas: 3
+image:
+  tag: ignored
+`,
+	"templates/_helpers.tpl": `{{- define "app.labels" -}}
+app: {{ .Release.Name }}
+{{- end }}`,
+	"templates/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}
+  namespace: {{ .Release.Namespace }}
+  labels:
+    {{- include "app.labels" . | nindent 4 }}
+spec:
+  replicas: {{ .Values.replicas }}
+  template:
+    spec:
+      containers:
+        - name: app
+          image: "{{ .Values.image.repository }}{{ if .Values.image.digest }}@{{ .Values.image.digest }}{{ else }}:{{ .Values.image.tag }}{{ end }}"`,
+	"templates/service.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Release.Name }}`,
+	"templates/hook.yaml": `apiVersion: v1
+kind: Pod
+metadata:
+  name: test
+  annotations:
+    helm.sh/hook: test`,
+	"templates/NOTES.txt": `Installed {{ .Release.Name }}`,
+}
+
+func TestIsChart(t *testing.T) {
+	dir := t.TempDir()
+	assert.False(t, IsChart(dir))
+	assert.False(t, IsChart(filepath.Join(dir, "missing")))
+	writeChart(t, dir, map[string]string{"Chart.yaml": "name: app"})
+	assert.True(t, IsChart(dir))
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name       string
+		release    Release
+		want       string
+		wantLogged []string
+	}{
+		{
+			name:    "target values",
+			release: Release{Name: "app", Namespace: "prod-ns", Target: "prod", Image: "registry/app:abc123"},
+			want: `---
+# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+---
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: prod-ns
+  labels:
+    app: app
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: app
+          image: "registry/app:abc123"
+`,
+			wantLogged: []string{
+				"debug: using values file '<green>values-prod.yaml</green>' for target: <green>prod</green>\n",
+				"warn: ignoring helm hook '<yellow>test</yellow>' in app/templates/hook.yaml\n",
+			},
+		},
+		{
+			name:    "no target values",
+			release: Release{Name: "other", Target: "staging", Image: "registry/app:abc123"},
+			want: `---
+# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: other
+---
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: other
+  namespace: default
+  labels:
+    app: other
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+        - name: app
+          image: "registry/app:abc123"
+`,
+			wantLogged: []string{
+				"debug: no values file '<yellow>values-staging.yaml</yellow>' for target: <green>staging</green>\n",
+				"warn: ignoring helm hook '<yellow>test</yellow>' in app/templates/hook.yaml\n",
+			},
+		},
+		{
+			name:    "pinned image",
+			release: Release{Name: "app", Target: "staging", Image: "registry/app@sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7"},
+			want: `---
+# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+---
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: default
+  labels:
+    app: app
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+        - name: app
+          image: "registry/app@sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7"
+`,
+			wantLogged: []string{
+				"debug: no values file '<yellow>values-staging.yaml</yellow>' for target: <green>staging</green>\n",
+				"warn: ignoring helm hook '<yellow>test</yellow>' in app/templates/hook.yaml\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logMock := mocks.New()
+			log.SetHandler(logMock)
+			log.SetLevel(log.DebugLevel)
+			dir := t.TempDir()
+			writeChart(t, dir, chart)
+
+			got, err := Render(dir, tt.release)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			logMock.Check(t, tt.wantLogged)
+		})
+	}
+}
+
+func TestRender_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name:    "invalid chart",
+			files:   map[string]string{"Chart.yaml": "name: app"},
+			wantErr: "helm: validation: chart.metadata.version is required",
+		},
+		{
+			name: "invalid target values",
+			files: map[string]string{
+				"Chart.yaml":       "apiVersion: v2\nname: app\nversion: 0.1.0\n",
+				"values-prod.yaml": "replicas: [",
+			},
+			wantErr: "helm: error converting YAML to JSON: yaml: line 1: did not find expected node content",
+		},
+		{
+			name: "template error",
+			files: map[string]string{
+				"Chart.yaml":          "apiVersion: v2\nname: app\nversion: 0.1.0\n",
+				"templates/test.yaml": `{{ required "host is required" .Values.host }}`,
+			},
+			wantErr: "helm: execution error at (app/templates/test.yaml:1:3): host is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeChart(t, dir, tt.files)
+
+			_, err := Render(dir, Release{Name: "app", Target: "prod", Image: "registry/app:abc123"})
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSplitImage(t *testing.T) {
+	tests := []struct {
+		image      string
+		repository string
+		tag        string
+		digest     string
+	}{
+		{image: "registry:5000/app:abc123", repository: "registry:5000/app", tag: "abc123"},
+		{image: "registry:5000/app", repository: "registry:5000/app"},
+		{image: "registry:5000/app@sha256:af534ee8", repository: "registry:5000/app", digest: "sha256:af534ee8"},
+		{image: "registry:5000/app:abc123@sha256:af534ee8", repository: "registry:5000/app", tag: "abc123", digest: "sha256:af534ee8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			repository, tag, digest := splitImage(tt.image)
+			assert.Equal(t, tt.repository, repository)
+			assert.Equal(t, tt.tag, tag)
+			assert.Equal(t, tt.digest, digest)
+		})
+	}
+}
@@ -0,0 +1,247 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package kubectl
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// logLines is the number of log lines to include for crashing containers
+var logLines = 20
+
+// Diagnostics describes why a workload failed to roll out
+type Diagnostics struct {
+	Workload Object           `json:"workload"`
+	Pods     []PodDiagnostics `json:"pods"`
+	// Events are the warning events for the workload
+	Events []Event `json:"events,omitempty"`
+}
+
+// PodDiagnostics describes a pod of a workload which isn't ready
+type PodDiagnostics struct {
+	Name       string                 `json:"name"`
+	Phase      string                 `json:"phase"`
+	Containers []ContainerDiagnostics `json:"containers"`
+	// Events are the warning events for the pod
+	Events []Event `json:"events,omitempty"`
+}
+
+// ContainerDiagnostics describes the state of a container in a pod which isn't ready
+type ContainerDiagnostics struct {
+	Name         string `json:"name"`
+	Ready        bool   `json:"ready"`
+	RestartCount int32  `json:"restartCount"`
+	// State is Waiting, Running or Terminated
+	State string `json:"state"`
+	// Reason is the reason for the current state, e.g. CrashLoopBackOff or ImagePullBackOff
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	// LastTerminationReason is the reason the container last terminated, e.g. OOMKilled or Error
+	LastTerminationReason string `json:"lastTerminationReason,omitempty"`
+	ExitCode              *int32 `json:"exitCode,omitempty"`
+	// Logs are the last lines of logs for crashing containers
+	Logs string `json:"logs,omitempty"`
+}
+
+// diagnosticsSource fetches what is needed to diagnose a workload from the cluster
+type diagnosticsSource interface {
+	workload(object Object) (*unstructured.Unstructured, error)
+	pods(namespace, selector string) ([]corev1.Pod, error)
+	logs(namespace, pod, container string, previous bool, lines int) (string, error)
+	events(object Object) ([]Event, error)
+}
+
+func diagnose(source diagnosticsSource, workload Object) (*Diagnostics, error) {
+	obj, err := source.workload(workload)
+	if err != nil {
+		return nil, err
+	}
+	diagnostics := &Diagnostics{Workload: workload}
+	if events, err := source.events(workload); err != nil {
+		return nil, err
+	} else {
+		diagnostics.Events = warnings(events)
+	}
+	selector, err := podSelector(obj)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := source.pods(obj.GetNamespace(), selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		if isHealthy(pod) {
+			continue
+		}
+		podDiagnostics := PodDiagnostics{Name: pod.Name, Phase: string(pod.Status.Phase)}
+		for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			if status.Ready || status.State.Terminated != nil && status.State.Terminated.ExitCode == 0 {
+				continue
+			}
+			container := containerDiagnostics(status)
+			if isCrashing(status) {
+				logs, err := source.logs(pod.Namespace, pod.Name, status.Name, status.State.Running == nil && status.RestartCount > 0, logLines)
+				if err != nil {
+					logs = fmt.Sprintf("unable to fetch logs: %v", err)
+				}
+				container.Logs = logs
+			}
+			podDiagnostics.Containers = append(podDiagnostics.Containers, container)
+		}
+		events, err := source.events(Object{Kind: "Pod", Name: pod.Name, Namespace: pod.Namespace})
+		if err != nil {
+			return nil, err
+		}
+		podDiagnostics.Events = warnings(events)
+		diagnostics.Pods = append(diagnostics.Pods, podDiagnostics)
+	}
+	return diagnostics, nil
+}
+
+// podSelector returns the label selector of the pods controlled by workload
+func podSelector(obj *unstructured.Unstructured) (string, error) {
+	raw, found, err := unstructured.NestedMap(obj.Object, "spec", "selector")
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("%s/%s has no selector", strings.ToLower(obj.GetKind()), obj.GetName())
+	}
+	labelSelector := &metav1.LabelSelector{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, labelSelector); err != nil {
+		return "", err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return "", err
+	}
+	return selector.String(), nil
+}
+
+func isHealthy(pod corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded {
+		return true
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func isCrashing(status corev1.ContainerStatus) bool {
+	if status.RestartCount > 0 {
+		return true
+	}
+	return status.State.Terminated != nil && status.State.Terminated.ExitCode != 0
+}
+
+func containerDiagnostics(status corev1.ContainerStatus) ContainerDiagnostics {
+	container := ContainerDiagnostics{Name: status.Name, Ready: status.Ready, RestartCount: status.RestartCount}
+	switch {
+	case status.State.Waiting != nil:
+		container.State = "Waiting"
+		container.Reason = status.State.Waiting.Reason
+		container.Message = status.State.Waiting.Message
+	case status.State.Terminated != nil:
+		container.State = "Terminated"
+		container.Reason = status.State.Terminated.Reason
+		container.Message = status.State.Terminated.Message
+		exitCode := status.State.Terminated.ExitCode
+		container.ExitCode = &exitCode
+	default:
+		container.State = "Running"
+	}
+	if last := status.LastTerminationState.Terminated; last != nil {
+		container.LastTerminationReason = last.Reason
+		if container.ExitCode == nil {
+			exitCode := last.ExitCode
+			container.ExitCode = &exitCode
+		}
+	}
+	return container
+}
+
+func warnings(events []Event) []Event {
+	var result []Event
+	for _, event := range events {
+		if event.Type == corev1.EventTypeWarning {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// String formats the diagnostics to be readable in a terminal
+func (d *Diagnostics) String() string {
+	var builder strings.Builder
+	_, _ = fmt.Fprintf(&builder, "Diagnostics for %s:\n", d.Workload)
+	if len(d.Pods) == 0 {
+		builder.WriteString("  No failing pods found\n")
+	}
+	for _, pod := range d.Pods {
+		_, _ = fmt.Fprintf(&builder, "  Pod %s (%s):\n", pod.Name, pod.Phase)
+		for _, container := range pod.Containers {
+			_, _ = fmt.Fprintf(&builder, "    Container %s: %s", container.Name, container.State)
+			if container.Reason != "" {
+				_, _ = fmt.Fprintf(&builder, " (%s)", container.Reason)
+			}
+			if container.RestartCount > 0 {
+				_, _ = fmt.Fprintf(&builder, ", restarted %d times", container.RestartCount)
+			}
+			if container.LastTerminationReason != "" {
+				_, _ = fmt.Fprintf(&builder, ", last terminated: %s", container.LastTerminationReason)
+			}
+			if container.ExitCode != nil {
+				_, _ = fmt.Fprintf(&builder, ", exit code %d", *container.ExitCode)
+			}
+			builder.WriteString("\n")
+			if container.Message != "" {
+				_, _ = fmt.Fprintf(&builder, "      %s\n", container.Message)
+			}
+			if container.Logs != "" {
+				builder.WriteString("      Logs:\n")
+				builder.WriteString(indent(container.Logs, "        "))
+			}
+		}
+		builder.WriteString(indent(formatEvents(pod.Events), "    "))
+	}
+	builder.WriteString(indent(formatEvents(d.Events), "  "))
+	return builder.String()
+}
+
+func indent(text, prefix string) string {
+	if text == "" {
+		return ""
+	}
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix) + "\n"
+}
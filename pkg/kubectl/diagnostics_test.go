@@ -0,0 +1,180 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package kubectl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestDiagnose(t *testing.T) {
+	current := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+	ready := []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	notReady := []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}}
+	n, _, _ := newTestNative(t, []runtime.Object{toUnstructured(t, `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: default
+spec:
+  selector:
+    matchLabels:
+      component: app
+`)},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-ready", Namespace: "default", Labels: map[string]string{"component": "app"}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, Conditions: ready},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-other", Namespace: "default", Labels: map[string]string{"app": "app"}},
+			Status:     corev1.PodStatus{Phase: corev1.PodPending, Conditions: notReady},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-crashing", Namespace: "default", Labels: map[string]string{"component": "app"}},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, Conditions: notReady, ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name:                 "app",
+					RestartCount:         3,
+					State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off 40s restarting failed container"}},
+					LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+				},
+				{Name: "sidecar", Ready: true, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-pulling", Namespace: "default", Labels: map[string]string{"component": "app"}},
+			Status: corev1.PodStatus{Phase: corev1.PodPending, Conditions: notReady, ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image \"app:abc\""}}},
+			}},
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "e1", Namespace: "default"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Deployment", Name: "app"},
+			Type:           "Normal",
+			Reason:         "ScalingReplicaSet",
+			Message:        "Scaled up replica set app-5cb459ff7d to 3",
+			LastTimestamp:  metav1.NewTime(current.Add(-time.Minute)),
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "e2", Namespace: "default"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "app-crashing"},
+			Type:           "Warning",
+			Reason:         "BackOff",
+			Source:         corev1.EventSource{Component: "kubelet"},
+			Message:        "Back-off restarting failed container",
+			LastTimestamp:  metav1.NewTime(current.Add(-30 * time.Second)),
+		},
+	)
+
+	diagnostics, err := n.Diagnose(Object{Kind: "Deployment", Name: "app"})
+	assert.NoError(t, err)
+	exitCode := int32(137)
+	assert.Equal(t, &Diagnostics{
+		Workload: Object{Kind: "Deployment", Name: "app"},
+		Pods: []PodDiagnostics{
+			{
+				Name:  "app-crashing",
+				Phase: "Running",
+				Containers: []ContainerDiagnostics{{
+					Name:                  "app",
+					RestartCount:          3,
+					State:                 "Waiting",
+					Reason:                "CrashLoopBackOff",
+					Message:               "back-off 40s restarting failed container",
+					LastTerminationReason: "OOMKilled",
+					ExitCode:              &exitCode,
+					Logs:                  "fake logs",
+				}},
+				Events: []Event{{Type: "Warning", Reason: "BackOff", From: "kubelet", Message: "Back-off restarting failed container", LastSeen: current.Add(-30 * time.Second)}},
+			},
+			{
+				Name:  "app-pulling",
+				Phase: "Pending",
+				Containers: []ContainerDiagnostics{{
+					Name:    "app",
+					State:   "Waiting",
+					Reason:  "ImagePullBackOff",
+					Message: "Back-off pulling image \"app:abc\"",
+				}},
+			},
+		},
+	}, diagnostics)
+	assert.Equal(t, `Diagnostics for deployment/app:
+  Pod app-crashing (Running):
+    Container app: Waiting (CrashLoopBackOff), restarted 3 times, last terminated: OOMKilled, exit code 137
+      back-off 40s restarting failed container
+      Logs:
+        fake logs
+    Events:
+      Type     Reason   Age   From     Message
+      ----     ------   ----  ----     -------
+      Warning  BackOff  30s   kubelet  Back-off restarting failed container
+  Pod app-pulling (Pending):
+    Container app: Waiting (ImagePullBackOff)
+      Back-off pulling image "app:abc"
+`, diagnostics.String())
+}
+
+func TestDiagnose_MissingWorkload(t *testing.T) {
+	n, _, _ := newTestNative(t, nil)
+
+	_, err := n.Diagnose(Object{Kind: "Deployment", Name: "app"})
+	assert.EqualError(t, err, `deployments.apps "app" not found`)
+}
+
+func TestDiagnose_NoSelector(t *testing.T) {
+	n, _, _ := newTestNative(t, []runtime.Object{toUnstructured(t, `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: default
+`)})
+
+	_, err := n.Diagnose(Object{Kind: "Deployment", Name: "app"})
+	assert.EqualError(t, err, "deployment/app has no selector")
+}
+
+func TestDiagnostics_String_NoPods(t *testing.T) {
+	current := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+	diagnostics := &Diagnostics{
+		Workload: Object{Kind: "Job", Name: "migrate", Namespace: "db"},
+		Events:   []Event{{Type: "Warning", Reason: "BackoffLimitExceeded", From: "job-controller", Message: "Job has reached the specified backoff limit", LastSeen: current.Add(-2 * time.Minute)}},
+	}
+
+	assert.Equal(t, `Diagnostics for job/migrate:
+  No failing pods found
+  Events:
+    Type     Reason                Age   From            Message
+    ----     ------                ----  ----            -------
+    Warning  BackoffLimitExceeded  2m    job-controller  Job has reached the specified backoff limit
+`, diagnostics.String())
+}
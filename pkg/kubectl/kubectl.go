@@ -26,16 +26,24 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
@@ -50,13 +58,24 @@ import (
 
 var kubectlVerbosityLevel = 6
 
+// pollInterval is how often the status of an object is checked while waiting for it to become ready
+var pollInterval = 2 * time.Second
+
 type Kubectl interface {
-	Apply(input string) error
+	// Apply applies input using server side apply and returns the result for each object
+	Apply(input string) ([]ApplyResult, error)
+	DryRun(input, mode string) (string, error)
 	Cleanup()
 	DeploymentExists(name string) bool
-	RolloutStatus(name, timeout string) bool
-	DeploymentEvents(name string) string
-	PodEvents(name string) string
+	RolloutStatus(workload Object, timeout string) bool
+	WaitForCondition(objects []Object, condition, timeout string) error
+	Diagnose(workload Object) (*Diagnostics, error)
+	Get(object Object) (string, error)
+	RolloutUndo(workload Object) error
+	Delete(object Object) error
+	List(kinds []string, selector, namespace string) ([]Object, error)
+	// Kubeconfig returns the path of the kubeconfig file in use, or an empty string if the default is used
+	Kubeconfig() string
 }
 
 type kubectl struct {
@@ -122,13 +141,25 @@ func writeKubeconfigFile(tempDir string, content []byte) (string, error) {
 }
 
 func (k kubectl) defaultArgs() (args []string) {
+	return k.argsWithNamespace("")
+}
+
+func (k kubectl) argsWithNamespace(namespace string) (args []string) {
+	values := make(map[string]string)
 	var keys []string
-	for key := range k.args {
+	for key, value := range k.args {
 		keys = append(keys, key)
+		values[key] = value
+	}
+	if namespace != "" {
+		if _, exists := values["namespace"]; !exists {
+			keys = append(keys, "namespace")
+		}
+		values["namespace"] = namespace
 	}
 	sort.Strings(keys)
 	for _, key := range keys {
-		args = append(args, fmt.Sprintf("--%s", key), k.args[key])
+		args = append(args, fmt.Sprintf("--%s", key), values[key])
 	}
 
 	if cli.Verbose(log.Log) {
@@ -137,16 +168,126 @@ func (k kubectl) defaultArgs() (args []string) {
 	return args
 }
 
-func (k kubectl) Apply(input string) error {
-	file := filepath.Join(k.tempDir, "content.yaml")
-	err := os.WriteFile(file, []byte(input), 0o777)
+// ApplyResult is the result of applying an object, i.e. serverside-applied
+type ApplyResult struct {
+	Object
+	Result string `json:"result"`
+}
+
+func (k kubectl) Apply(input string) ([]ApplyResult, error) {
+	args := append(k.defaultArgs(), "apply", "--server-side", "--force-conflicts", "--output=name", "-f", "-")
+	buffer := bytes.Buffer{}
+	err := runWithInput(input, &buffer, k.out, args)
+	for _, name := range strings.Fields(buffer.String()) {
+		_, _ = fmt.Fprintf(k.out, "%s serverside-applied\n", name)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return applyResults(input, buffer.String())
+}
 
-	args := append(k.defaultArgs(), "apply", "--server-side", "--force-conflicts", "-f", file)
-	c := newKubectlCmd(os.Stdin, k.out, k.out, args)
-	return c.Execute()
+// applyResults matches the objects in input with the names printed by kubectl apply --output=name,
+// which prints one line like "deployment.apps/app" for each applied object
+func applyResults(input, output string) ([]ApplyResult, error) {
+	objects, err := Objects(input)
+	if err != nil {
+		return nil, err
+	}
+	var results []ApplyResult
+	applied := strings.Fields(output)
+	for _, object := range objects {
+		name := fmt.Sprintf("%s/%s", strings.ToLower(schema.FromAPIVersionAndKind(object.APIVersion, object.Kind).GroupKind().String()), object.Name)
+		i := slices.Index(applied, name)
+		if i < 0 {
+			return nil, fmt.Errorf("kubectl apply didn't report a result for %s, got '%s'", name, strings.TrimSpace(output))
+		}
+		applied = slices.Delete(applied, i, i+1)
+		results = append(results, ApplyResult{Object: object, Result: "serverside-applied"})
+	}
+	if len(applied) > 0 {
+		return nil, fmt.Errorf("kubectl apply reported results for unknown objects %s", strings.Join(applied, ", "))
+	}
+	return results, nil
+}
+
+// DryRun applies input with the given dry run mode (client or server) and returns the resulting objects as yaml
+func (k kubectl) DryRun(input, mode string) (string, error) {
+	args := append(k.defaultArgs(), "apply")
+	// client side dry run isn't supported together with server side apply
+	if mode != "client" {
+		args = append(args, "--server-side", "--force-conflicts")
+	}
+	args = append(args, fmt.Sprintf("--dry-run=%s", mode), "--output=yaml", "-f", "-")
+	buffer := bytes.Buffer{}
+	if err := runWithInput(input, &buffer, k.out, args); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// invocation serializes running kubectl commands, since kubectl uses process wide state which isn't safe to share
+// between concurrent commands: the flags in flag.CommandLine, the handler set with cmdutil.BehaviorOnFatal and
+// os.Stdin from which the descriptors passed as "-" are read. Only short commands are run, waiting is done by
+// polling in between, so that concurrent deploys to different clusters aren't held up by each other
+var invocation sync.Mutex
+
+// fatalError is returned by run when kubectl reports a fatal error, which would otherwise exit the process
+type fatalError struct {
+	msg string
+}
+
+// Error returns the message without the "error: " prefix added by kubectl, since it's added again when logged
+func (e *fatalError) Error() string {
+	return strings.TrimPrefix(strings.TrimSpace(e.msg), "error: ")
+}
+
+// run executes kubectl with args while holding invocation
+func run(out, errout io.Writer, args []string) error {
+	invocation.Lock()
+	defer invocation.Unlock()
+	return execute(newKubectlCmd(os.Stdin, out, errout, args))
+}
+
+// runWithInput executes kubectl with args and input available on stdin while holding invocation
+func runWithInput(input string, out, errout io.Writer, args []string) error {
+	invocation.Lock()
+	defer invocation.Unlock()
+	return withStdin(input, func(in io.Reader) error {
+		return execute(newKubectlCmd(in, out, errout, args))
+	})
+}
+
+// execute runs c, returning fatal errors reported by kubectl as a fatalError. The caller must hold invocation
+func execute(c *cobra.Command) error {
+	var fatal error
+	cmdutil.BehaviorOnFatal(func(msg string, code int) {
+		fatal = &fatalError{msg: msg}
+	})
+	defer cmdutil.DefaultBehaviorOnFatal()
+	err := c.Execute()
+	if fatal != nil {
+		return fatal
+	}
+	return err
+}
+
+// withStdin runs fn with input available on os.Stdin through a pipe, so that descriptors (which may contain
+// decrypted secrets) are never written to disk. The caller must hold invocation
+func withStdin(input string, fn func(in io.Reader) error) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	go func() {
+		_, _ = io.WriteString(w, input)
+		_ = w.Close()
+	}()
+	previous := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = previous }()
+	return fn(r)
 }
 
 func (k kubectl) Cleanup() {
@@ -158,65 +299,148 @@ func (k kubectl) DeploymentExists(name string) bool {
 	args = append(args, "get", "deployment", name, "--ignore-not-found")
 	log.Debugf("kubectl %s\n", strings.Join(args, " "))
 	buffer := bytes.Buffer{}
-	c := newKubectlCmd(os.Stdin, &buffer, &buffer, args)
-	_ = c.Execute()
+	_ = run(&buffer, &buffer, args)
 	return buffer.Len() > 0
 }
 
-func (k kubectl) RolloutStatus(name, timeout string) bool {
-	args := k.defaultArgs()
-	args = append(args, "rollout", "status", "deployment", fmt.Sprintf("--timeout=%s", timeout), name)
-	log.Debugf("kubectl %s\n", strings.Join(args, " "))
-	c := newKubectlCmd(os.Stdin, k.out, k.out, args)
-	success := true
-	cmdutil.BehaviorOnFatal(func(str string, code int) {
-		fmt.Println(str)
-		success = false
-	})
-	if err := c.Execute(); err != nil {
-		success = false
+func (k kubectl) RolloutStatus(workload Object, timeout string) bool {
+	return rolloutStatus(workload, timeout, k.rollout)
+}
+
+// WaitForCondition polls the objects until condition is True for all of them, i.e. Established for CustomResourceDefinitions
+func (k kubectl) WaitForCondition(objects []Object, condition, timeout string) error {
+	return waitForCondition(objects, condition, timeout, k.workload)
+}
+
+// rollout returns the current rollout state of workload. The state is polled with kubectl get instead of
+// running kubectl rollout status, which would hold invocation until the rollout has finished
+func (k kubectl) rollout(workload Object) (RolloutState, error) {
+	obj, err := k.workload(workload)
+	if err != nil {
+		return RolloutState{}, err
 	}
-	return success
+	return rolloutState(obj.GroupVersionKind().GroupKind(), obj)
 }
 
-func (k kubectl) DeploymentEvents(name string) string {
-	args := k.defaultArgs()
-	args = append(args, "describe", "deployment", name, "--show-events=true")
+// Diagnose collects the failing pods, container states, logs and warning events for workload
+func (k kubectl) Diagnose(workload Object) (*Diagnostics, error) {
+	return diagnose(k, workload)
+}
+
+func (k kubectl) workload(object Object) (*unstructured.Unstructured, error) {
+	out, err := k.output(object.Namespace, "get", object.Type(), object.Name, "--output=json")
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(out); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (k kubectl) pods(namespace, selector string) ([]corev1.Pod, error) {
+	out, err := k.output(namespace, "get", "pods", fmt.Sprintf("--selector=%s", selector), "--output=json")
+	if err != nil {
+		return nil, err
+	}
+	list := corev1.PodList{}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (k kubectl) logs(namespace, pod, container string, previous bool, lines int) (string, error) {
+	args := []string{"logs", pod, fmt.Sprintf("--container=%s", container), fmt.Sprintf("--tail=%d", lines)}
+	if previous {
+		args = append(args, "--previous")
+	}
+	out, err := k.output(namespace, args...)
+	return string(out), err
+}
+
+func (k kubectl) events(object Object) ([]Event, error) {
+	selector := fields.AndSelectors(
+		fields.OneTermEqualSelector("involvedObject.kind", object.Kind),
+		fields.OneTermEqualSelector("involvedObject.name", object.Name),
+	).String()
+	out, err := k.output(object.Namespace, "get", "events", fmt.Sprintf("--field-selector=%s", selector), "--output=json")
+	if err != nil {
+		return nil, err
+	}
+	list := corev1.EventList{}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, err
+	}
+	return toEvents(list.Items, object), nil
+}
+
+// output runs kubectl with args in namespace, or the default namespace if empty, and returns what was written to stdout
+func (k kubectl) output(namespace string, args ...string) ([]byte, error) {
+	args = append(k.argsWithNamespace(namespace), args...)
 	log.Debugf("kubectl %s\n", strings.Join(args, " "))
 	buffer := bytes.Buffer{}
-	c := newKubectlCmd(os.Stdin, &buffer, &buffer, args)
-	if err := c.Execute(); err != nil {
-		return err.Error()
+	errBuffer := bytes.Buffer{}
+	if err := run(&buffer, &errBuffer, args); err != nil {
+		return nil, err
 	}
-	return k.extractEvents(buffer.String())
+	return buffer.Bytes(), nil
 }
 
-func (k kubectl) PodEvents(name string) string {
-	args := k.defaultArgs()
-	args = append(args, "describe", "pods", "-l", fmt.Sprintf("app=%s", name), "--show-events=true")
+// Get returns the current state of object without server populated fields, or an empty string if it doesn't exist
+func (k kubectl) Get(object Object) (string, error) {
+	args := k.argsWithNamespace(object.Namespace)
+	args = append(args, "get", object.Type(), object.Name, "--ignore-not-found", "--output=yaml")
 	log.Debugf("kubectl %s\n", strings.Join(args, " "))
 	buffer := bytes.Buffer{}
-	c := newKubectlCmd(os.Stdin, &buffer, &buffer, args)
-	if err := c.Execute(); err != nil {
-		return err.Error()
+	if err := run(&buffer, &buffer, args); err != nil {
+		return "", err
+	}
+	if buffer.Len() == 0 {
+		return "", nil
 	}
-	return k.extractEvents(buffer.String())
+	return applicable(buffer.String())
 }
 
-func (k kubectl) extractEvents(output string) string {
-	scanner := bufio.NewScanner(strings.NewReader(output))
-	var events strings.Builder
-	found := false
+func (k kubectl) RolloutUndo(workload Object) error {
+	args := k.argsWithNamespace(workload.Namespace)
+	args = append(args, "rollout", "undo", workload.Type(), workload.Name)
+	log.Debugf("kubectl %s\n", strings.Join(args, " "))
+	return run(k.out, k.out, args)
+}
+
+func (k kubectl) Delete(object Object) error {
+	args := k.argsWithNamespace(object.Namespace)
+	args = append(args, "delete", object.Type(), object.Name, "--ignore-not-found")
+	log.Debugf("kubectl %s\n", strings.Join(args, " "))
+	return run(k.out, k.out, args)
+}
+
+// List returns all objects of the given kinds, plain or in the form returned by Object.Type, matching selector in namespace, or the default namespace if empty
+func (k kubectl) List(kinds []string, selector, namespace string) ([]Object, error) {
+	args := k.argsWithNamespace(namespace)
+	args = append(args, "get", strings.Join(kinds, ","), fmt.Sprintf("--selector=%s", selector), "--ignore-not-found",
+		`--output=jsonpath={range .items[*]}{.apiVersion}{"\t"}{.kind}{"\t"}{.metadata.name}{"\t"}{.metadata.namespace}{"\n"}{end}`)
+	log.Debugf("kubectl %s\n", strings.Join(args, " "))
+	buffer := bytes.Buffer{}
+	if err := run(&buffer, &buffer, args); err != nil {
+		return nil, err
+	}
+	var objects []Object
+	scanner := bufio.NewScanner(&buffer)
 	for scanner.Scan() {
-		text := scanner.Text()
-		if found || strings.Index(text, "Events:") == 0 && !strings.Contains(text, "<none>") {
-			found = true
-			events.WriteString(text)
-			events.WriteString("\n")
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 4 {
+			continue
 		}
+		objects = append(objects, Object{APIVersion: fields[0], Kind: fields[1], Name: fields[2], Namespace: fields[3]})
 	}
+	return objects, nil
+}
 
-	return events.String()
+func (k kubectl) Kubeconfig() string {
+	return k.args["kubeconfig"]
 }
 
 var _ Kubectl = &kubectl{}
@@ -65,10 +65,10 @@ func TestNew_NoNamespace(t *testing.T) {
 
 	k := &kubectl{args: map[string]string{"context": "missing"}, tempDir: tempDir, out: cli.NewWriter(logMock)}
 
-	err := k.Apply("")
+	_, err := k.Apply("")
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(calls))
-	assert.Equal(t, []string{"apply", "--context", "missing", "--file", fmt.Sprintf("%s/content.yaml", tempDir), "--v=6", "--server-side", "--force-conflicts"}, calls[0])
+	assert.Equal(t, []string{"apply", "--context", "missing", "--file", "-", "--v=6", "--server-side", "--force-conflicts", "--output", "name"}, calls[0])
 	logMock.Check(t, []string{})
 }
 
@@ -82,10 +82,10 @@ func TestNew_NoContext(t *testing.T) {
 
 	k := &kubectl{args: map[string]string{"namespace": "namespace"}, tempDir: tempDir, out: cli.NewWriter(logMock)}
 
-	err := k.Apply("")
+	_, err := k.Apply("")
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(calls))
-	assert.Equal(t, []string{"apply", "--namespace", "namespace", "--file", fmt.Sprintf("%s/content.yaml", tempDir), "--server-side", "--force-conflicts"}, calls[0])
+	assert.Equal(t, []string{"apply", "--namespace", "namespace", "--file", "-", "--server-side", "--force-conflicts", "--output", "name"}, calls[0])
 	logMock.Check(t, []string{})
 }
 
@@ -94,19 +94,66 @@ func TestKubectl_Apply(t *testing.T) {
 	log.SetHandler(logMock)
 	log.SetLevel(log.InfoLevel)
 	calls = [][]string{}
+	o := "secret/db\n"
+	cmdOut = &o
 	newKubectlCmd = mockCmd
 	tempDir, _ := os.MkdirTemp(os.TempDir(), "build-tools")
 
-	k := &kubectl{args: map[string]string{"context": "missing", "namespace": "default"}, tempDir: tempDir, out: cli.NewWriter(logMock)}
+	k := &kubectl{args: map[string]string{"context": "missing", "namespace": "default"}, tempDir: tempDir, out: cli.NewWriter(log.Log)}
 
-	err := k.Apply("")
+	results, err := k.Apply("apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\nstringData:\n  password: s3cr3t\n")
 	assert.NoError(t, err)
+	assert.Equal(t, []ApplyResult{{Object: Object{APIVersion: "v1", Kind: "Secret", Name: "db"}, Result: "serverside-applied"}}, results)
 	assert.Equal(t, 1, len(calls))
-	assert.Equal(t, []string{"apply", "--context", "missing", "--namespace", "default", "--file", fmt.Sprintf("%s/content.yaml", tempDir), "--server-side", "--force-conflicts"}, calls[0])
+	assert.Equal(t, []string{"apply", "--context", "missing", "--namespace", "default", "--file", "-", "--server-side", "--force-conflicts", "--output", "name"}, calls[0])
+	assert.Equal(t, "apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\nstringData:\n  password: s3cr3t\n", cmdIn)
+	written, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Empty(t, written)
+	assert.Equal(t, os.Stdin.Name(), "/dev/stdin")
+	logMock.Check(t, []string{"info: secret/db serverside-applied\n"})
+	cmdOut = nil
+}
+
+func TestKubectl_DryRun(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	calls = [][]string{}
+	cmdError = nil
+	o := "apiVersion: v1\nkind: List\nitems: []\n"
+	cmdOut = &o
+	newKubectlCmd = mockCmd
+	tempDir := t.TempDir()
+
+	k := &kubectl{args: map[string]string{"context": "missing", "namespace": "default"}, tempDir: tempDir, out: cli.NewWriter(logMock)}
+
+	result, err := k.DryRun("kind: ConfigMap\n", "server")
+	assert.NoError(t, err)
+	assert.Equal(t, o, result)
+	assert.Equal(t, "kind: ConfigMap\n", cmdIn)
+	assert.Equal(t, []string{"apply", "--context", "missing", "--namespace", "default", "--file", "-", "--server-side", "--force-conflicts", "--output", "yaml", "--dry-run", "server"}, calls[0])
+
+	_, err = k.DryRun("", "client")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"apply", "--context", "missing", "--namespace", "default", "--file", "-", "--output", "yaml", "--dry-run", "client"}, calls[1])
 	logMock.Check(t, []string{})
+	cmdOut = nil
 }
 
-func TestKubectl_UnableToCreateTempDir(t *testing.T) {
+func TestKubectl_DryRunError(t *testing.T) {
+	e := "invalid object"
+	cmdError = &e
+	newKubectlCmd = mockCmd
+
+	k := &kubectl{args: map[string]string{}, tempDir: t.TempDir(), out: cli.NewWriter(mocks.New())}
+
+	_, err := k.DryRun("", "server")
+	assert.EqualError(t, err, "invalid object")
+	cmdError = nil
+}
+
+func TestKubectl_ApplyWithoutTempDir(t *testing.T) {
 	logMock := mocks.New()
 	log.SetHandler(logMock)
 	log.SetLevel(log.InfoLevel)
@@ -114,11 +161,66 @@ func TestKubectl_UnableToCreateTempDir(t *testing.T) {
 
 	k := &kubectl{args: nil, tempDir: "/missing", out: cli.NewWriter(logMock)}
 
-	err := k.Apply("")
-	assert.EqualError(t, err, "open /missing/content.yaml: no such file or directory")
+	_, err := k.Apply("")
+	assert.NoError(t, err)
 	logMock.Check(t, []string{})
 }
 
+func TestKubectl_ApplyResults(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	calls = [][]string{}
+	o := "deployment.apps/app\nnamespace/apps\n"
+	cmdOut = &o
+	newKubectlCmd = mockCmd
+
+	k := &kubectl{args: map[string]string{}, tempDir: t.TempDir(), out: cli.NewWriter(log.Log)}
+
+	results, err := k.Apply("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: apps\n---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n  namespace: apps\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []ApplyResult{
+		{Object: Object{APIVersion: "v1", Kind: "Namespace", Name: "apps"}, Result: "serverside-applied"},
+		{Object: Object{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Namespace: "apps"}, Result: "serverside-applied"},
+	}, results)
+	logMock.Check(t, []string{
+		"info: deployment.apps/app serverside-applied\n",
+		"info: namespace/apps serverside-applied\n",
+	})
+	cmdOut = nil
+}
+
+func TestKubectl_ApplyResultsMismatch(t *testing.T) {
+	calls = [][]string{}
+	o := "deployment.apps/other\n"
+	cmdOut = &o
+	newKubectlCmd = mockCmd
+	log.SetHandler(mocks.New())
+
+	k := &kubectl{args: map[string]string{}, tempDir: t.TempDir(), out: cli.NewWriter(log.Log)}
+
+	_, err := k.Apply("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n")
+	assert.EqualError(t, err, "kubectl apply didn't report a result for deployment.apps/app, got 'deployment.apps/other'")
+
+	o = "deployment.apps/app\nwidget.example.org/app\n"
+	_, err = k.Apply("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n")
+	assert.EqualError(t, err, "kubectl apply reported results for unknown objects widget.example.org/app")
+	cmdOut = nil
+}
+
+func TestKubectl_ApplyError(t *testing.T) {
+	e := "apply failed"
+	cmdError = &e
+	newKubectlCmd = mockCmd
+
+	k := &kubectl{args: map[string]string{}, tempDir: t.TempDir(), out: cli.NewWriter(mocks.New())}
+
+	results, err := k.Apply("kind: ConfigMap\n")
+	assert.EqualError(t, err, "apply failed")
+	assert.Nil(t, results)
+	cmdError = nil
+}
+
 func TestKubectl_Target(t *testing.T) {
 	logMock := mocks.New()
 	log.SetHandler(logMock)
@@ -174,17 +276,19 @@ func TestKubectl_RolloutStatusSuccess(t *testing.T) {
 	log.SetHandler(logMock)
 	log.SetLevel(log.InfoLevel)
 	calls = [][]string{}
-	cmdOut = nil
+	o := `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"image","generation":1},"spec":{"replicas":1},"status":{"observedGeneration":1,"replicas":1,"updatedReplicas":1,"availableReplicas":1}}`
+	cmdOut = &o
+	defer func() { cmdOut = nil }()
 	cmdError = nil
 	newKubectlCmd = mockCmd
 
 	k := New(&config.Target{Context: "missing", Namespace: "other"})
 
-	result := k.RolloutStatus("image", "2m")
+	result := k.RolloutStatus(Object{Kind: "Deployment", Name: "image"}, "2m")
 	assert.True(t, result)
 	assert.Equal(t, 1, len(calls))
-	assert.Equal(t, []string{"rollout", "status", "deployment", "image", "--context", "missing", "--namespace", "other", "--timeout", "2m0s"}, calls[0])
-	logMock.Check(t, []string{})
+	assert.Equal(t, []string{"get", "deployment", "image", "--context", "missing", "--namespace", "other", "--output", "json"}, calls[0])
+	logMock.Check(t, []string{"info: deployment \"image\" successfully rolled out\n"})
 }
 
 func TestKubectl_RolloutStatusFailure(t *testing.T) {
@@ -192,17 +296,18 @@ func TestKubectl_RolloutStatusFailure(t *testing.T) {
 	log.SetHandler(logMock)
 	log.SetLevel(log.InfoLevel)
 	calls = [][]string{}
-	e := "rollout failed"
+	e := "deployment not found"
 	cmdError = &e
+	defer func() { cmdError = nil }()
 	newKubectlCmd = mockCmd
 
 	k := New(&config.Target{Context: "missing", Namespace: "default"})
 
-	result := k.RolloutStatus("image", "2m")
+	result := k.RolloutStatus(Object{Kind: "Deployment", Name: "image"}, "2m")
 	assert.False(t, result)
 	assert.Equal(t, 1, len(calls))
-	assert.Equal(t, []string{"rollout", "status", "deployment", "image", "--context", "missing", "--namespace", "default", "--timeout", "2m0s"}, calls[0])
-	logMock.Check(t, []string{})
+	assert.Equal(t, []string{"get", "deployment", "image", "--context", "missing", "--namespace", "default", "--output", "json"}, calls[0])
+	logMock.Check(t, []string{"error: deployment not found\n"})
 }
 
 func TestKubectl_RolloutStatusFatal(t *testing.T) {
@@ -213,17 +318,354 @@ func TestKubectl_RolloutStatusFatal(t *testing.T) {
 	e := "rollout failed"
 	cmdError = &e
 	fatal = true
-	defer func() { fatal = false }()
+	defer func() {
+		fatal = false
+		cmdError = nil
+	}()
 
 	newKubectlCmd = mockCmd
 
 	k := New(&config.Target{Context: "missing", Namespace: "default"})
 
-	result := k.RolloutStatus("image", "3m")
+	result := k.RolloutStatus(Object{Kind: "Deployment", Name: "image"}, "3m")
 	assert.False(t, result)
 	assert.Equal(t, 1, len(calls))
-	assert.Equal(t, []string{"rollout", "status", "deployment", "image", "--context", "missing", "--namespace", "default", "--timeout", "3m0s"}, calls[0])
+	logMock.Check(t, []string{"error: rollout failed\n"})
+}
+
+func TestKubectl_RolloutStatusProgressDeadlineExceeded(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	calls = [][]string{}
+	o := `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"image","generation":1},"spec":{"replicas":1},"status":{"observedGeneration":1,"replicas":1,"conditions":[{"type":"Progressing","status":"False","reason":"ProgressDeadlineExceeded"}]}}`
+	cmdOut = &o
+	defer func() { cmdOut = nil }()
+	cmdError = nil
+	newKubectlCmd = mockCmd
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	result := k.RolloutStatus(Object{Kind: "Deployment", Name: "image"}, "3m")
+	assert.False(t, result)
+	assert.Equal(t, 1, len(calls))
+	logMock.Check(t, []string{"error: deployment \"image\" exceeded its progress deadline\n"})
+}
+
+func TestKubectl_RolloutStatusTimeout(t *testing.T) {
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = 2 * time.Second }()
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	calls = [][]string{}
+	o := `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"image","generation":2},"spec":{"replicas":1},"status":{"observedGeneration":1}}`
+	cmdOut = &o
+	defer func() { cmdOut = nil }()
+	cmdError = nil
+	newKubectlCmd = mockCmd
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	result := k.RolloutStatus(Object{Kind: "Deployment", Name: "image"}, "5ms")
+	assert.False(t, result)
+	assert.Greater(t, len(calls), 1)
+	logMock.Check(t, []string{
+		"info: Waiting for deployment spec update to be observed...\n",
+		"error: timed out waiting for deployment/image to become ready\n",
+	})
+}
+
+func TestKubectl_RolloutStatusStatefulSetNamespace(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	calls = [][]string{}
+	o := `{"apiVersion":"apps/v1","kind":"StatefulSet","metadata":{"name":"db","generation":1},"spec":{"replicas":1,"updateStrategy":{"type":"RollingUpdate"}},"status":{"observedGeneration":1,"replicas":1,"readyReplicas":1,"currentReplicas":1,"updatedReplicas":1,"availableReplicas":1,"currentRevision":"db-1","updateRevision":"db-1"}}`
+	cmdOut = &o
+	defer func() { cmdOut = nil }()
+	cmdError = nil
+	newKubectlCmd = mockCmd
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	result := k.RolloutStatus(Object{Kind: "StatefulSet", Name: "db", Namespace: "other"}, "2m")
+	assert.True(t, result)
+	assert.Equal(t, 1, len(calls))
+	assert.Equal(t, []string{"get", "statefulset", "db", "--context", "missing", "--namespace", "other", "--v=6", "--output", "json"}, calls[0])
+	logMock.Check(t, []string{
+		"debug: kubectl --context missing --namespace other --v=6 get statefulset db --output=json\n",
+		"info: statefulset rolling update complete 1 pods at revision db-1...\n",
+	})
+}
+
+func TestKubectl_RolloutStatusJob(t *testing.T) {
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = 2 * time.Second }()
+	job := func(status string) string {
+		return fmt.Sprintf(`{"apiVersion":"batch/v1","kind":"Job","metadata":{"name":"migrate"},"status":%s}`, status)
+	}
+	tests := []struct {
+		name       string
+		out        string
+		err        *string
+		timeout    string
+		want       bool
+		wantCalls  int
+		wantLogged []string
+	}{
+		{
+			name:       "complete",
+			out:        job(`{"conditions":[{"type":"SuccessCriteriaMet","status":"True"},{"type":"Complete","status":"True"}]}`),
+			timeout:    "2m",
+			want:       true,
+			wantCalls:  1,
+			wantLogged: []string{"info: job \"migrate\" completed\n"},
+		},
+		{
+			name:       "failed",
+			out:        job(`{"conditions":[{"type":"FailureTarget","status":"True"},{"type":"Failed","status":"True","message":"BackoffLimitExceeded"}]}`),
+			timeout:    "2m",
+			want:       false,
+			wantCalls:  1,
+			wantLogged: []string{"error: job \"migrate\" failed: BackoffLimitExceeded\n"},
+		},
+		{
+			name:    "timeout",
+			out:     job(`{}`),
+			timeout: "5ms",
+			want:    false,
+			wantLogged: []string{
+				"info: Waiting for job \"migrate\" to complete...\n",
+				"error: timed out waiting for job/migrate to become ready\n",
+			},
+		},
+		{
+			name:       "error",
+			err:        func() *string { e := "job not found"; return &e }(),
+			timeout:    "2m",
+			want:       false,
+			wantCalls:  1,
+			wantLogged: []string{"error: job not found\n"},
+		},
+		{
+			name:       "invalid timeout",
+			timeout:    "abc",
+			want:       false,
+			wantLogged: []string{"error: invalid timeout 'abc': time: invalid duration \"abc\"\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logMock := mocks.New()
+			log.SetHandler(logMock)
+			log.SetLevel(log.InfoLevel)
+			calls = [][]string{}
+			cmdOut = &tt.out
+			cmdError = tt.err
+			newKubectlCmd = mockCmd
+
+			k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+			result := k.RolloutStatus(Object{Kind: "Job", Name: "migrate"}, tt.timeout)
+			assert.Equal(t, tt.want, result)
+			if tt.wantCalls > 0 {
+				assert.Equal(t, tt.wantCalls, len(calls))
+			}
+			if len(calls) > 0 {
+				assert.Equal(t, []string{"get", "job", "migrate", "--context", "missing", "--namespace", "default", "--output", "json"}, calls[0])
+			}
+			logMock.Check(t, tt.wantLogged)
+		})
+	}
+	cmdOut = nil
+	cmdError = nil
+}
+
+func TestKubectl_Get(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	calls = [][]string{}
+	cmdError = nil
+	newKubectlCmd = mockCmd
+	o := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  creationTimestamp: "2024-01-01T00:00:00Z"
+  name: config
+  namespace: other
+  resourceVersion: "123"
+  uid: 1e6a5f55-2f67-4b0a-9f2d-3c1a2f0d1a2b
+data:
+  key: value
+`
+	cmdOut = &o
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	result, err := k.Get(Object{APIVersion: "v1", Kind: "ConfigMap", Name: "config", Namespace: "other"})
+	assert.NoError(t, err)
+	assert.Equal(t, "apiVersion: v1\ndata:\n  key: value\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: other\n", result)
+	assert.Equal(t, 1, len(calls))
+	assert.Equal(t, []string{"get", "ConfigMap.v1.", "config", "--context", "missing", "--namespace", "other", "--ignore-not-found", "--output", "yaml"}, calls[0])
 	logMock.Check(t, []string{})
+	cmdOut = nil
+}
+
+func TestKubectl_GetNotFound(t *testing.T) {
+	calls = [][]string{}
+	cmdError = nil
+	cmdOut = nil
+	newKubectlCmd = mockCmd
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	result, err := k.Get(Object{Kind: "ConfigMap", Name: "config"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", result)
+}
+
+func TestKubectl_GetError(t *testing.T) {
+	calls = [][]string{}
+	e := "forbidden"
+	cmdError = &e
+	newKubectlCmd = mockCmd
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	_, err := k.Get(Object{Kind: "ConfigMap", Name: "config"})
+	assert.EqualError(t, err, "forbidden")
+	cmdError = nil
+}
+
+func TestKubectl_RolloutUndo(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	calls = [][]string{}
+	cmdError = nil
+	newKubectlCmd = mockCmd
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	err := k.RolloutUndo(Object{APIVersion: "apps/v1", Kind: "Deployment", Name: "image"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rollout", "undo", "Deployment.v1.apps", "image", "--context", "missing", "--namespace", "default", "--v=6"}, calls[0])
+	logMock.Check(t, []string{"debug: kubectl --context missing --namespace default --v=6 rollout undo Deployment.v1.apps image\n"})
+}
+
+func TestKubectl_WaitForCondition(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	calls = [][]string{}
+	cmdError = nil
+	o := `{"apiVersion":"apiextensions.k8s.io/v1","kind":"CustomResourceDefinition","metadata":{"name":"widgets.example.org"},"status":{"conditions":[{"type":"Established","status":"True"}]}}`
+	cmdOut = &o
+	defer func() { cmdOut = nil }()
+	newKubectlCmd = mockCmd
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	err := k.WaitForCondition([]Object{
+		{Kind: "CustomResourceDefinition", Name: "widgets.example.org"},
+		{Kind: "Job", Name: "migrate", Namespace: "db"},
+	}, "Established", "0")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"get", "customresourcedefinition", "widgets.example.org", "--context", "missing", "--namespace", "default", "--output", "json"},
+		{"get", "job", "migrate", "--context", "missing", "--namespace", "db", "--output", "json"},
+	}, calls)
+
+	assert.EqualError(t, k.WaitForCondition(nil, "Established", "abc"), `invalid timeout 'abc': time: invalid duration "abc"`)
+	logMock.Check(t, []string{})
+}
+
+func TestKubectl_WaitForConditionTimeout(t *testing.T) {
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = 2 * time.Second }()
+	calls = [][]string{}
+	cmdError = nil
+	o := `{"apiVersion":"apiextensions.k8s.io/v1","kind":"CustomResourceDefinition","metadata":{"name":"widgets.example.org"},"status":{"conditions":[{"type":"Established","status":"False"}]}}`
+	cmdOut = &o
+	defer func() { cmdOut = nil }()
+	newKubectlCmd = mockCmd
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	err := k.WaitForCondition([]Object{{Kind: "CustomResourceDefinition", Name: "widgets.example.org"}}, "Established", "5ms")
+	assert.EqualError(t, err, "timed out waiting for condition Established on customresourcedefinition/widgets.example.org")
+	assert.Greater(t, len(calls), 1)
+}
+
+func TestKubectl_WaitForConditionError(t *testing.T) {
+	calls = [][]string{}
+	newKubectlCmd = mockCmd
+	e := "forbidden"
+	cmdError = &e
+	defer func() { cmdError = nil }()
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	err := k.WaitForCondition([]Object{{Kind: "CustomResourceDefinition", Name: "widgets.example.org"}}, "Established", "2m")
+	assert.EqualError(t, err, "forbidden")
+	assert.Equal(t, []string{"get", "customresourcedefinition", "widgets.example.org", "--context", "missing", "--namespace", "default", "--output", "json"}, calls[0])
+}
+
+func TestKubectl_Delete(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	calls = [][]string{}
+	e := "forbidden"
+	cmdError = &e
+	newKubectlCmd = mockCmd
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	err := k.Delete(Object{Kind: "Service", Name: "image", Namespace: "other"})
+	assert.EqualError(t, err, "forbidden")
+	assert.Equal(t, []string{"delete", "service", "image", "--context", "missing", "--namespace", "other", "--ignore-not-found"}, calls[0])
+	logMock.Check(t, []string{})
+	cmdError = nil
+}
+
+func TestKubectl_List(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	calls = [][]string{}
+	cmdError = nil
+	newKubectlCmd = mockCmd
+	o := "v1\tConfigMap\tconfig\tdefault\nnetworking.k8s.io/v1\tIngress\timage\tdefault\n\n"
+	cmdOut = &o
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	result, err := k.List([]string{"ConfigMap.v1.", "Ingress.v1.networking.k8s.io"}, "build-tools/deploy=image", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []Object{
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "config", Namespace: "default"},
+		{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Name: "image", Namespace: "default"},
+	}, result)
+	assert.Equal(t, []string{"get", "ConfigMap.v1.,Ingress.v1.networking.k8s.io", "--context", "missing", "--namespace", "default", "--ignore-not-found", "--selector", "build-tools/deploy=image", "--output", `jsonpath={range .items[*]}{.apiVersion}{"\t"}{.kind}{"\t"}{.metadata.name}{"\t"}{.metadata.namespace}{"\n"}{end}`}, calls[0])
+	logMock.Check(t, []string{})
+	cmdOut = nil
+}
+
+func TestKubectl_ListError(t *testing.T) {
+	calls = [][]string{}
+	e := "forbidden"
+	cmdError = &e
+	newKubectlCmd = mockCmd
+
+	k := New(&config.Target{Context: "missing", Namespace: "default"})
+
+	_, err := k.List([]string{"configmap"}, "build-tools/deploy=image", "other")
+	assert.EqualError(t, err, "forbidden")
+	assert.Equal(t, []string{"get", "configmap", "--context", "missing", "--namespace", "other", "--ignore-not-found", "--selector", "build-tools/deploy=image"}, calls[0][:9])
+	cmdError = nil
 }
 
 func TestKubectl_KubeconfigSet(t *testing.T) {
@@ -240,6 +682,7 @@ func TestKubectl_KubeconfigSet(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "contexts:\n- context:\n    cluster: k8s.prod\n    user: user@example.org\n", string(fileContent))
 	assert.Equal(t, kubeconfigFile, k.(*kubectl).args["kubeconfig"])
+	assert.Equal(t, kubeconfigFile, k.Kubeconfig())
 	k.Cleanup()
 }
 
@@ -280,7 +723,7 @@ func TestKubectl_KubeconfigExistingFile(t *testing.T) {
 	k.Cleanup()
 }
 
-func TestKubectl_DeploymentEvents_Error(t *testing.T) {
+func TestKubectl_Diagnose_Error(t *testing.T) {
 	logMock := mocks.New()
 	log.SetHandler(logMock)
 	log.SetLevel(log.InfoLevel)
@@ -288,134 +731,61 @@ func TestKubectl_DeploymentEvents_Error(t *testing.T) {
 	newKubectlCmd = mockCmd
 	e := "deployment not found"
 	cmdError = &e
+	defer func() { cmdError = nil }()
 
 	k := New(&config.Target{Context: "missing", Namespace: "default"})
 
-	result := k.DeploymentEvents("image")
-	assert.Equal(t, "deployment not found", result)
-	assert.Equal(t, 1, len(calls))
-	assert.Equal(t, []string{"describe", "deployment", "image", "--context", "missing", "--namespace", "default", "--show-events", "true"}, calls[0])
-	logMock.Check(t, []string{})
-}
-
-func TestKubectl_DeploymentEvents_NoEvents(t *testing.T) {
-	logMock := mocks.New()
-	log.SetHandler(logMock)
-	log.SetLevel(log.InfoLevel)
-	calls = [][]string{}
-	cmdError = nil
-	newKubectlCmd = mockCmd
-	e := `
-Name:               gpe-core
-Namespace:          default
-Events:          <none>
-`
-	cmdOut = &e
-
-	k := New(&config.Target{Context: "missing", Namespace: "default"})
-
-	result := k.DeploymentEvents("image")
-	assert.Equal(t, "", result)
-	assert.Equal(t, 1, len(calls))
-	assert.Equal(t, []string{"describe", "deployment", "image", "--context", "missing", "--namespace", "default", "--show-events", "true"}, calls[0])
-	logMock.Check(t, []string{})
-}
-
-func TestKubectl_DeploymentEvents_SomeEvents(t *testing.T) {
-	logMock := mocks.New()
-	log.SetHandler(logMock)
-	log.SetLevel(log.InfoLevel)
-	calls = [][]string{}
-	cmdError = nil
-	newKubectlCmd = mockCmd
-	e := `
-Name:               gpe-core
-Namespace:          default
-Events:
-  Type    Reason             Age   From                   Message
-  ----    ------             ----  ----                   -------
-  Normal  ScalingReplicaSet  9m    deployment-controller  Scaled up replica set gpe-core-5cb459ff7d to 1
-  Normal  ScalingReplicaSet  9m    deployment-controller  Scaled down replica set gpe-core-7fc44679dc to 0
-  Normal  ScalingReplicaSet  61s   deployment-controller  Scaled up replica set gpe-core-c8798ff88 to 1
-  Normal  ScalingReplicaSet  61s   deployment-controller  Scaled down replica set gpe-core-5cb459ff7d to 0
-`
-	cmdOut = &e
-
-	k := New(&config.Target{Context: "missing", Namespace: "default"})
-
-	result := k.DeploymentEvents("image")
-	assert.Equal(t, "Events:\n  Type    Reason             Age   From                   Message\n  ----    ------             ----  ----                   -------\n  Normal  ScalingReplicaSet  9m    deployment-controller  Scaled up replica set gpe-core-5cb459ff7d to 1\n  Normal  ScalingReplicaSet  9m    deployment-controller  Scaled down replica set gpe-core-7fc44679dc to 0\n  Normal  ScalingReplicaSet  61s   deployment-controller  Scaled up replica set gpe-core-c8798ff88 to 1\n  Normal  ScalingReplicaSet  61s   deployment-controller  Scaled down replica set gpe-core-5cb459ff7d to 0\n", result)
-	assert.Equal(t, 1, len(calls))
-	assert.Equal(t, []string{"describe", "deployment", "image", "--context", "missing", "--namespace", "default", "--show-events", "true"}, calls[0])
-	logMock.Check(t, []string{})
-}
-
-func TestKubectl_PodEvents_Error(t *testing.T) {
-	logMock := mocks.New()
-	log.SetHandler(logMock)
-	log.SetLevel(log.InfoLevel)
-	calls = [][]string{}
-	newKubectlCmd = mockCmd
-	e := "pod not found"
-	cmdError = &e
-
-	k := New(&config.Target{Context: "missing", Namespace: "default"})
-
-	result := k.PodEvents("image")
-	assert.Equal(t, "pod not found", result)
+	_, err := k.Diagnose(Object{Kind: "Deployment", Name: "image"})
+	assert.EqualError(t, err, "deployment not found")
 	assert.Equal(t, 1, len(calls))
-	assert.Equal(t, []string{"describe", "pods", "--context", "missing", "--namespace", "default", "--show-events", "true", "--selector", "app=image"}, calls[0])
+	assert.Equal(t, []string{"get", "deployment", "image", "--context", "missing", "--namespace", "default", "--output", "json"}, calls[0])
 	logMock.Check(t, []string{})
 }
 
-func TestKubectl_PodEvents_NoEvents(t *testing.T) {
+func TestKubectl_DiagnosticsSource(t *testing.T) {
 	logMock := mocks.New()
 	log.SetHandler(logMock)
 	log.SetLevel(log.InfoLevel)
 	calls = [][]string{}
 	cmdError = nil
 	newKubectlCmd = mockCmd
-	e := `
-Name:               gpe-core
-Namespace:          default
-Events:          <none>
-`
-	cmdOut = &e
+	defer func() { cmdOut = nil }()
 
-	k := New(&config.Target{Context: "missing", Namespace: "default"})
+	k := New(&config.Target{Context: "missing", Namespace: "default"}).(*kubectl)
 
-	result := k.PodEvents("image")
-	assert.Equal(t, "", result)
-	assert.Equal(t, 1, len(calls))
-	assert.Equal(t, []string{"describe", "pods", "--context", "missing", "--namespace", "default", "--show-events", "true", "--selector", "app=image"}, calls[0])
-	logMock.Check(t, []string{})
-}
+	o := `{"apiVersion":"apps/v1","kind":"StatefulSet","metadata":{"name":"db","namespace":"data"},"spec":{"selector":{"matchLabels":{"app":"db"}}}}`
+	cmdOut = &o
+	obj, err := k.workload(Object{Kind: "StatefulSet", Name: "db", Namespace: "data"})
+	assert.NoError(t, err)
+	assert.Equal(t, "db", obj.GetName())
 
-func TestKubectl_PodEvents_SomeEvents(t *testing.T) {
-	logMock := mocks.New()
-	log.SetHandler(logMock)
-	log.SetLevel(log.InfoLevel)
-	calls = [][]string{}
-	cmdError = nil
-	newKubectlCmd = mockCmd
-	e := `
-Events:
-  Type     Reason     Age                From                                                 Message
-  ----     ------     ----               ----                                                 -------
-  Normal   Scheduled  61s                default-scheduler                                    Successfully assigned dev/gpe-core-c8798ff88-674tr to some-ip-somewhere
-  Normal   Pulling    10s (x4 over 60s)  kubelet, some-ip-somewhere                           pulling image "quay.io/somewhere/gpe-core:9cdb0243e82b9bfdf037627d9d59cbfcbf55406c"
-  Normal   Pulled     9s (x4 over 57s)   kubelet, some-ip-somewhere                           Successfully pulled image "quay.io/somewhere/gpe-core:9cdb0243e82b9bfdf037627d9d59cbfcbf55406c"
-  Normal   Created    8s (x4 over 57s)   kubelet, some-ip-somewhere                           Created container
-  Normal   Started    8s (x4 over 57s)   kubelet, some-ip-somewhere                           Started container
-  Warning  BackOff    8s (x5 over 54s)   kubelet, some-ip-somewhere                           Back-off restarting failed container`
-	cmdOut = &e
+	o = `{"apiVersion":"v1","kind":"List","items":[{"metadata":{"name":"db-0","namespace":"data"},"status":{"phase":"Pending"}}]}`
+	pods, err := k.pods("data", "app=db")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pods))
+	assert.Equal(t, "db-0", pods[0].Name)
 
-	k := New(&config.Target{Context: "missing", Namespace: "default"})
+	o = "panic: boom\n"
+	logs, err := k.logs("data", "db-0", "db", true, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, "panic: boom\n", logs)
 
-	result := k.PodEvents("image")
-	assert.Equal(t, "Events:\n  Type     Reason     Age                From                                                 Message\n  ----     ------     ----               ----                                                 -------\n  Normal   Scheduled  61s                default-scheduler                                    Successfully assigned dev/gpe-core-c8798ff88-674tr to some-ip-somewhere\n  Normal   Pulling    10s (x4 over 60s)  kubelet, some-ip-somewhere                           pulling image \"quay.io/somewhere/gpe-core:9cdb0243e82b9bfdf037627d9d59cbfcbf55406c\"\n  Normal   Pulled     9s (x4 over 57s)   kubelet, some-ip-somewhere                           Successfully pulled image \"quay.io/somewhere/gpe-core:9cdb0243e82b9bfdf037627d9d59cbfcbf55406c\"\n  Normal   Created    8s (x4 over 57s)   kubelet, some-ip-somewhere                           Created container\n  Normal   Started    8s (x4 over 57s)   kubelet, some-ip-somewhere                           Started container\n  Warning  BackOff    8s (x5 over 54s)   kubelet, some-ip-somewhere                           Back-off restarting failed container\n", result)
-	assert.Equal(t, 1, len(calls))
-	assert.Equal(t, []string{"describe", "pods", "--context", "missing", "--namespace", "default", "--show-events", "true", "--selector", "app=image"}, calls[0])
+	o = `{"apiVersion":"v1","kind":"List","items":[
+{"involvedObject":{"kind":"Pod","name":"db-0"},"type":"Warning","reason":"BackOff","message":"Back-off restarting failed container","source":{"component":"kubelet"},"lastTimestamp":"2024-01-01T12:00:00Z"},
+{"involvedObject":{"kind":"Pod","name":"db-0"},"type":"Normal","reason":"Pulled","message":"Pulled image","source":{"component":"kubelet"},"lastTimestamp":"2024-01-01T11:00:00Z"}]}`
+	events, err := k.events(Object{Kind: "Pod", Name: "db-0", Namespace: "data"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{
+		{Type: "Normal", Reason: "Pulled", From: "kubelet", Message: "Pulled image", LastSeen: time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC).Local()},
+		{Type: "Warning", Reason: "BackOff", From: "kubelet", Message: "Back-off restarting failed container", LastSeen: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).Local()},
+	}, events)
+
+	assert.Equal(t, [][]string{
+		{"get", "statefulset", "db", "--context", "missing", "--namespace", "data", "--output", "json"},
+		{"get", "pods", "--context", "missing", "--namespace", "data", "--selector", "app=db", "--output", "json"},
+		{"logs", "db-0", "--context", "missing", "--namespace", "data", "--container", "db", "--tail", "20", "--previous"},
+		{"get", "events", "--context", "missing", "--namespace", "data", "--output", "json", "--field-selector", "involvedObject.kind=Pod,involvedObject.name=db-0"},
+	}, calls)
 	logMock.Check(t, []string{})
 }
 
@@ -423,6 +793,7 @@ var (
 	calls    [][]string
 	cmdError *string
 	cmdOut   *string
+	cmdIn    string
 	fatal    = false
 )
 
@@ -436,6 +807,13 @@ func mockCmd(_ io.Reader, out, _ io.Writer, args []string) *cobra.Command {
 	var verbose *string
 	var serverSide *bool
 	var forceConflicts *bool
+	var output *string
+	var dryRun *string
+	var container *string
+	var tail *int
+	var previous *bool
+	var fieldSelector *string
+	var waitFor *string
 
 	cmd := cobra.Command{
 		Use: "kubectl",
@@ -453,6 +831,10 @@ func mockCmd(_ io.Reader, out, _ io.Writer, args []string) *cobra.Command {
 			if *file != "" {
 				call = append(call, "--file", *file)
 			}
+			if *file == "-" {
+				in, _ := io.ReadAll(os.Stdin)
+				cmdIn = string(in)
+			}
 			if *timeout != time.Duration(0) {
 				call = append(call, "--timeout", timeout.String())
 			}
@@ -474,6 +856,27 @@ func mockCmd(_ io.Reader, out, _ io.Writer, args []string) *cobra.Command {
 			if *forceConflicts {
 				call = append(call, "--force-conflicts")
 			}
+			if *output != "" {
+				call = append(call, "--output", *output)
+			}
+			if *dryRun != "" {
+				call = append(call, "--dry-run", *dryRun)
+			}
+			if *container != "" {
+				call = append(call, "--container", *container)
+			}
+			if *tail != -1 {
+				call = append(call, "--tail", fmt.Sprintf("%d", *tail))
+			}
+			if *previous {
+				call = append(call, "--previous")
+			}
+			if *fieldSelector != "" {
+				call = append(call, "--field-selector", *fieldSelector)
+			}
+			if *waitFor != "" {
+				call = append(call, "--for", *waitFor)
+			}
 			calls = append(calls, call)
 			return nil
 		},
@@ -502,6 +905,13 @@ func mockCmd(_ io.Reader, out, _ io.Writer, args []string) *cobra.Command {
 	verbose = cmd.Flags().StringP("v", "v", "0", "")
 	serverSide = cmd.Flags().BoolP("server-side", "", false, "")
 	forceConflicts = cmd.Flags().BoolP("force-conflicts", "", false, "")
+	output = cmd.Flags().StringP("output", "o", "", "")
+	dryRun = cmd.Flags().StringP("dry-run", "", "", "")
+	container = cmd.Flags().StringP("container", "", "", "")
+	tail = cmd.Flags().IntP("tail", "", -1, "")
+	previous = cmd.Flags().BoolP("previous", "", false, "")
+	fieldSelector = cmd.Flags().StringP("field-selector", "", "", "")
+	waitFor = cmd.Flags().StringP("for", "", "", "")
 	cmd.SetArgs(args)
 	return &cmd
 }
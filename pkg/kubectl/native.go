@@ -0,0 +1,431 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package kubectl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apex/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/polymorphichelpers"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+// fieldManager is the field manager used for server side apply
+const fieldManager = "build-tools"
+
+// Native is a Kubectl implementation using client-go and server side apply directly,
+// instead of running kubectl commands
+type Native struct {
+	dynamic    dynamic.Interface
+	clientset  kubernetes.Interface
+	mapper     meta.RESTMapper
+	namespace  string
+	tempDir    string
+	kubeconfig string
+}
+
+// Event is a Kubernetes event for an object
+type Event struct {
+	Type     string    `json:"type"`
+	Reason   string    `json:"reason"`
+	From     string    `json:"from"`
+	Message  string    `json:"message"`
+	Count    int32     `json:"count,omitempty"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+var now = time.Now
+
+func NewNative(target *config.Target) (*Native, error) {
+	tempDir, err := os.MkdirTemp(os.TempDir(), "build-tools")
+	if err != nil {
+		return nil, err
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if file, exists, err := getKubeconfigFileFromEnvs(tempDir); err != nil {
+		log.Errorf("%s", err.Error())
+	} else if exists {
+		rules.ExplicitPath = file
+	}
+	if len(target.Kubeconfig) > 0 {
+		rules.ExplicitPath = target.Kubeconfig
+	}
+	if len(rules.ExplicitPath) > 0 {
+		log.Debugf("Using kubeconfig: <green>'%s'</green>", rules.ExplicitPath)
+	}
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: target.Context,
+		Context:        clientcmdapi.Context{Namespace: target.Namespace},
+	}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	n := newNative(dynamicClient, clientset, mapper, namespace, tempDir)
+	n.kubeconfig = rules.ExplicitPath
+	return n, nil
+}
+
+func newNative(dynamicClient dynamic.Interface, clientset kubernetes.Interface, mapper meta.RESTMapper, namespace, tempDir string) *Native {
+	return &Native{
+		dynamic:   dynamicClient,
+		clientset: clientset,
+		mapper:    mapper,
+		namespace: namespace,
+		tempDir:   tempDir,
+	}
+}
+
+func (n *Native) Apply(input string) ([]ApplyResult, error) {
+	objects, err := decode(input)
+	if err != nil {
+		return nil, err
+	}
+	var results []ApplyResult
+	for _, obj := range objects {
+		if _, err := n.apply(obj, false); err != nil {
+			return results, err
+		}
+		log.Infof("%s serverside-applied\n", objectOf(obj))
+		results = append(results, ApplyResult{Object: objectOf(obj), Result: "serverside-applied"})
+	}
+	return results, nil
+}
+
+func (n *Native) DryRun(input, mode string) (string, error) {
+	objects, err := decode(input)
+	if err != nil {
+		return "", err
+	}
+	var docs []string
+	for _, obj := range objects {
+		result := obj
+		if mode != "client" {
+			if result, err = n.apply(obj, true); err != nil {
+				return "", err
+			}
+		}
+		out, err := sigsyaml.Marshal(result.Object)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, string(out))
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+func (n *Native) apply(obj *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := n.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	force := true
+	options := metav1.PatchOptions{FieldManager: fieldManager, Force: &force}
+	if dryRun {
+		options.DryRun = []string{metav1.DryRunAll}
+	}
+	return n.resource(mapping, obj.GetNamespace()).Patch(context.Background(), obj.GetName(), types.ApplyPatchType, data, options)
+}
+
+func (n *Native) Kubeconfig() string {
+	return n.kubeconfig
+}
+
+func (n *Native) Cleanup() {
+	_ = os.RemoveAll(n.tempDir)
+}
+
+func (n *Native) DeploymentExists(name string) bool {
+	_, err := n.clientset.AppsV1().Deployments(n.namespace).Get(context.Background(), name, metav1.GetOptions{})
+	return err == nil
+}
+
+func (n *Native) RolloutStatus(workload Object, timeout string) bool {
+	return rolloutStatus(workload, timeout, n.Rollout)
+}
+
+// WaitForCondition polls the objects until condition is True for all of them, i.e. Established for CustomResourceDefinitions
+func (n *Native) WaitForCondition(objects []Object, condition, timeout string) error {
+	return waitForCondition(objects, condition, timeout, n.workload)
+}
+
+// Rollout returns the current rollout state of workload
+func (n *Native) Rollout(workload Object) (RolloutState, error) {
+	resource, mapping, err := n.resourceFor(workload)
+	if err != nil {
+		return RolloutState{}, err
+	}
+	obj, err := resource.Get(context.Background(), workload.Name, metav1.GetOptions{})
+	if err != nil {
+		return RolloutState{}, err
+	}
+	return rolloutState(mapping.GroupVersionKind.GroupKind(), obj)
+}
+
+// EventList returns the events for object, oldest first
+func (n *Native) EventList(object Object) ([]Event, error) {
+	namespace := object.Namespace
+	if namespace == "" {
+		namespace = n.namespace
+	}
+	selector := fields.AndSelectors(
+		fields.OneTermEqualSelector("involvedObject.kind", object.Kind),
+		fields.OneTermEqualSelector("involvedObject.name", object.Name),
+	).String()
+	list, err := n.clientset.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	return toEvents(list.Items, object), nil
+}
+
+// toEvents converts the events involving object, oldest first
+func toEvents(items []corev1.Event, object Object) []Event {
+	var events []Event
+	for _, e := range items {
+		if e.InvolvedObject.Kind == object.Kind && e.InvolvedObject.Name == object.Name {
+			events = append(events, toEvent(e))
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastSeen.Before(events[j].LastSeen)
+	})
+	return events
+}
+
+func toEvent(e corev1.Event) Event {
+	lastSeen := e.LastTimestamp.Time
+	if lastSeen.IsZero() {
+		lastSeen = e.EventTime.Time
+	}
+	from := e.Source.Component
+	if from == "" {
+		from = e.ReportingController
+	}
+	return Event{Type: e.Type, Reason: e.Reason, From: from, Message: strings.TrimSpace(e.Message), Count: e.Count, LastSeen: lastSeen}
+}
+
+// formatEvents formats events in the same way as kubectl describe
+func formatEvents(events []Event) string {
+	if len(events) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteString("Events:\n")
+	w := tabwriter.NewWriter(&builder, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "  Type\tReason\tAge\tFrom\tMessage\n")
+	_, _ = fmt.Fprintf(w, "  ----\t------\t----\t----\t-------\n")
+	for _, e := range events {
+		_, _ = fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", e.Type, e.Reason, duration.HumanDuration(now().Sub(e.LastSeen)), e.From, e.Message)
+	}
+	_ = w.Flush()
+	return builder.String()
+}
+
+// Diagnose collects the failing pods, container states, logs and warning events for workload
+func (n *Native) Diagnose(workload Object) (*Diagnostics, error) {
+	return diagnose(n, workload)
+}
+
+func (n *Native) workload(object Object) (*unstructured.Unstructured, error) {
+	resource, _, err := n.resourceFor(object)
+	if err != nil {
+		return nil, err
+	}
+	return resource.Get(context.Background(), object.Name, metav1.GetOptions{})
+}
+
+func (n *Native) pods(namespace, selector string) ([]corev1.Pod, error) {
+	if namespace == "" {
+		namespace = n.namespace
+	}
+	list, err := n.clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (n *Native) logs(namespace, pod, container string, previous bool, lines int) (string, error) {
+	tail := int64(lines)
+	out, err := n.clientset.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{Container: container, Previous: previous, TailLines: &tail}).DoRaw(context.Background())
+	return string(out), err
+}
+
+func (n *Native) events(object Object) ([]Event, error) {
+	return n.EventList(object)
+}
+
+func (n *Native) Get(object Object) (string, error) {
+	resource, _, err := n.resourceFor(object)
+	if err != nil {
+		return "", err
+	}
+	obj, err := resource.Get(context.Background(), object.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return clean(obj)
+}
+
+func (n *Native) RolloutUndo(workload Object) error {
+	resource, mapping, err := n.resourceFor(workload)
+	if err != nil {
+		return err
+	}
+	rollbacker, err := polymorphichelpers.RollbackerFor(mapping.GroupVersionKind.GroupKind(), n.clientset)
+	if err != nil {
+		return err
+	}
+	obj, err := resource.Get(context.Background(), workload.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	message, err := rollbacker.Rollback(obj, nil, 0, cmdutil.DryRunNone)
+	if err != nil {
+		return err
+	}
+	log.Infof("%s %s\n", workload, message)
+	return nil
+}
+
+func (n *Native) Delete(object Object) error {
+	resource, _, err := n.resourceFor(object)
+	if err != nil {
+		return err
+	}
+	propagation := metav1.DeletePropagationBackground
+	err = resource.Delete(context.Background(), object.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (n *Native) List(kinds []string, selector, namespace string) ([]Object, error) {
+	var objects []Object
+	for _, kind := range kinds {
+		resource, _, err := n.resourceFor(typeObject(kind, namespace))
+		if err != nil {
+			return nil, err
+		}
+		list, err := resource.List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			objects = append(objects, objectOf(&item))
+		}
+	}
+	return objects, nil
+}
+
+// typeObject returns an Object for a kind given to List, either a plain kind or in the form returned by Object.Type
+func typeObject(kind, namespace string) Object {
+	gvk, _ := schema.ParseKindArg(kind)
+	if gvk == nil {
+		return Object{Kind: kind, Namespace: namespace}
+	}
+	return Object{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind, Namespace: namespace}
+}
+
+// resourceFor finds the resource for object using its apiVersion and kind.
+// Objects without apiVersion are resolved using only the kind, in the same way as kubectl.
+func (n *Native) resourceFor(object Object) (dynamic.ResourceInterface, *meta.RESTMapping, error) {
+	gvk := schema.FromAPIVersionAndKind(object.APIVersion, object.Kind)
+	if object.APIVersion == "" {
+		kind, err := n.mapper.KindFor(schema.GroupVersionResource{Resource: strings.ToLower(object.Kind)})
+		if err != nil {
+			return nil, nil, err
+		}
+		gvk = kind
+	}
+	mapping, err := n.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+	return n.resource(mapping, object.Namespace), mapping, nil
+}
+
+func (n *Native) resource(mapping *meta.RESTMapping, namespace string) dynamic.ResourceInterface {
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return n.dynamic.Resource(mapping.Resource)
+	}
+	if namespace == "" {
+		namespace = n.namespace
+	}
+	return n.dynamic.Resource(mapping.Resource).Namespace(namespace)
+}
+
+var _ Kubectl = &Native{}
@@ -0,0 +1,535 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package kubectl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+func testMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("StatefulSet"), meta.RESTScopeNamespace)
+	mapper.Add(batchv1.SchemeGroupVersion.WithKind("Job"), meta.RESTScopeNamespace)
+	return mapper
+}
+
+func testScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	return scheme
+}
+
+func toUnstructured(t *testing.T, content string) *unstructured.Unstructured {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	assert.NoError(t, sigsyaml.Unmarshal([]byte(content), &obj.Object))
+	return obj
+}
+
+func newTestNative(t *testing.T, objects []runtime.Object, typed ...runtime.Object) (*Native, *dynamicfake.FakeDynamicClient, *kubefake.Clientset) {
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(testScheme(), objects...)
+	clientset := kubefake.NewClientset(typed...)
+	return newNative(dynamicClient, clientset, testMapper(), "default", t.TempDir()), dynamicClient, clientset
+}
+
+func TestNative_Apply(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	n, dynamicClient, _ := newTestNative(t, nil)
+	var patches []k8stesting.PatchActionImpl
+	dynamicClient.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchActionImpl)
+		patches = append(patches, patch)
+		return true, toUnstructured(t, string(patch.GetPatch())), nil
+	})
+
+	results, err := n.Apply(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  key: value
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: other
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: other
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, []ApplyResult{
+		{Object: Object{APIVersion: "v1", Kind: "ConfigMap", Name: "config"}, Result: "serverside-applied"},
+		{Object: Object{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Namespace: "other"}, Result: "serverside-applied"},
+		{Object: Object{APIVersion: "v1", Kind: "Namespace", Name: "other"}, Result: "serverside-applied"},
+	}, results)
+	assert.Equal(t, 3, len(patches))
+	assert.Equal(t, "configmaps", patches[0].GetResource().Resource)
+	assert.Equal(t, "default", patches[0].GetNamespace())
+	assert.Equal(t, "config", patches[0].GetName())
+	assert.Equal(t, types.ApplyPatchType, patches[0].GetPatchType())
+	assert.JSONEq(t, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"config"},"data":{"key":"value"}}`, string(patches[0].GetPatch()))
+	assert.Equal(t, "build-tools", patches[0].PatchOptions.FieldManager)
+	assert.True(t, *patches[0].PatchOptions.Force)
+	assert.Empty(t, patches[0].PatchOptions.DryRun)
+	assert.Equal(t, "deployments", patches[1].GetResource().Resource)
+	assert.Equal(t, "other", patches[1].GetNamespace())
+	assert.Equal(t, "namespaces", patches[2].GetResource().Resource)
+	assert.Equal(t, "", patches[2].GetNamespace())
+	logMock.Check(t, []string{
+		"info: configmap/config serverside-applied\n",
+		"info: deployment/app serverside-applied\n",
+		"info: namespace/other serverside-applied\n",
+	})
+}
+
+func TestNative_ApplyErrors(t *testing.T) {
+	n, _, _ := newTestNative(t, nil)
+
+	_, err := n.Apply("dummy yaml content")
+	assert.EqualError(t, err, "error unmarshaling JSON: while decoding JSON: json: cannot unmarshal string into Go value of type map[string]interface {}")
+	_, err = n.Apply("metadata:\n  name: missing\n")
+	assert.EqualError(t, err, "object 'missing' is missing kind")
+	_, err = n.Apply("apiVersion: example.com/v1\nkind: Unknown\nmetadata:\n  name: x\n")
+	assert.EqualError(t, err, `no matches for kind "Unknown" in version "example.com/v1"`)
+}
+
+func TestNative_DryRun(t *testing.T) {
+	n, dynamicClient, _ := newTestNative(t, nil)
+	var patches []k8stesting.PatchActionImpl
+	dynamicClient.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchActionImpl)
+		patches = append(patches, patch)
+		obj := toUnstructured(t, string(patch.GetPatch()))
+		obj.SetResourceVersion("12")
+		return true, obj, nil
+	})
+	content := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: other\n"
+
+	out, err := n.DryRun(content, "server")
+	assert.NoError(t, err)
+	assert.Equal(t, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n  resourceVersion: \"12\"\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: other\n  resourceVersion: \"12\"\n", out)
+	assert.Equal(t, 2, len(patches))
+	assert.Equal(t, []string{metav1.DryRunAll}, patches[0].PatchOptions.DryRun)
+
+	out, err = n.DryRun(content, "client")
+	assert.NoError(t, err)
+	assert.Equal(t, content, out)
+	assert.Equal(t, 2, len(patches))
+}
+
+func TestNative_DeploymentExists(t *testing.T) {
+	n, _, _ := newTestNative(t, nil, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}})
+
+	assert.True(t, n.DeploymentExists("app"))
+	assert.False(t, n.DeploymentExists("missing"))
+}
+
+func TestNative_RolloutStatus(t *testing.T) {
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = 2 * time.Second }()
+	tests := []struct {
+		name       string
+		object     string
+		workload   Object
+		timeout    string
+		want       bool
+		wantLogged []string
+	}{
+		{
+			name: "deployment done",
+			object: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: default
+  generation: 2
+spec:
+  replicas: 2
+status:
+  observedGeneration: 2
+  replicas: 2
+  updatedReplicas: 2
+  availableReplicas: 2
+`,
+			workload:   Object{Kind: "Deployment", Name: "app"},
+			timeout:    "2m",
+			want:       true,
+			wantLogged: []string{"info: deployment \"app\" successfully rolled out\n"},
+		},
+		{
+			name: "deployment progress deadline exceeded",
+			object: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: default
+spec:
+  replicas: 1
+status:
+  conditions:
+  - type: Progressing
+    status: "False"
+    reason: ProgressDeadlineExceeded
+`,
+			workload:   Object{Kind: "Deployment", Name: "app"},
+			timeout:    "2m",
+			want:       false,
+			wantLogged: []string{"error: deployment \"app\" exceeded its progress deadline\n"},
+		},
+		{
+			name: "statefulset timeout",
+			object: `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: data
+spec:
+  replicas: 2
+  updateStrategy:
+    type: RollingUpdate
+status:
+  replicas: 2
+  readyReplicas: 1
+`,
+			workload: Object{Kind: "StatefulSet", Name: "db", Namespace: "data"},
+			timeout:  "5ms",
+			want:     false,
+			wantLogged: []string{
+				"info: Waiting for statefulset spec update to be observed...\n",
+				"error: timed out waiting for statefulset/db to become ready\n",
+			},
+		},
+		{
+			name: "job complete",
+			object: `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: default
+status:
+  conditions:
+  - type: SuccessCriteriaMet
+    status: "True"
+  - type: Complete
+    status: "True"
+`,
+			workload:   Object{Kind: "Job", Name: "migrate"},
+			timeout:    "2m",
+			want:       true,
+			wantLogged: []string{"info: job \"migrate\" completed\n"},
+		},
+		{
+			name: "job failed",
+			object: `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: default
+status:
+  conditions:
+  - type: Failed
+    status: "True"
+    message: Job has reached the specified backoff limit
+`,
+			workload:   Object{Kind: "Job", Name: "migrate"},
+			timeout:    "2m",
+			want:       false,
+			wantLogged: []string{"error: job \"migrate\" failed: Job has reached the specified backoff limit\n"},
+		},
+		{
+			name:       "missing",
+			object:     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: other\n  namespace: default\n",
+			workload:   Object{Kind: "Job", Name: "migrate"},
+			timeout:    "2m",
+			want:       false,
+			wantLogged: []string{"error: jobs.batch \"migrate\" not found\n"},
+		},
+		{
+			name:       "invalid timeout",
+			object:     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: other\n  namespace: default\n",
+			workload:   Object{Kind: "Job", Name: "migrate"},
+			timeout:    "abc",
+			want:       false,
+			wantLogged: []string{"error: invalid timeout 'abc': time: invalid duration \"abc\"\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logMock := mocks.New()
+			log.SetHandler(logMock)
+			log.SetLevel(log.InfoLevel)
+			n, _, _ := newTestNative(t, []runtime.Object{toUnstructured(t, tt.object)})
+
+			assert.Equal(t, tt.want, n.RolloutStatus(tt.workload, tt.timeout))
+			logMock.Check(t, tt.wantLogged)
+		})
+	}
+}
+
+func TestNative_Events(t *testing.T) {
+	current := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+	n, _, _ := newTestNative(t, nil,
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "e2", Namespace: "default"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Deployment", Name: "app"},
+			Type:           "Normal",
+			Reason:         "ScalingReplicaSet",
+			Source:         corev1.EventSource{Component: "deployment-controller"},
+			Message:        "Scaled up replica set app-5cb459ff7d to 1",
+			LastTimestamp:  metav1.NewTime(current.Add(-time.Minute)),
+		},
+		&corev1.Event{
+			ObjectMeta:          metav1.ObjectMeta{Name: "e1", Namespace: "default"},
+			InvolvedObject:      corev1.ObjectReference{Kind: "Deployment", Name: "app"},
+			Type:                "Warning",
+			Reason:              "FailedCreate",
+			ReportingController: "deployment-controller",
+			Message:             "forbidden",
+			EventTime:           metav1.NewMicroTime(current.Add(-9 * time.Minute)),
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "default", Labels: map[string]string{"app": "app"}}},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "e3", Namespace: "default"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "app-1"},
+			Type:           "Warning",
+			Reason:         "BackOff",
+			Source:         corev1.EventSource{Component: "kubelet"},
+			Message:        "Back-off restarting failed container",
+			LastTimestamp:  metav1.NewTime(current.Add(-30 * time.Second)),
+		},
+	)
+
+	events, err := n.EventList(Object{Kind: "Deployment", Name: "app"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{
+		{Type: "Warning", Reason: "FailedCreate", From: "deployment-controller", Message: "forbidden", LastSeen: current.Add(-9 * time.Minute)},
+		{Type: "Normal", Reason: "ScalingReplicaSet", From: "deployment-controller", Message: "Scaled up replica set app-5cb459ff7d to 1", LastSeen: current.Add(-time.Minute)},
+	}, events)
+	assert.Equal(t, `Events:
+  Type     Reason             Age   From                   Message
+  ----     ------             ----  ----                   -------
+  Warning  FailedCreate       9m    deployment-controller  forbidden
+  Normal   ScalingReplicaSet  60s   deployment-controller  Scaled up replica set app-5cb459ff7d to 1
+`, formatEvents(events))
+	events, err = n.EventList(Object{Kind: "Deployment", Name: "other"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", formatEvents(events))
+	events, err = n.EventList(Object{Kind: "Pod", Name: "app-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, `Events:
+  Type     Reason   Age   From     Message
+  ----     ------   ----  ----     -------
+  Warning  BackOff  30s   kubelet  Back-off restarting failed container
+`, formatEvents(events))
+}
+
+func TestNative_Get(t *testing.T) {
+	n, _, _ := newTestNative(t, []runtime.Object{toUnstructured(t, `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: default
+  resourceVersion: "12"
+data:
+  key: value
+`)})
+
+	result, err := n.Get(Object{Kind: "ConfigMap", Name: "config"})
+	assert.NoError(t, err)
+	assert.Equal(t, "apiVersion: v1\ndata:\n  key: value\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: default\n", result)
+
+	result, err = n.Get(Object{Kind: "ConfigMap", Name: "missing"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", result)
+
+	_, err = n.Get(Object{Kind: "Unknown", Name: "missing"})
+	assert.EqualError(t, err, `no matches for /, Resource=unknown`)
+}
+
+func TestNative_GetByAPIVersion(t *testing.T) {
+	mapper := testMapper().(*meta.DefaultRESTMapper)
+	mapper.Add(schema.GroupVersionKind{Group: "example.org", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(testScheme(),
+		toUnstructured(t, "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n  namespace: default\n"),
+		toUnstructured(t, "apiVersion: example.org/v1\nkind: Deployment\nmetadata:\n  name: app\n  namespace: default\n"),
+	)
+	n := newNative(dynamicClient, kubefake.NewClientset(), mapper, "default", t.TempDir())
+
+	result, err := n.Get(Object{APIVersion: "example.org/v1", Kind: "Deployment", Name: "app"})
+	assert.NoError(t, err)
+	assert.Equal(t, "apiVersion: example.org/v1\nkind: Deployment\nmetadata:\n  name: app\n  namespace: default\n", result)
+
+	result, err = n.Get(Object{APIVersion: "apps/v1", Kind: "Deployment", Name: "app"})
+	assert.NoError(t, err)
+	assert.Equal(t, "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n  namespace: default\n", result)
+
+	_, err = n.Get(Object{Kind: "Deployment", Name: "app"})
+	assert.Error(t, err)
+}
+
+func TestNative_Delete(t *testing.T) {
+	n, dynamicClient, _ := newTestNative(t, []runtime.Object{toUnstructured(t, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: other\n")})
+
+	assert.NoError(t, n.Delete(Object{Kind: "ConfigMap", Name: "config", Namespace: "other"}))
+	assert.NoError(t, n.Delete(Object{Kind: "ConfigMap", Name: "config", Namespace: "other"}))
+	actions := dynamicClient.Actions()
+	assert.Equal(t, 2, len(actions))
+	assert.Equal(t, "delete", actions[0].GetVerb())
+	assert.Equal(t, "other", actions[0].GetNamespace())
+}
+
+func TestNative_List(t *testing.T) {
+	n, _, _ := newTestNative(t, []runtime.Object{
+		toUnstructured(t, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: default\n  labels:\n    build-tools/deploy: app\n"),
+		toUnstructured(t, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: other\n  namespace: default\n"),
+		toUnstructured(t, "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n  namespace: default\n  labels:\n    build-tools/deploy: app\n"),
+		toUnstructured(t, "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n  namespace: other\n  labels:\n    build-tools/deploy: app\n"),
+	})
+
+	result, err := n.List([]string{"configmap", "Deployment.v1.apps"}, "build-tools/deploy=app", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []Object{
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "config", Namespace: "default"},
+		{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Namespace: "default"},
+	}, result)
+
+	_, err = n.List([]string{"unknown"}, "build-tools/deploy=app", "")
+	assert.Error(t, err)
+}
+
+func TestNative_RolloutUndo(t *testing.T) {
+	n, _, _ := newTestNative(t,
+		[]runtime.Object{
+			toUnstructured(t, "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n  namespace: default\n"),
+			toUnstructured(t, "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migrate\n  namespace: default\n"),
+		},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}},
+	)
+
+	assert.EqualError(t, n.RolloutUndo(Object{Kind: "Job", Name: "migrate"}), `no rollbacker has been implemented for "Job.batch"`)
+	assert.EqualError(t, n.RolloutUndo(Object{Kind: "Deployment", Name: "app"}), `no rollout history found for deployment "app"`)
+}
+
+func TestNative_WaitForCondition(t *testing.T) {
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = 2 * time.Second }()
+	n, _, _ := newTestNative(t, []runtime.Object{
+		toUnstructured(t, `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: done
+  namespace: default
+status:
+  conditions:
+  - type: Complete
+    status: "True"
+`),
+		toUnstructured(t, `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: running
+  namespace: default
+status:
+  conditions:
+  - type: Complete
+    status: "False"
+`),
+	})
+
+	assert.NoError(t, n.WaitForCondition([]Object{{Kind: "Job", Name: "done"}}, "Complete", "0"))
+	assert.EqualError(t, n.WaitForCondition([]Object{{Kind: "Job", Name: "done"}, {Kind: "Job", Name: "running"}}, "Complete", "5ms"), "timed out waiting for condition Complete on job/running")
+	assert.EqualError(t, n.WaitForCondition([]Object{{Kind: "Job", Name: "missing"}}, "Complete", "5ms"), `jobs.batch "missing" not found`)
+	assert.EqualError(t, n.WaitForCondition(nil, "Complete", "abc"), `invalid timeout 'abc': time: invalid duration "abc"`)
+}
+
+func TestNewNative(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	_ = os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+contexts:
+- name: test
+  context:
+    cluster: test
+    namespace: from-context
+users: []
+`), 0o666)
+
+	n, err := NewNative(&config.Target{Context: "test", Kubeconfig: kubeconfig})
+	assert.NoError(t, err)
+	assert.Equal(t, "from-context", n.namespace)
+	assert.Equal(t, kubeconfig, n.Kubeconfig())
+	n.Cleanup()
+	_, err = os.Stat(n.tempDir)
+	assert.True(t, os.IsNotExist(err))
+
+	n, err = NewNative(&config.Target{Context: "test", Namespace: "override", Kubeconfig: kubeconfig})
+	assert.NoError(t, err)
+	assert.Equal(t, "override", n.namespace)
+	n.Cleanup()
+
+	_, err = NewNative(&config.Target{Context: "missing", Kubeconfig: kubeconfig})
+	assert.EqualError(t, err, `context "missing" does not exist`)
+	logMock.Check(t, []string{})
+}
@@ -0,0 +1,254 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package kubectl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// Object identifies a Kubernetes object in the cluster
+type Object struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+func (o Object) String() string {
+	return fmt.Sprintf("%s/%s", strings.ToLower(o.Kind), o.Name)
+}
+
+// Type returns the type of the object in the form accepted by kubectl, i.e. Deployment.v1.apps, so that a kind
+// can't be confused with the same kind in another group. Only the kind is used if the apiVersion is unknown.
+func (o Object) Type() string {
+	if o.APIVersion == "" {
+		return strings.ToLower(o.Kind)
+	}
+	gv, err := schema.ParseGroupVersion(o.APIVersion)
+	if err != nil {
+		return strings.ToLower(o.Kind)
+	}
+	return fmt.Sprintf("%s.%s.%s", o.Kind, gv.Version, gv.Group)
+}
+
+// IsWorkload returns true if the object can be waited on until it's ready
+func (o Object) IsWorkload() bool {
+	return workloadKinds[o.Kind]
+}
+
+var workloadKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+	"Job":         true,
+}
+
+// Objects finds all objects in the yaml documents in content.
+// Documents which aren't Kubernetes objects are ignored since they have already been accepted by Apply.
+func Objects(content string) ([]Object, error) {
+	var objects []Object
+	reader := yaml.NewYAMLReader(bufio.NewReader(strings.NewReader(content)))
+	for {
+		doc, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, nil
+			}
+			return nil, err
+		}
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(doc, &obj.Object); err != nil || obj.GetKind() == "" {
+			continue
+		}
+		objects = append(objects, objectOf(obj))
+	}
+}
+
+// decode parses all objects in the yaml documents in content, failing on documents which aren't Kubernetes objects
+func decode(content string) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	reader := yaml.NewYAMLReader(bufio.NewReader(strings.NewReader(content)))
+	for {
+		doc, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, nil
+			}
+			return nil, err
+		}
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(doc, &obj.Object); err != nil {
+			return nil, err
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.GetKind() == "" {
+			return nil, fmt.Errorf("object '%s' is missing kind", obj.GetName())
+		}
+		objects = append(objects, obj)
+	}
+}
+
+func objectOf(obj *unstructured.Unstructured) Object {
+	return Object{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Name: obj.GetName(), Namespace: obj.GetNamespace()}
+}
+
+// Workloads finds all objects in the yaml documents in content which can be waited on
+func Workloads(content string) ([]Object, error) {
+	objects, err := Objects(content)
+	if err != nil {
+		return nil, err
+	}
+	var workloads []Object
+	for _, object := range objects {
+		if object.IsWorkload() {
+			workloads = append(workloads, object)
+		}
+	}
+	return workloads, nil
+}
+
+// Label adds labels to all objects in the yaml documents in content
+func Label(content string, labels map[string]string) (string, error) {
+	var docs []string
+	reader := yaml.NewYAMLReader(bufio.NewReader(strings.NewReader(content)))
+	for {
+		doc, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return strings.Join(docs, "---\n"), nil
+			}
+			return "", err
+		}
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(doc, &obj.Object); err != nil || obj.GetKind() == "" {
+			if len(strings.TrimSpace(string(doc))) > 0 {
+				docs = append(docs, strings.TrimRight(string(doc), "\n")+"\n")
+			}
+			continue
+		}
+		existing := obj.GetLabels()
+		if existing == nil {
+			existing = make(map[string]string, len(labels))
+		}
+		for key, value := range labels {
+			existing[key] = value
+		}
+		obj.SetLabels(existing)
+		out, err := sigsyaml.Marshal(obj.Object)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, string(out))
+	}
+}
+
+// serverFields are populated by the API server and must be removed before a fetched object can be applied again
+var serverFields = [][]string{
+	{"status"},
+	{"metadata", "uid"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "generation"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "managedFields"},
+	{"metadata", "selfLink"},
+	{"metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration"},
+	{"metadata", "annotations", "deployment.kubernetes.io/revision"},
+}
+
+// Manifest is an object together with its yaml without server populated fields
+type Manifest struct {
+	Object  Object
+	Content string
+}
+
+// Manifests splits the yaml documents in content into separate objects, expanding Lists
+// and removing server populated fields
+func Manifests(content string) ([]Manifest, error) {
+	var manifests []Manifest
+	reader := yaml.NewYAMLReader(bufio.NewReader(strings.NewReader(content)))
+	for {
+		doc, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return manifests, nil
+			}
+			return nil, err
+		}
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(doc, &obj.Object); err != nil || obj.GetKind() == "" {
+			continue
+		}
+		items := []unstructured.Unstructured{*obj}
+		if obj.IsList() {
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, err
+			}
+			items = list.Items
+		}
+		for _, item := range items {
+			out, err := clean(&item)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, Manifest{
+				Object:  objectOf(&item),
+				Content: out,
+			})
+		}
+	}
+}
+
+// applicable strips server populated fields from a fetched object
+func applicable(live string) (string, error) {
+	obj := &unstructured.Unstructured{}
+	if err := sigsyaml.Unmarshal([]byte(live), &obj.Object); err != nil {
+		return "", err
+	}
+	return clean(obj)
+}
+
+func clean(obj *unstructured.Unstructured) (string, error) {
+	for _, field := range serverFields {
+		unstructured.RemoveNestedField(obj.Object, field...)
+	}
+	if len(obj.GetAnnotations()) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "metadata", "annotations")
+	}
+	out, err := sigsyaml.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
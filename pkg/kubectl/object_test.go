@@ -0,0 +1,200 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package kubectl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkloads(t *testing.T) {
+	content := `# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+---
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: data
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: cleanup
+`
+	workloads, err := Workloads(content)
+	assert.NoError(t, err)
+	assert.Equal(t, []Object{
+		{APIVersion: "apps/v1", Kind: "Deployment", Name: "app"},
+		{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "db", Namespace: "data"},
+		{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "agent"},
+		{APIVersion: "batch/v1", Kind: "Job", Name: "migrate"},
+	}, workloads)
+	assert.Equal(t, "statefulset/db", workloads[1].String())
+}
+
+func TestWorkloads_Empty(t *testing.T) {
+	workloads, err := Workloads("")
+	assert.NoError(t, err)
+	assert.Empty(t, workloads)
+}
+
+func TestWorkloads_NotObjects(t *testing.T) {
+	workloads, err := Workloads("dummy yaml content\n---\nkind: Job\nmetadata:\n  name: migrate\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []Object{{Kind: "Job", Name: "migrate"}}, workloads)
+}
+
+func TestObjects(t *testing.T) {
+	objects, err := Objects("apiVersion: v1\nkind: Service\nmetadata:\n  name: app\n---\ndummy\n---\nkind: Job\nmetadata:\n  name: migrate\n  namespace: jobs\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []Object{
+		{APIVersion: "v1", Kind: "Service", Name: "app"},
+		{Kind: "Job", Name: "migrate", Namespace: "jobs"},
+	}, objects)
+	assert.False(t, objects[0].IsWorkload())
+	assert.True(t, objects[1].IsWorkload())
+}
+
+func TestObject_Type(t *testing.T) {
+	assert.Equal(t, "Deployment.v1.apps", Object{APIVersion: "apps/v1", Kind: "Deployment", Name: "app"}.Type())
+	assert.Equal(t, "ConfigMap.v1.", Object{APIVersion: "v1", Kind: "ConfigMap", Name: "config"}.Type())
+	assert.Equal(t, "Widget.v1alpha1.example.org", Object{APIVersion: "example.org/v1alpha1", Kind: "Widget", Name: "widget"}.Type())
+	assert.Equal(t, "job", Object{Kind: "Job", Name: "migrate"}.Type())
+}
+
+func TestApplicable(t *testing.T) {
+	live := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  annotations:
+    deployment.kubernetes.io/revision: "3"
+  creationTimestamp: "2024-01-01T00:00:00Z"
+  generation: 3
+  managedFields:
+  - manager: kubectl
+  name: app
+  resourceVersion: "123"
+  uid: 1e6a5f55-2f67-4b0a-9f2d-3c1a2f0d1a2b
+spec:
+  replicas: 2
+status:
+  replicas: 2
+`
+	result, err := applicable(live)
+	assert.NoError(t, err)
+	assert.Equal(t, "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\nspec:\n  replicas: 2\n", result)
+
+	_, err = applicable("kind: [")
+	assert.Error(t, err)
+}
+
+func TestLabel(t *testing.T) {
+	content := `# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+  labels:
+    app: app
+---
+dummy
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+`
+	result, err := Label(content, map[string]string{"build-tools/deploy": "app.prod"})
+	assert.NoError(t, err)
+	assert.Equal(t, `apiVersion: v1
+kind: Service
+metadata:
+  labels:
+    app: app
+    build-tools/deploy: app.prod
+  name: app
+---
+dummy
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  labels:
+    build-tools/deploy: app.prod
+  name: config
+`, result)
+}
+
+func TestManifests(t *testing.T) {
+	content := `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: config
+    resourceVersion: "12"
+  data:
+    key: value
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: app
+    namespace: other
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+status:
+  replicas: 1
+`
+	manifests, err := Manifests(content)
+	assert.NoError(t, err)
+	assert.Equal(t, []Manifest{
+		{Object: Object{APIVersion: "v1", Kind: "ConfigMap", Name: "config"}, Content: "apiVersion: v1\ndata:\n  key: value\nkind: ConfigMap\nmetadata:\n  name: config\n"},
+		{Object: Object{APIVersion: "v1", Kind: "Service", Name: "app", Namespace: "other"}, Content: "apiVersion: v1\nkind: Service\nmetadata:\n  name: app\n  namespace: other\n"},
+		{Object: Object{APIVersion: "apps/v1", Kind: "Deployment", Name: "app"}, Content: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n"},
+	}, manifests)
+}
@@ -0,0 +1,143 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package kubectl
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// kindOrder is the order in which objects are applied, so that objects are created before the objects
+// depending on them. Kinds which aren't listed, like custom resources, are applied last.
+var kindOrder = []string{
+	"Namespace",
+	"CustomResourceDefinition",
+	"PriorityClass",
+	"StorageClass",
+	"ResourceQuota",
+	"LimitRange",
+	"NetworkPolicy",
+	"ServiceAccount",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Secret",
+	"ConfigMap",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"Service",
+	"Pod",
+	"ReplicaSet",
+	"Deployment",
+	"StatefulSet",
+	"DaemonSet",
+	"Job",
+	"CronJob",
+	"HorizontalPodAutoscaler",
+	"PodDisruptionBudget",
+	"IngressClass",
+	"Ingress",
+	"MutatingWebhookConfiguration",
+	"ValidatingWebhookConfiguration",
+}
+
+func kindPriority(kind string) int {
+	for i, k := range kindOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(kindOrder)
+}
+
+// Document is a yaml document in deployment descriptors, Object is empty for documents which aren't objects
+type Document struct {
+	Object  Object
+	Content string
+}
+
+// IsCRD returns true if the document is a CustomResourceDefinition
+func (d Document) IsCRD() bool {
+	return d.Object.Kind == "CustomResourceDefinition"
+}
+
+// Order splits the yaml documents in content and orders them by kind, keeping the original order
+// of documents with the same kind. Documents which aren't objects are kept last.
+func Order(content string) ([]Document, error) {
+	var docs []Document
+	reader := yaml.NewYAMLReader(bufio.NewReader(strings.NewReader(content)))
+	for {
+		doc, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		// the separator is included in the first document if content starts with one
+		content := strings.TrimPrefix(string(doc), "---\n")
+		if len(strings.TrimSpace(content)) == 0 {
+			continue
+		}
+		document := Document{Content: content}
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(doc, &obj.Object); err == nil && obj.GetKind() != "" {
+			document.Object = objectOf(obj)
+		}
+		docs = append(docs, document)
+	}
+	// the reader adds a newline to the last document if content doesn't end with one, which Join adds back if needed
+	if len(docs) > 0 && !strings.HasSuffix(content, "\n") {
+		docs[len(docs)-1].Content = strings.TrimSuffix(docs[len(docs)-1].Content, "\n")
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return documentPriority(docs[i]) < documentPriority(docs[j])
+	})
+	return docs, nil
+}
+
+func documentPriority(doc Document) int {
+	if doc.Object.Kind == "" {
+		return len(kindOrder) + 1
+	}
+	return kindPriority(doc.Object.Kind)
+}
+
+// Join joins documents into a single multi document yaml
+func Join(docs []Document) string {
+	contents := make([]string, len(docs))
+	for i, doc := range docs {
+		contents[i] = doc.Content
+		if i < len(docs)-1 && !strings.HasSuffix(doc.Content, "\n") {
+			contents[i] += "\n"
+		}
+	}
+	return strings.Join(contents, "---\n")
+}
@@ -0,0 +1,115 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package kubectl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrder(t *testing.T) {
+	docs, err := Order(`apiVersion: example.org/v1
+kind: Widget
+metadata:
+  name: widget
+---
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: apps
+---
+not an object
+---
+# only a comment
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.org
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: apps
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: other
+---
+`)
+	assert.NoError(t, err)
+	var objects []Object
+	for _, doc := range docs {
+		objects = append(objects, doc.Object)
+	}
+	assert.Equal(t, []Object{
+		{APIVersion: "v1", Kind: "Namespace", Name: "apps"},
+		{APIVersion: "apiextensions.k8s.io/v1", Kind: "CustomResourceDefinition", Name: "widgets.example.org"},
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "config"},
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "other"},
+		{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Namespace: "apps"},
+		{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Name: "app"},
+		{APIVersion: "example.org/v1", Kind: "Widget", Name: "widget"},
+		{},
+		{},
+	}, objects)
+	assert.False(t, docs[0].IsCRD())
+	assert.True(t, docs[1].IsCRD())
+	assert.Equal(t, "not an object\n", docs[7].Content)
+	assert.Equal(t, "# only a comment\n", docs[8].Content)
+}
+
+func TestOrder_Empty(t *testing.T) {
+	docs, err := Order("---\n\n---\n")
+	assert.NoError(t, err)
+	assert.Empty(t, docs)
+}
+
+func TestJoin(t *testing.T) {
+	assert.Equal(t, "a: 1\n---\nb: 2\n---\nc: 3", Join([]Document{{Content: "a: 1\n"}, {Content: "b: 2"}, {Content: "c: 3"}}))
+	assert.Equal(t, "", Join(nil))
+}
+
+func TestOrder_Join(t *testing.T) {
+	for _, content := range []string{"prod content", "prod content\n", "kind: Namespace\n---\nkind: ConfigMap"} {
+		docs, err := Order(content)
+		assert.NoError(t, err)
+		assert.Equal(t, content, Join(docs))
+	}
+	docs, err := Order("kind: ConfigMap\n---\nkind: Namespace")
+	assert.NoError(t, err)
+	assert.Equal(t, "kind: Namespace\n---\nkind: ConfigMap\n", Join(docs))
+}
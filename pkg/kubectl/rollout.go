@@ -0,0 +1,155 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package kubectl
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/apex/log"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kubectl/pkg/polymorphichelpers"
+)
+
+// RolloutState is the current state of a rollout
+type RolloutState struct {
+	Done    bool
+	Failed  bool
+	Message string
+}
+
+// rolloutStatus polls the state of workload with rollout until it's done, has failed or timeout has passed.
+// Each poll is a short request, so that the kubectl commands of clusters deployed to in parallel can run in
+// between instead of waiting for the whole rollout of another cluster
+func rolloutStatus(workload Object, timeout string, rollout func(Object) (RolloutState, error)) bool {
+	deadline, err := deadlineFor(timeout)
+	if err != nil {
+		log.Errorf("%v\n", err)
+		return false
+	}
+	message := ""
+	for {
+		state, err := rollout(workload)
+		if err != nil {
+			log.Errorf("%v\n", err)
+			return false
+		}
+		if state.Failed {
+			log.Errorf("%s", state.Message)
+			return false
+		}
+		if state.Message != message {
+			message = state.Message
+			log.Info(message)
+		}
+		if state.Done {
+			return true
+		}
+		if !deadline.IsZero() && now().After(deadline) {
+			log.Errorf("timed out waiting for %s to become ready\n", workload)
+			return false
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// waitForCondition polls the objects with get until condition is True for all of them
+func waitForCondition(objects []Object, condition, timeout string, get func(Object) (*unstructured.Unstructured, error)) error {
+	deadline, err := deadlineFor(timeout)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		for {
+			obj, err := get(object)
+			if err != nil {
+				return err
+			}
+			if hasCondition(obj, condition) {
+				break
+			}
+			if !deadline.IsZero() && now().After(deadline) {
+				return fmt.Errorf("timed out waiting for condition %s on %s", condition, object)
+			}
+			time.Sleep(pollInterval)
+		}
+	}
+	return nil
+}
+
+// deadlineFor returns when timeout has passed from now, or the zero time for a zero timeout which never passes
+func deadlineFor(timeout string) (time.Time, error) {
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timeout '%s': %w", timeout, err)
+	}
+	if duration > 0 {
+		return now().Add(duration), nil
+	}
+	return time.Time{}, nil
+}
+
+func hasCondition(obj *unstructured.Unstructured, conditionType string) bool {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		if condition, ok := c.(map[string]interface{}); ok && condition["type"] == conditionType && condition["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+// rolloutState returns the rollout state of obj, a workload of kind
+func rolloutState(kind schema.GroupKind, obj *unstructured.Unstructured) (RolloutState, error) {
+	if kind.Kind == "Job" {
+		return jobState(obj), nil
+	}
+	viewer, err := polymorphichelpers.StatusViewerFor(kind)
+	if err != nil {
+		return RolloutState{}, err
+	}
+	message, done, err := viewer.Status(obj, 0)
+	if err != nil {
+		// the status viewers return an error when a rollout has failed, i.e. exceeded its progress deadline
+		return RolloutState{Failed: true, Message: fmt.Sprintf("%v\n", err)}, nil
+	}
+	return RolloutState{Done: done, Message: message}, nil
+}
+
+func jobState(obj *unstructured.Unstructured) RolloutState {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["status"] != "True" {
+			continue
+		}
+		switch condition["type"] {
+		case "Complete":
+			return RolloutState{Done: true, Message: fmt.Sprintf("job %q completed\n", obj.GetName())}
+		case "Failed":
+			return RolloutState{Failed: true, Message: fmt.Sprintf("job %q failed: %v\n", obj.GetName(), condition["message"])}
+		}
+	}
+	return RolloutState{Message: fmt.Sprintf("Waiting for job %q to complete...\n", obj.GetName())}
+}
@@ -24,16 +24,58 @@
 
 package kubectl
 
+import (
+	"fmt"
+	"strings"
+)
+
 type MockKubectl struct {
 	Inputs     []string
 	Responses  []error
 	Deployment bool
 	Status     bool
+	Failing    []string
+	Waited     []Object
+	Existing   map[string]string
+	Undone     []Object
+	Deleted    []Object
+	UndoError  error
+	Listed     map[string][]Object
+	ListCalls  []string
+	DryRuns    []string
+	DryRunOut  map[string]string
+	// DiagnoseError is returned by Diagnose if set
+	DiagnoseError error
+	// KubeconfigFile is returned by Kubeconfig
+	KubeconfigFile string
+	// Conditions records WaitForCondition calls as "condition: objects"
+	Conditions []string
+	// WaitError is returned by WaitForCondition if set
+	WaitError error
 }
 
-func (m *MockKubectl) Apply(input string) error {
+func (m *MockKubectl) Apply(input string) ([]ApplyResult, error) {
 	m.Inputs = append(m.Inputs, input)
-	return m.Responses[len(m.Inputs)-1]
+	if err := m.Responses[len(m.Inputs)-1]; err != nil {
+		return nil, err
+	}
+	objects, err := Objects(input)
+	if err != nil {
+		return nil, err
+	}
+	var results []ApplyResult
+	for _, object := range objects {
+		results = append(results, ApplyResult{Object: object, Result: "serverside-applied"})
+	}
+	return results, nil
+}
+
+func (m *MockKubectl) DryRun(input, mode string) (string, error) {
+	m.DryRuns = append(m.DryRuns, fmt.Sprintf("%s: %s", mode, input))
+	if out, exists := m.DryRunOut[input]; exists {
+		return out, nil
+	}
+	return input, nil
 }
 
 func (m *MockKubectl) Cleanup() {
@@ -43,16 +85,53 @@ func (m *MockKubectl) DeploymentExists(name string) bool {
 	return m.Deployment
 }
 
-func (m *MockKubectl) RolloutStatus(name, timeout string) bool {
+func (m *MockKubectl) RolloutStatus(workload Object, timeout string) bool {
+	m.Waited = append(m.Waited, workload)
+	for _, name := range m.Failing {
+		if name == workload.Name {
+			return false
+		}
+	}
 	return m.Status
 }
 
-func (m *MockKubectl) DeploymentEvents(name string) string {
-	return "Deployment events"
+func (m *MockKubectl) WaitForCondition(objects []Object, condition, timeout string) error {
+	var names []string
+	for _, object := range objects {
+		names = append(names, object.String())
+	}
+	m.Conditions = append(m.Conditions, fmt.Sprintf("%s: %s", condition, strings.Join(names, " ")))
+	return m.WaitError
+}
+
+func (m *MockKubectl) Diagnose(workload Object) (*Diagnostics, error) {
+	if m.DiagnoseError != nil {
+		return nil, m.DiagnoseError
+	}
+	return &Diagnostics{Workload: workload, Pods: []PodDiagnostics{{Name: fmt.Sprintf("%s-1", workload.Name), Phase: "Running"}}}, nil
+}
+
+func (m *MockKubectl) Get(object Object) (string, error) {
+	return m.Existing[object.String()], nil
+}
+
+func (m *MockKubectl) RolloutUndo(workload Object) error {
+	m.Undone = append(m.Undone, workload)
+	return m.UndoError
+}
+
+func (m *MockKubectl) Delete(object Object) error {
+	m.Deleted = append(m.Deleted, object)
+	return nil
+}
+
+func (m *MockKubectl) List(kinds []string, selector, namespace string) ([]Object, error) {
+	m.ListCalls = append(m.ListCalls, fmt.Sprintf("%s -l %s -n %s", strings.Join(kinds, ","), selector, namespace))
+	return m.Listed[namespace], nil
 }
 
-func (m *MockKubectl) PodEvents(name string) string {
-	return "Pod events"
+func (m *MockKubectl) Kubeconfig() string {
+	return m.KubeconfigFile
 }
 
 var _ Kubectl = &MockKubectl{}
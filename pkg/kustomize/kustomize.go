@@ -0,0 +1,156 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package kustomize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"gopkg.in/yaml.v3"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// Find returns the directory in dir containing the kustomization to use for target.
+// An overlay in overlays/<target> is preferred, followed by a kustomization in dir itself
+// and finally a kustomization in base. A warning is logged if there are overlays but none for target.
+func Find(dir, target string) (string, bool) {
+	overlay := filepath.Join(dir, "overlays", target)
+	if target != "" && exists(overlay) {
+		log.Infof("Using kustomization in '<green>%s</green>' for target <green>%s</green>\n", display(dir, overlay), target)
+		return overlay, true
+	}
+	for _, candidate := range []string{dir, filepath.Join(dir, "base")} {
+		if !exists(candidate) {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(dir, "overlays")); err == nil && info.IsDir() && target != "" {
+			log.Warnf("No kustomize overlay found in '%s' for target %s, using '%s' instead\n", display(dir, overlay), target, display(dir, candidate))
+		} else {
+			log.Infof("Using kustomization in '<green>%s</green>'\n", display(dir, candidate))
+		}
+		return candidate, true
+	}
+	return "", false
+}
+
+// display returns path relative to the parent of dir, i.e. k8s/overlays/prod
+func display(dir, path string) string {
+	if rel, err := filepath.Rel(filepath.Dir(dir), path); err == nil {
+		return rel
+	}
+	return path
+}
+
+func exists(dir string) bool {
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadFunc is called with the path and content of each file read by kustomize and returns the content to use
+// instead, i.e. a decrypted resource
+type ReadFunc func(path string, content []byte) ([]byte, error)
+
+// readFs is a file system where the content of the files read is replaced by read
+type readFs struct {
+	filesys.FileSystem
+	read ReadFunc
+}
+
+func (f readFs) ReadFile(path string) ([]byte, error) {
+	content, err := f.FileSystem.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.read(path, content)
+}
+
+// Render builds the kustomization in dir and returns the resulting resources as yaml.
+// All images named name are replaced with image. If read is set, the files are passed through it before
+// kustomize transforms them.
+func Render(dir, name, image string, read ReadFunc) (string, error) {
+	wrapper, err := os.MkdirTemp(os.TempDir(), "build-tools")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.RemoveAll(wrapper) }()
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	// kustomize doesn't allow absolute paths to resources
+	resource, err := filepath.Rel(wrapper, absDir)
+	if err != nil {
+		return "", err
+	}
+	kustomization := types.Kustomization{
+		TypeMeta: types.TypeMeta{
+			APIVersion: types.KustomizationVersion,
+			Kind:       types.KustomizationKind,
+		},
+		Resources: []string{resource},
+		Images:    []types.Image{imageOverride(name, image)},
+	}
+	content, err := yaml.Marshal(kustomization)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(wrapper, konfig.DefaultKustomizationFileName()), content, 0o666); err != nil {
+		return "", err
+	}
+
+	fs := filesys.MakeFsOnDisk()
+	if read != nil {
+		fs = readFs{FileSystem: fs, read: read}
+	}
+	resources, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fs, wrapper)
+	if err != nil {
+		return "", fmt.Errorf("kustomize: %w", err)
+	}
+	out, err := resources.AsYaml()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// imageOverride splits image into name and tag or digest
+func imageOverride(name, image string) types.Image {
+	override := types.Image{Name: name, NewName: image}
+	if i := strings.LastIndex(image, "@"); i > 0 {
+		override.NewName, override.Digest = image[:i], image[i+1:]
+	} else if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		override.NewName, override.NewTag = image[:i], image[i+1:]
+	}
+	return override
+}
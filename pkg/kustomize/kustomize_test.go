@@ -0,0 +1,184 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package kustomize
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+const deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app
+`
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o777))
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o666))
+}
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  []string
+		target string
+		want   string
+		found  bool
+		logged []string
+	}{
+		{
+			name:   "no kustomization",
+			files:  []string{"deploy.yaml"},
+			logged: []string{},
+		},
+		{
+			name:   "kustomization in dir",
+			files:  []string{"kustomization.yaml"},
+			target: "prod",
+			want:   "",
+			found:  true,
+			logged: []string{"info: Using kustomization in '<green>k8s</green>'\n"},
+		},
+		{
+			name:   "overlay for target",
+			files:  []string{"base/kustomization.yaml", "overlays/prod/kustomization.yml"},
+			target: "prod",
+			want:   "overlays/prod",
+			found:  true,
+			logged: []string{"info: Using kustomization in '<green>k8s/overlays/prod</green>' for target <green>prod</green>\n"},
+		},
+		{
+			name:   "base when overlay for target is missing",
+			files:  []string{"base/Kustomization", "overlays/prod/kustomization.yaml"},
+			target: "staging",
+			want:   "base",
+			found:  true,
+			logged: []string{"warn: No kustomize overlay found in 'k8s/overlays/staging' for target staging, using 'k8s/base' instead\n"},
+		},
+		{
+			name:   "base without overlays",
+			files:  []string{"base/kustomization.yaml"},
+			target: "staging",
+			want:   "base",
+			found:  true,
+			logged: []string{"info: Using kustomization in '<green>k8s/base</green>'\n"},
+		},
+		{
+			name:   "overlay directory without kustomization",
+			files:  []string{"overlays/prod/deploy.yaml"},
+			logged: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logMock := mocks.New()
+			log.SetHandler(logMock)
+			log.SetLevel(log.InfoLevel)
+			dir := filepath.Join(t.TempDir(), "k8s")
+			for _, f := range tt.files {
+				writeFile(t, filepath.Join(dir, f), "")
+			}
+			got, found := Find(dir, tt.target)
+			assert.Equal(t, tt.found, found)
+			if tt.found {
+				assert.Equal(t, filepath.Join(dir, tt.want), got)
+			}
+			logMock.Check(t, tt.logged)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "base", "kustomization.yaml"), "resources:\n- deploy.yaml\n")
+	writeFile(t, filepath.Join(dir, "base", "deploy.yaml"), deployment)
+	writeFile(t, filepath.Join(dir, "overlays", "prod", "kustomization.yaml"), "resources:\n- ../../base\nnamePrefix: prod-\n")
+
+	got, err := Render(filepath.Join(dir, "overlays", "prod"), "app", "registry/app:abc123", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: prod-app
+spec:
+  template:
+    spec:
+      containers:
+      - image: registry/app:abc123
+        name: app
+`, got)
+}
+
+func TestRender_Error(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "kustomization.yaml"), "resources:\n- missing.yaml\n")
+
+	_, err := Render(dir, "app", "registry/app:abc123", nil)
+	assert.ErrorContains(t, err, "kustomize: accumulating resources")
+}
+
+func TestRender_Read(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "kustomization.yaml"), "resources:\n- config.yaml\nnamespace: prod\n")
+	writeFile(t, filepath.Join(dir, "config.yaml"), "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\ndata:\n  value: encrypted\n")
+	var read []string
+
+	got, err := Render(dir, "app", "registry/app:abc123", func(path string, content []byte) ([]byte, error) {
+		read = append(read, filepath.Base(path))
+		return []byte(strings.ReplaceAll(string(content), "encrypted", "decrypted")), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "apiVersion: v1\ndata:\n  value: decrypted\nkind: ConfigMap\nmetadata:\n  name: app\n  namespace: prod\n", got)
+	assert.Contains(t, read, "config.yaml")
+
+	_, err = Render(dir, "app", "registry/app:abc123", func(path string, content []byte) ([]byte, error) {
+		if filepath.Base(path) == "config.yaml" {
+			return nil, errors.New("failed to read")
+		}
+		return content, nil
+	})
+	assert.ErrorContains(t, err, "failed to read")
+}
+
+func TestImageOverride(t *testing.T) {
+	assert.Equal(t, types.Image{Name: "app", NewName: "registry/app", NewTag: "abc123"}, imageOverride("app", "registry/app:abc123"))
+	assert.Equal(t, types.Image{Name: "app", NewName: "registry:5000/app", NewTag: "abc123"}, imageOverride("app", "registry:5000/app:abc123"))
+	assert.Equal(t, types.Image{Name: "app", NewName: "registry:5000/app"}, imageOverride("app", "registry:5000/app"))
+	assert.Equal(t, types.Image{Name: "app", NewName: "registry/app", Digest: "sha256:1234"}, imageOverride("app", "registry/app@sha256:1234"))
+}
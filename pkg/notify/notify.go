@@ -0,0 +1,214 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+const (
+	Succeeded = "succeeded"
+	Failed    = "failed"
+)
+
+// DefaultMessage is the message template used unless the notification has its own
+const DefaultMessage = "{{ .App }} ({{ .Commit }}) {{ .Command }} to {{ .Target }} {{ .Outcome }} in {{ .Duration }}{{ if .Error }}: {{ .Error }}{{ end }}"
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Event is the completion of a deploy or promote, which is available in message templates
+type Event struct {
+	// Command is deploy or promote
+	Command string
+	Target  string
+	App     string
+	Commit  string
+	Branch  string
+	Image   string
+	// Outcome is succeeded or failed, or differences for deploy --diff
+	Outcome  string
+	Error    string
+	Duration time.Duration
+}
+
+// Send sends event to all notifications in cfg which apply to it
+func Send(cfg *config.NotificationsConfig, event Event) error {
+	if cfg == nil {
+		return nil
+	}
+	var errs []error
+	send := func(kind string, notifications []config.Notification, payload func(Event, string) any) {
+		for _, n := range notifications {
+			if !applies(n, event) {
+				continue
+			}
+			log.Debugf("Sending %s notification\n", kind)
+			if err := post(n, event, payload); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", kind, err))
+			}
+		}
+	}
+	send("slack", cfg.Slack, slack)
+	send("teams", cfg.Teams, teams)
+	send("webhook", cfg.Webhooks, webhook)
+	return errors.Join(errs...)
+}
+
+// applies returns true if n should be sent for event, given its targets and when to notify
+func applies(n config.Notification, event Event) bool {
+	if len(n.Targets) > 0 && !slices.Contains(n.Targets, event.Target) {
+		return false
+	}
+	switch n.On {
+	case "success":
+		return event.Outcome == Succeeded
+	case "failure":
+		return event.Outcome != Succeeded
+	}
+	return true
+}
+
+func post(n config.Notification, event Event, payload func(Event, string) any) error {
+	message, err := render(n.Message, event)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(payload(event, message))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, os.ExpandEnv(n.URL), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range n.Headers {
+		req.Header.Set(key, os.ExpandEnv(value))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		content, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(content)))
+	}
+	return nil
+}
+
+// render renders the message template text for event, using the default message if text is empty
+func render(text string, event Event) (string, error) {
+	if text == "" {
+		text = DefaultMessage
+	}
+	tmpl, err := template.New("message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid message template: %w", err)
+	}
+	out := &bytes.Buffer{}
+	if err := tmpl.Execute(out, event); err != nil {
+		return "", fmt.Errorf("invalid message template: %w", err)
+	}
+	return out.String(), nil
+}
+
+// slack is the payload for a Slack incoming webhook
+func slack(_ Event, message string) any {
+	return map[string]string{"text": message}
+}
+
+// teams is the payload for a Microsoft Teams incoming webhook, as a message card with the details as facts
+func teams(event Event, message string) any {
+	color := "2EB886"
+	if event.Outcome != Succeeded {
+		color = "D00000"
+	}
+	type fact struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	var facts []fact
+	for _, f := range []fact{
+		{"Target", event.Target},
+		{"App", event.App},
+		{"Commit", event.Commit},
+		{"Branch", event.Branch},
+		{"Image", event.Image},
+		{"Outcome", event.Outcome},
+		{"Duration", event.Duration.String()},
+	} {
+		if f.Value != "" {
+			facts = append(facts, f)
+		}
+	}
+	return map[string]any{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    message,
+		"themeColor": color,
+		"text":       message,
+		"sections":   []map[string]any{{"facts": facts}},
+	}
+}
+
+// webhook is the payload for a generic webhook
+func webhook(event Event, message string) any {
+	return struct {
+		Command         string  `json:"command"`
+		Target          string  `json:"target"`
+		App             string  `json:"app"`
+		Commit          string  `json:"commit"`
+		Branch          string  `json:"branch,omitempty"`
+		Image           string  `json:"image"`
+		Outcome         string  `json:"outcome"`
+		Error           string  `json:"error,omitempty"`
+		DurationSeconds float64 `json:"durationSeconds"`
+		Message         string  `json:"message"`
+	}{
+		Command:         event.Command,
+		Target:          event.Target,
+		App:             event.App,
+		Commit:          event.Commit,
+		Branch:          event.Branch,
+		Image:           event.Image,
+		Outcome:         event.Outcome,
+		Error:           event.Error,
+		DurationSeconds: event.Duration.Seconds(),
+		Message:         message,
+	}
+}
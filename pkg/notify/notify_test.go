@@ -0,0 +1,198 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package notify
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+type request struct {
+	path   string
+	header http.Header
+	body   string
+}
+
+func newServer(t *testing.T, status int) (*httptest.Server, *[]request) {
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, request{path: r.URL.Path, header: r.Header, body: string(body)})
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("invalid_payload\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+var event = Event{
+	Command:  "deploy",
+	Target:   "prod",
+	App:      "my-service",
+	Commit:   "abc123",
+	Branch:   "main",
+	Image:    "registry/my-service:abc123",
+	Outcome:  Succeeded,
+	Duration: 42 * time.Second,
+}
+
+func TestSend(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	t.Setenv("WEBHOOK_TOKEN", "s3cr3t")
+	server, requests := newServer(t, http.StatusOK)
+
+	err := Send(&config.NotificationsConfig{
+		Slack: []config.Notification{{URL: server.URL + "/slack"}},
+		Teams: []config.Notification{{URL: server.URL + "/teams"}},
+		Webhooks: []config.Notification{{
+			URL:     server.URL + "/webhook",
+			Message: "{{ .App }} is live on {{ .Target }}",
+			Headers: map[string]string{"Authorization": "Bearer $WEBHOOK_TOKEN"},
+		}},
+	}, event)
+
+	assert.NoError(t, err)
+	if assert.Len(t, *requests, 3) {
+		slack := (*requests)[0]
+		assert.Equal(t, "/slack", slack.path)
+		assert.Equal(t, "application/json", slack.header.Get("Content-Type"))
+		assert.JSONEq(t, `{"text":"my-service (abc123) deploy to prod succeeded in 42s"}`, slack.body)
+		teams := (*requests)[1]
+		assert.Equal(t, "/teams", teams.path)
+		assert.JSONEq(t, `{
+  "@type": "MessageCard",
+  "@context": "https://schema.org/extensions",
+  "summary": "my-service (abc123) deploy to prod succeeded in 42s",
+  "themeColor": "2EB886",
+  "text": "my-service (abc123) deploy to prod succeeded in 42s",
+  "sections": [{"facts": [
+    {"name": "Target", "value": "prod"},
+    {"name": "App", "value": "my-service"},
+    {"name": "Commit", "value": "abc123"},
+    {"name": "Branch", "value": "main"},
+    {"name": "Image", "value": "registry/my-service:abc123"},
+    {"name": "Outcome", "value": "succeeded"},
+    {"name": "Duration", "value": "42s"}
+  ]}]
+}`, teams.body)
+		webhook := (*requests)[2]
+		assert.Equal(t, "/webhook", webhook.path)
+		assert.Equal(t, "Bearer s3cr3t", webhook.header.Get("Authorization"))
+		assert.JSONEq(t, `{
+  "command": "deploy",
+  "target": "prod",
+  "app": "my-service",
+  "commit": "abc123",
+  "branch": "main",
+  "image": "registry/my-service:abc123",
+  "outcome": "succeeded",
+  "durationSeconds": 42,
+  "message": "my-service is live on prod"
+}`, webhook.body)
+	}
+	logMock.Check(t, []string{
+		"debug: Sending slack notification\n",
+		"debug: Sending teams notification\n",
+		"debug: Sending webhook notification\n",
+	})
+}
+
+func TestSend_Failure(t *testing.T) {
+	server, requests := newServer(t, http.StatusOK)
+	failed := event
+	failed.Outcome = Failed
+	failed.Error = "failed to rollout deployment/my-service"
+
+	err := Send(&config.NotificationsConfig{
+		Teams:    []config.Notification{{URL: server.URL + "/teams", On: "failure"}},
+		Webhooks: []config.Notification{{URL: server.URL + "/webhook", On: "failure"}},
+	}, failed)
+
+	assert.NoError(t, err)
+	if assert.Len(t, *requests, 2) {
+		assert.Contains(t, (*requests)[0].body, `"themeColor":"D00000"`)
+		assert.JSONEq(t, `{
+  "command": "deploy",
+  "target": "prod",
+  "app": "my-service",
+  "commit": "abc123",
+  "branch": "main",
+  "image": "registry/my-service:abc123",
+  "outcome": "failed",
+  "error": "failed to rollout deployment/my-service",
+  "durationSeconds": 42,
+  "message": "my-service (abc123) deploy to prod failed in 42s: failed to rollout deployment/my-service"
+}`, (*requests)[1].body)
+	}
+}
+
+func TestSend_Filtered(t *testing.T) {
+	server, requests := newServer(t, http.StatusOK)
+
+	err := Send(&config.NotificationsConfig{
+		Slack: []config.Notification{
+			{URL: server.URL + "/other-target", Targets: []string{"staging"}},
+			{URL: server.URL + "/failure", On: "failure"},
+			{URL: server.URL + "/success", On: "success", Targets: []string{"staging", "prod"}},
+			{URL: server.URL + "/always", On: "always"},
+		},
+	}, event)
+
+	assert.NoError(t, err)
+	var paths []string
+	for _, r := range *requests {
+		paths = append(paths, r.path)
+	}
+	assert.Equal(t, []string{"/success", "/always"}, paths)
+}
+
+func TestSend_Errors(t *testing.T) {
+	server, _ := newServer(t, http.StatusBadRequest)
+
+	err := Send(&config.NotificationsConfig{
+		Slack:    []config.Notification{{URL: server.URL}},
+		Teams:    []config.Notification{{URL: server.URL, Message: "{{ .Missing }}"}},
+		Webhooks: []config.Notification{{URL: server.URL, Message: "{{ .App "}, {URL: "http://[::1"}},
+	}, event)
+
+	assert.EqualError(t, err, `slack: unexpected status 400 Bad Request: invalid_payload
+teams: invalid message template: template: message:1:3: executing "message" at <.Missing>: can't evaluate field Missing in type notify.Event
+webhook: invalid message template: template: message:1: unclosed action
+webhook: parse "http://[::1": missing ']' in host`)
+}
+
+func TestSend_NotConfigured(t *testing.T) {
+	assert.NoError(t, Send(nil, event))
+	assert.NoError(t, Send(&config.NotificationsConfig{}, event))
+}
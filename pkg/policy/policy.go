@@ -0,0 +1,245 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package policy
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"cel.dev/cel-go/cel"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/kubectl"
+	"github.com/buildtool/build-tools/pkg/schema"
+)
+
+const (
+	noLatestTag    = "noLatestTag"
+	resources      = "resources"
+	noPrivileged   = "noPrivileged"
+	requiredLabels = "requiredLabels"
+)
+
+// Checker checks Kubernetes objects against the configured built-in and custom rules
+type Checker struct {
+	cfg   *config.PolicyConfig
+	rules []rule
+}
+
+// rule is a compiled custom rule
+type rule struct {
+	config.PolicyRule
+	program cel.Program
+}
+
+// Violation is an object breaking a rule
+type Violation struct {
+	Object  kubectl.Object
+	Rule    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s (%s)", v.Object, v.Message, v.Rule)
+}
+
+// Error is returned by Check when any of the objects break a rule
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = fmt.Sprintf("  %s", violation)
+	}
+	return fmt.Sprintf("policy check failed:\n%s", strings.Join(messages, "\n"))
+}
+
+// New creates a Checker for cfg, compiling the custom rules
+func New(cfg *config.PolicyConfig) (*Checker, error) {
+	env, err := cel.NewEnv(cel.Variable("object", cel.DynType))
+	if err != nil {
+		return nil, err
+	}
+	checker := &Checker{cfg: cfg}
+	for _, r := range cfg.Rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("name is mandatory for policy rules")
+		}
+		if r.Expression == "" {
+			return nil, fmt.Errorf("expression is mandatory for policy rule '%s'", r.Name)
+		}
+		ast, issues := env.Compile(r.Expression)
+		if issues.Err() != nil {
+			return nil, fmt.Errorf("invalid expression for policy rule '%s': %w", r.Name, issues.Err())
+		}
+		if t := ast.OutputType(); !t.IsExactType(cel.BoolType) && !t.IsExactType(cel.DynType) {
+			return nil, fmt.Errorf("expression for policy rule '%s' must evaluate to a bool, not %s", r.Name, t)
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression for policy rule '%s': %w", r.Name, err)
+		}
+		checker.rules = append(checker.rules, rule{PolicyRule: r, program: program})
+	}
+	return checker, nil
+}
+
+// Check checks all objects in the yaml documents in content. SOPS encrypted documents are ignored.
+func (c *Checker) Check(content string) error {
+	objects, err := schema.Parse(content)
+	if err != nil {
+		return err
+	}
+	var violations []Violation
+	for _, obj := range objects {
+		object := kubectl.Object{Kind: obj.GetKind(), Name: obj.GetName(), Namespace: obj.GetNamespace()}
+		for _, v := range c.check(obj) {
+			v.Object = object
+			violations = append(violations, v)
+		}
+	}
+	if len(violations) > 0 {
+		return &Error{Violations: violations}
+	}
+	return nil
+}
+
+func (c *Checker) check(obj *unstructured.Unstructured) []Violation {
+	var violations []Violation
+	if missing := missingLabels(obj, c.cfg.RequiredLabels); len(missing) > 0 {
+		violations = append(violations, Violation{Rule: requiredLabels, Message: fmt.Sprintf("missing required labels %s", strings.Join(missing, ", "))})
+	}
+	for _, container := range containers(obj) {
+		name, _, _ := unstructured.NestedString(container, "name")
+		if c.cfg.NoLatestTag {
+			if image, _, _ := unstructured.NestedString(container, "image"); latest(image) {
+				violations = append(violations, Violation{Rule: noLatestTag, Message: fmt.Sprintf("container '%s' must use an image with a tag other than latest, not '%s'", name, image)})
+			}
+		}
+		if c.cfg.Resources {
+			if missing := missingResources(container); len(missing) > 0 {
+				violations = append(violations, Violation{Rule: resources, Message: fmt.Sprintf("container '%s' must set %s", name, strings.Join(missing, ", "))})
+			}
+		}
+		if c.cfg.NoPrivileged {
+			if privileged, _, _ := unstructured.NestedBool(container, "securityContext", "privileged"); privileged {
+				violations = append(violations, Violation{Rule: noPrivileged, Message: fmt.Sprintf("container '%s' must not be privileged", name)})
+			}
+		}
+	}
+	for _, r := range c.rules {
+		if len(r.Kinds) > 0 && !slices.Contains(r.Kinds, obj.GetKind()) {
+			continue
+		}
+		if message := r.evaluate(obj); message != "" {
+			violations = append(violations, Violation{Rule: r.Name, Message: message})
+		}
+	}
+	return violations
+}
+
+// evaluate returns a message if obj doesn't satisfy the rule
+func (r rule) evaluate(obj *unstructured.Unstructured) string {
+	out, _, err := r.program.Eval(map[string]any{"object": obj.Object})
+	if err != nil {
+		return fmt.Sprintf("failed to evaluate '%s': %v", r.Expression, err)
+	}
+	ok, isBool := out.Value().(bool)
+	switch {
+	case !isBool:
+		return fmt.Sprintf("'%s' did not evaluate to a bool", r.Expression)
+	case ok:
+		return ""
+	case r.Message != "":
+		return r.Message
+	default:
+		return fmt.Sprintf("'%s' is not satisfied", r.Expression)
+	}
+}
+
+// podSpecPaths are the paths to the pod spec for the kinds which create pods
+var podSpecPaths = map[string][]string{
+	"Pod":                   {"spec"},
+	"Deployment":            {"spec", "template", "spec"},
+	"StatefulSet":           {"spec", "template", "spec"},
+	"DaemonSet":             {"spec", "template", "spec"},
+	"ReplicaSet":            {"spec", "template", "spec"},
+	"ReplicationController": {"spec", "template", "spec"},
+	"Job":                   {"spec", "template", "spec"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// containers returns the init containers and containers of obj, if it creates pods
+func containers(obj *unstructured.Unstructured) []map[string]any {
+	path, exists := podSpecPaths[obj.GetKind()]
+	if !exists {
+		return nil
+	}
+	var result []map[string]any
+	for _, field := range []string{"initContainers", "containers"} {
+		list, _, _ := unstructured.NestedSlice(obj.Object, append(slices.Clone(path), field)...)
+		for _, c := range list {
+			if container, ok := c.(map[string]any); ok {
+				result = append(result, container)
+			}
+		}
+	}
+	return result
+}
+
+// latest returns true if image has no tag or the latest tag, and isn't pinned to a digest
+func latest(image string) bool {
+	if strings.Contains(image, "@") {
+		return false
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	i := strings.LastIndex(name, ":")
+	return i < 0 || name[i+1:] == "latest"
+}
+
+func missingResources(container map[string]any) []string {
+	var missing []string
+	for _, kind := range []string{"requests", "limits"} {
+		for _, resource := range []string{"cpu", "memory"} {
+			if _, found, _ := unstructured.NestedFieldNoCopy(container, "resources", kind, resource); !found {
+				missing = append(missing, fmt.Sprintf("resources.%s.%s", kind, resource))
+			}
+		}
+	}
+	return missing
+}
+
+func missingLabels(obj *unstructured.Unstructured, required []string) []string {
+	labels := obj.GetLabels()
+	var missing []string
+	for _, label := range required {
+		if _, exists := labels[label]; !exists {
+			missing = append(missing, label)
+		}
+	}
+	return missing
+}
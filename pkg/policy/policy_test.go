@@ -0,0 +1,257 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/kubectl"
+)
+
+const deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  labels:
+    team: platform
+spec:
+  replicas: 1
+  template:
+    spec:
+      initContainers:
+        - name: migrate
+          image: registry/migrate
+          securityContext:
+            privileged: true
+      containers:
+        - name: app
+          image: registry:5000/app:abc123
+          resources:
+            requests:
+              cpu: 100m
+              memory: 64Mi
+            limits:
+              cpu: 500m
+              memory: 128Mi
+        - name: sidecar
+          image: proxy:latest
+          resources:
+            requests:
+              cpu: 10m
+`
+
+const cronJob = `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: job
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: job
+              image: registry/job@sha256:4b8e2b3a7c9f
+`
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []config.PolicyRule
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			rules: []config.PolicyRule{{Name: "replicas", Expression: "object.spec.replicas >= 2"}},
+		},
+		{
+			name:    "missing name",
+			rules:   []config.PolicyRule{{Expression: "true"}},
+			wantErr: "name is mandatory for policy rules",
+		},
+		{
+			name:    "missing expression",
+			rules:   []config.PolicyRule{{Name: "empty"}},
+			wantErr: "expression is mandatory for policy rule 'empty'",
+		},
+		{
+			name:    "invalid expression",
+			rules:   []config.PolicyRule{{Name: "broken", Expression: "object.spec.replicas >="}},
+			wantErr: "invalid expression for policy rule 'broken': ERROR: <input>:1:24: Syntax error: mismatched input '<EOF>'",
+		},
+		{
+			name:    "not a bool",
+			rules:   []config.PolicyRule{{Name: "string", Expression: "'abc'"}},
+			wantErr: "expression for policy rule 'string' must evaluate to a bool, not string",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker, err := New(&config.PolicyConfig{Rules: tt.rules})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Nil(t, checker)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, checker)
+			}
+		})
+	}
+}
+
+func TestChecker_Check(t *testing.T) {
+	app := kubectl.Object{Kind: "Deployment", Name: "app"}
+	job := kubectl.Object{Kind: "CronJob", Name: "job"}
+	tests := []struct {
+		name    string
+		cfg     config.PolicyConfig
+		content string
+		want    []Violation
+		wantErr string
+	}{
+		{
+			name:    "no rules",
+			content: deployment,
+		},
+		{
+			name:    "latest tag",
+			cfg:     config.PolicyConfig{NoLatestTag: true},
+			content: deployment + "---\n" + cronJob,
+			want: []Violation{
+				{Object: app, Rule: "noLatestTag", Message: "container 'migrate' must use an image with a tag other than latest, not 'registry/migrate'"},
+				{Object: app, Rule: "noLatestTag", Message: "container 'sidecar' must use an image with a tag other than latest, not 'proxy:latest'"},
+			},
+		},
+		{
+			name:    "resources",
+			cfg:     config.PolicyConfig{Resources: true},
+			content: deployment,
+			want: []Violation{
+				{Object: app, Rule: "resources", Message: "container 'migrate' must set resources.requests.cpu, resources.requests.memory, resources.limits.cpu, resources.limits.memory"},
+				{Object: app, Rule: "resources", Message: "container 'sidecar' must set resources.requests.memory, resources.limits.cpu, resources.limits.memory"},
+			},
+		},
+		{
+			name:    "privileged",
+			cfg:     config.PolicyConfig{NoPrivileged: true},
+			content: deployment,
+			want: []Violation{
+				{Object: app, Rule: "noPrivileged", Message: "container 'migrate' must not be privileged"},
+			},
+		},
+		{
+			name:    "required labels",
+			cfg:     config.PolicyConfig{RequiredLabels: []string{"team", "app"}},
+			content: deployment + "---\n" + cronJob,
+			want: []Violation{
+				{Object: app, Rule: "requiredLabels", Message: "missing required labels app"},
+				{Object: job, Rule: "requiredLabels", Message: "missing required labels team, app"},
+			},
+		},
+		{
+			name: "custom rules",
+			cfg: config.PolicyConfig{Rules: []config.PolicyRule{
+				{Name: "replicas", Kinds: []string{"Deployment"}, Expression: "object.spec.replicas >= 2", Message: "at least 2 replicas are required"},
+				{Name: "namespace", Expression: "has(object.metadata.namespace)"},
+				{Name: "schedule", Kinds: []string{"CronJob"}, Expression: "object.spec.schedule != ''"},
+			}},
+			content: deployment + "---\n" + cronJob,
+			want: []Violation{
+				{Object: app, Rule: "replicas", Message: "at least 2 replicas are required"},
+				{Object: app, Rule: "namespace", Message: "'has(object.metadata.namespace)' is not satisfied"},
+				{Object: job, Rule: "namespace", Message: "'has(object.metadata.namespace)' is not satisfied"},
+				{Object: job, Rule: "schedule", Message: "failed to evaluate 'object.spec.schedule != ''': no such key: schedule"},
+			},
+		},
+		{
+			name:    "dynamic result",
+			cfg:     config.PolicyConfig{Rules: []config.PolicyRule{{Name: "dyn", Expression: "object.spec.replicas"}}},
+			content: deployment,
+			want: []Violation{
+				{Object: app, Rule: "dyn", Message: "'object.spec.replicas' did not evaluate to a bool"},
+			},
+		},
+		{
+			name:    "satisfied",
+			cfg:     config.PolicyConfig{NoLatestTag: true, Rules: []config.PolicyRule{{Name: "replicas", Expression: "!has(object.spec.replicas) || object.spec.replicas >= 1"}}},
+			content: cronJob + "---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\nspec:\n  replicas: 1\n",
+		},
+		{
+			name:    "sops encrypted",
+			cfg:     config.PolicyConfig{RequiredLabels: []string{"team"}},
+			content: "apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\nsops:\n  mac: ENC[abc]\n",
+		},
+		{
+			name:    "invalid yaml",
+			cfg:     config.PolicyConfig{NoLatestTag: true},
+			content: "kind: [",
+			wantErr: "document 1 is not valid yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker, err := New(&tt.cfg)
+			assert.NoError(t, err)
+
+			err = checker.Check(tt.content)
+
+			switch {
+			case tt.wantErr != "":
+				assert.ErrorContains(t, err, tt.wantErr)
+			case tt.want == nil:
+				assert.NoError(t, err)
+			default:
+				assert.Equal(t, &Error{Violations: tt.want}, err)
+			}
+		})
+	}
+}
+
+func TestError_Error(t *testing.T) {
+	err := &Error{Violations: []Violation{
+		{Object: kubectl.Object{Kind: "Deployment", Name: "app"}, Rule: "noPrivileged", Message: "container 'app' must not be privileged"},
+		{Object: kubectl.Object{Kind: "Service", Name: "app"}, Rule: "requiredLabels", Message: "missing required labels team"},
+	}}
+
+	assert.Equal(t, "policy check failed:\n  deployment/app: container 'app' must not be privileged (noPrivileged)\n  service/app: missing required labels team (requiredLabels)", err.Error())
+}
+
+func TestLatest(t *testing.T) {
+	tests := map[string]bool{
+		"nginx":                      true,
+		"nginx:latest":               true,
+		"registry:5000/nginx":        true,
+		"registry:5000/nginx:latest": true,
+		"nginx:1.27":                 false,
+		"registry:5000/nginx:1.27":   false,
+		"nginx@sha256:4b8e2b3a7c9f":  false,
+	}
+	for image, want := range tests {
+		t.Run(image, func(t *testing.T) {
+			assert.Equal(t, want, latest(image))
+		})
+	}
+}
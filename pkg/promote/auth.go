@@ -0,0 +1,184 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package promote
+
+import (
+	"fmt"
+	neturl "net/url"
+	"os"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+const defaultKey = "~/.ssh/id_rsa"
+
+// tokenVariable is an environment variable with a token for HTTPS authentication, which is only sent to
+// the hosts of its provider
+type tokenVariable struct {
+	name string
+	// host is the host of the public instance of the provider
+	host string
+	// server is the environment variable with the URL or host of the instance running the CI job, if any
+	server string
+}
+
+// tokenVariables are the environment variables checked, in order, for a token for HTTPS authentication
+// if none is configured
+var tokenVariables = []tokenVariable{
+	{name: "GITHUB_TOKEN", host: "github.com", server: "GITHUB_SERVER_URL"},
+	{name: "GITLAB_TOKEN", host: "gitlab.com", server: "CI_SERVER_HOST"},
+	{name: "GITEA_TOKEN", host: "gitea.com"},
+	{name: "CI_JOB_TOKEN", host: "gitlab.com", server: "CI_SERVER_HOST"},
+}
+
+// matches returns true if host belongs to the provider of the token
+func (v tokenVariable) matches(host string) bool {
+	if strings.EqualFold(host, v.host) {
+		return true
+	}
+	if v.server == "" {
+		return false
+	}
+	server := os.Getenv(v.server)
+	if u, err := neturl.Parse(server); err == nil && u.Hostname() != "" {
+		server = u.Hostname()
+	}
+	return server != "" && strings.EqualFold(host, server)
+}
+
+// handleAuth determines how to authenticate to the Git repository at url from its scheme, using a token
+// for https URLs, no authentication for http URLs and SSH, with a key file or the ssh-agent, for everything else
+func handleAuth(url string, args Args, gitConfig config.Git) (transport.AuthMethod, error) {
+	switch {
+	case strings.HasPrefix(url, "https://"):
+		return handleToken(url, gitConfig)
+	case strings.HasPrefix(url, "http://"):
+		if gitConfig.Token != "" {
+			return nil, fmt.Errorf("refusing to send the git token over http, use an https URL instead of '%s'", url)
+		}
+		log.Debugf("Will access Git anonymously over http\n")
+		return nil, nil
+	}
+	return handleSSH(args, gitConfig)
+}
+
+func handleToken(url string, gitConfig config.Git) (transport.AuthMethod, error) {
+	user := gitConfig.User
+	token := os.ExpandEnv(gitConfig.Token)
+	if token == "" {
+		u, err := neturl.Parse(url)
+		if err != nil {
+			return nil, err
+		}
+		for _, variable := range tokenVariables {
+			if !variable.matches(u.Hostname()) {
+				continue
+			}
+			if token = os.Getenv(variable.name); token != "" {
+				log.Debugf("Will use token from %s\n", variable.name)
+				if variable.name == "CI_JOB_TOKEN" && user == "" {
+					user = "gitlab-ci-token"
+				}
+				break
+			}
+		}
+	}
+	if token == "" {
+		log.Debugf("No token found, will access Git anonymously\n")
+		return nil, nil
+	}
+	return &http.BasicAuth{
+		Username: defaultIfEmpty(user, "x-access-token"),
+		Password: token,
+	}, nil
+}
+
+func handleSSH(args Args, gitConfig config.Git) (transport.AuthMethod, error) {
+	privKey := args.PrivateKey
+	if privKey == "" {
+		privKey = gitConfig.Key
+	}
+	useAgent := gitConfig.Agent && args.PrivateKey == ""
+	if privKey == "" {
+		privKey = defaultKey
+		if _, err := os.Stat(expandHome(privKey)); os.IsNotExist(err) && os.Getenv("SSH_AUTH_SOCK") != "" {
+			useAgent = true
+		}
+	}
+	if useAgent {
+		log.Debugf("Will use ssh-agent\n")
+		auth, err := ssh.NewSSHAgentAuth(args.User)
+		if err != nil {
+			return nil, fmt.Errorf("ssh agent: %w", err)
+		}
+		if err := verifyHostKeys(&auth.HostKeyCallbackHelper, gitConfig); err != nil {
+			return nil, err
+		}
+		return auth, nil
+	}
+	privKey = expandHome(privKey)
+	log.Debugf("Will use SSH-key from %s\n", privKey)
+	keys, err := ssh.NewPublicKeysFromFile(args.User, privKey, args.Password)
+	if err != nil {
+		return nil, fmt.Errorf("ssh key: %w", err)
+	}
+	if err := verifyHostKeys(&keys.HostKeyCallbackHelper, gitConfig); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// verifyHostKeys verifies host keys against the configured known_hosts file, leaving the default files
+// ($SSH_KNOWN_HOSTS or ~/.ssh/known_hosts) to be used if none is configured
+func verifyHostKeys(helper *ssh.HostKeyCallbackHelper, gitConfig config.Git) error {
+	if gitConfig.KnownHosts == "" {
+		return nil
+	}
+	knownHosts := expandHome(os.ExpandEnv(gitConfig.KnownHosts))
+	if _, err := os.Stat(knownHosts); err != nil {
+		return fmt.Errorf("known hosts: %w", err)
+	}
+	callback, err := ssh.NewKnownHostsCallback(knownHosts)
+	if err != nil {
+		return fmt.Errorf("known hosts: %w", err)
+	}
+	helper.HostKeyCallback = callback
+	return nil
+}
+
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return fmt.Sprintf("%s%s", home, strings.TrimPrefix(path, "~"))
+}
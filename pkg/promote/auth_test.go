@@ -0,0 +1,287 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package promote
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+func clearTokens(t *testing.T) {
+	for _, variable := range tokenVariables {
+		t.Setenv(variable.name, "")
+		if variable.server != "" {
+			t.Setenv(variable.server, "")
+		}
+	}
+}
+
+func TestHandleAuth_HTTP(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		gitConfig config.Git
+		env       map[string]string
+		want      transport.AuthMethod
+		wantErr   string
+	}{
+		{
+			name:      "token from config",
+			url:       "https://github.com/org/gitops.git",
+			gitConfig: config.Git{Token: "$PROMOTE_TOKEN"},
+			env:       map[string]string{"PROMOTE_TOKEN": "s3cr3t", "GITHUB_TOKEN": "other"},
+			want:      &githttp.BasicAuth{Username: "x-access-token", Password: "s3cr3t"},
+		},
+		{
+			name:      "user from config",
+			url:       "https://gitea.example.org/org/gitops.git",
+			gitConfig: config.Git{User: "bot", Token: "s3cr3t"},
+			want:      &githttp.BasicAuth{Username: "bot", Password: "s3cr3t"},
+		},
+		{
+			name: "github token",
+			url:  "https://github.com/org/gitops.git",
+			env:  map[string]string{"GITHUB_TOKEN": "gh-token", "CI_JOB_TOKEN": "job-token"},
+			want: &githttp.BasicAuth{Username: "x-access-token", Password: "gh-token"},
+		},
+		{
+			name: "gitlab job token",
+			url:  "https://gitlab.com/org/gitops.git",
+			env:  map[string]string{"CI_JOB_TOKEN": "job-token"},
+			want: &githttp.BasicAuth{Username: "gitlab-ci-token", Password: "job-token"},
+		},
+		{
+			name:      "gitlab job token with user",
+			url:       "https://gitlab.local/org/gitops.git",
+			gitConfig: config.Git{User: "deployer"},
+			env:       map[string]string{"CI_JOB_TOKEN": "job-token", "CI_SERVER_HOST": "gitlab.local"},
+			want:      &githttp.BasicAuth{Username: "deployer", Password: "job-token"},
+		},
+		{
+			name: "github enterprise token",
+			url:  "https://github.example.org/org/gitops.git",
+			env:  map[string]string{"GITHUB_TOKEN": "gh-token", "GITHUB_SERVER_URL": "https://github.example.org"},
+			want: &githttp.BasicAuth{Username: "x-access-token", Password: "gh-token"},
+		},
+		{
+			name: "gitea token",
+			url:  "https://gitea.com/org/gitops.git",
+			env:  map[string]string{"GITHUB_TOKEN": "gh-token", "GITEA_TOKEN": "gitea-token"},
+			want: &githttp.BasicAuth{Username: "x-access-token", Password: "gitea-token"},
+		},
+		{
+			name: "tokens not sent to other hosts",
+			url:  "https://git.example.org/org/gitops.git",
+			env: map[string]string{
+				"GITHUB_TOKEN": "gh-token", "GITLAB_TOKEN": "gl-token", "GITEA_TOKEN": "gitea-token", "CI_JOB_TOKEN": "job-token",
+				"GITHUB_SERVER_URL": "https://github.com", "CI_SERVER_HOST": "gitlab.com",
+			},
+		},
+		{
+			name: "anonymous",
+			url:  "https://github.com/org/gitops.git",
+		},
+		{
+			name: "anonymous over http",
+			url:  "http://github.com/org/gitops.git",
+			env:  map[string]string{"GITHUB_TOKEN": "gh-token"},
+		},
+		{
+			name:      "token over http",
+			url:       "http://gitea.example.org/org/gitops.git",
+			gitConfig: config.Git{Token: "s3cr3t"},
+			wantErr:   "refusing to send the git token over http, use an https URL instead of 'http://gitea.example.org/org/gitops.git'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log.SetHandler(mocks.New())
+			clearTokens(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			got, err := handleAuth(tt.url, Args{User: "git"}, tt.gitConfig)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHandleAuth_SSHKey(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	defer log.SetLevel(log.InfoLevel)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	generateSSHKey(t, filepath.Join(home, ".ssh"))
+
+	got, err := handleAuth("git@github.com:org/gitops.git", Args{User: "git"}, config.Git{})
+
+	assert.NoError(t, err)
+	keys, ok := got.(*ssh.PublicKeys)
+	if assert.True(t, ok) {
+		assert.Equal(t, "git", keys.User)
+		assert.Nil(t, keys.HostKeyCallback)
+	}
+	assert.Equal(t, []string{"debug: Will use SSH-key from " + filepath.Join(home, ".ssh", "id_rsa") + "\n"}, logMock.Logged)
+}
+
+func TestHandleAuth_KnownHosts(t *testing.T) {
+	log.SetHandler(mocks.New())
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	generateSSHKey(t, filepath.Join(home, ".ssh"))
+	err := os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte("github.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl\n"), 0o600)
+	assert.NoError(t, err)
+
+	got, err := handleAuth("ssh://git@github.com/org/gitops.git", Args{User: "git"}, config.Git{KnownHosts: "~/.ssh/known_hosts"})
+	assert.NoError(t, err)
+	if keys, ok := got.(*ssh.PublicKeys); assert.True(t, ok) {
+		assert.NotNil(t, keys.HostKeyCallback)
+	}
+
+	_, err = handleAuth("git@github.com:org/gitops.git", Args{User: "git"}, config.Git{KnownHosts: "/missing/known_hosts"})
+	assert.EqualError(t, err, "known hosts: stat /missing/known_hosts: no such file or directory")
+}
+
+func TestHandleAuth_SSHAgent(t *testing.T) {
+	log.SetHandler(mocks.New())
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	keyring := agent.NewKeyring()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	assert.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: key}))
+	socket := filepath.Join(home, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _ = agent.ServeAgent(keyring, conn) }()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	got, err := handleAuth("git@github.com:org/gitops.git", Args{User: "deploy"}, config.Git{})
+	assert.NoError(t, err)
+	if auth, ok := got.(*ssh.PublicKeysCallback); assert.True(t, ok) {
+		assert.Equal(t, "deploy", auth.User)
+		signers, err := auth.Callback()
+		assert.NoError(t, err)
+		assert.Len(t, signers, 1)
+	}
+
+	generateSSHKey(t, filepath.Join(home, ".ssh"))
+	got, err = handleAuth("git@github.com:org/gitops.git", Args{User: "git"}, config.Git{})
+	assert.NoError(t, err)
+	assert.IsType(t, &ssh.PublicKeys{}, got)
+
+	got, err = handleAuth("git@github.com:org/gitops.git", Args{User: "git"}, config.Git{Agent: true})
+	assert.NoError(t, err)
+	assert.IsType(t, &ssh.PublicKeysCallback{}, got)
+}
+
+func TestHandleAuth_SSHAgentError(t *testing.T) {
+	log.SetHandler(mocks.New())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	_, err := handleAuth("git@github.com:org/gitops.git", Args{User: "git"}, config.Git{Agent: true})
+	assert.EqualError(t, err, `ssh agent: error creating SSH agent: "SSH agent requested but SSH_AUTH_SOCK not-specified"`)
+}
+
+func TestPromote_HTTPSToken(t *testing.T) {
+	log.SetHandler(mocks.New())
+	clearTokens(t)
+	t.Setenv("CI_JOB_TOKEN", "job-token")
+	t.Setenv("CI_SERVER_HOST", "127.0.0.1")
+	var user, password string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ = r.BasicAuth()
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	client.InstallProtocol("https", githttp.NewClient(server.Client()))
+	defer client.InstallProtocol("https", githttp.DefaultClient)
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "k8s"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "k8s", "deploy.yaml"), []byte("commit: ${COMMIT}\n"), 0o666)
+	assert.NoError(t, err)
+
+	err = Promote(dir, "app", "", &config.Gitops{URL: server.URL + "/org/gitops.git"}, Args{Target: "prod", Tag: "abc123", User: "git"}, config.InitEmptyConfig())
+
+	assert.EqualError(t, err, "authorization failed: ")
+	assert.Equal(t, "gitlab-ci-token", user)
+	assert.Equal(t, "job-token", password)
+}
+
+func TestPromote_HTTPTokenRefused(t *testing.T) {
+	log.SetHandler(mocks.New())
+	clearTokens(t)
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "k8s"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "k8s", "deploy.yaml"), []byte("commit: ${COMMIT}\n"), 0o666)
+	assert.NoError(t, err)
+	cfg := config.InitEmptyConfig()
+	cfg.Git.Token = "s3cr3t"
+
+	err = Promote(dir, "app", "", &config.Gitops{URL: server.URL + "/org/gitops.git"}, Args{Target: "prod", Tag: "abc123", User: "git"}, cfg)
+
+	assert.EqualError(t, err, "refusing to send the git token over http, use an https URL instead of '"+server.URL+"/org/gitops.git'")
+	assert.False(t, requested)
+}
@@ -0,0 +1,201 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package promote
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/gofrs/flock"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+// clone returns a clone of the repository at url with branch (the default branch if empty) checked out, either
+// in a new temporary directory or in the cache directory if one is configured. The returned cleanup removes
+// a temporary clone, or releases the lock which keeps other promotes from using a cached clone at the same time.
+func clone(url, branch string, auth transport.AuthMethod, gitConfig config.Git) (*git.Repository, func(), error) {
+	options := &git.CloneOptions{
+		URL:  url,
+		Auth: auth,
+	}
+	if branch != "" {
+		options.ReferenceName = plumbing.NewBranchReferenceName(branch)
+	}
+	if gitConfig.Shallow {
+		options.Depth = 1
+		options.SingleBranch = true
+	}
+	if gitConfig.CacheDir != "" {
+		dir := filepath.Join(expandHome(os.ExpandEnv(gitConfig.CacheDir)), cacheKey(url))
+		unlock, err := lock(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		repo, err := cached(dir, options)
+		if err != nil {
+			unlock()
+			return nil, nil, err
+		}
+		return repo, unlock, nil
+	}
+
+	cloneDir, err := os.MkdirTemp(os.TempDir(), "build-tools")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		_ = os.RemoveAll(cloneDir)
+	}
+	log.Debugf("Cloning into %s\n", cloneDir)
+	repo, err := git.PlainClone(cloneDir, false, options)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return repo, cleanup, nil
+}
+
+// cached updates the clone in dir by fetching and resetting it onto the remote head, cloning again if that
+// isn't possible
+func cached(dir string, options *git.CloneOptions) (*git.Repository, error) {
+	if _, err := os.Stat(dir); err == nil {
+		repo, err := update(dir, options)
+		if err == nil {
+			return repo, nil
+		}
+		log.Warnf("Unable to update cached clone in %s, cloning again: %v\n", dir, err)
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0o777); err != nil {
+		return nil, err
+	}
+	log.Debugf("Cloning into %s\n", dir)
+	repo, err := git.PlainClone(dir, false, options)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	return repo, nil
+}
+
+// lock waits until no other promote uses the cached clone in dir and locks it, returning a function which
+// releases the lock. The lock file is kept next to dir, since dir is removed if it can't be updated.
+func lock(dir string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(dir), 0o777); err != nil {
+		return nil, err
+	}
+	fileLock := flock.New(dir + ".lock")
+	locked, err := fileLock.TryLock()
+	if err != nil {
+		return nil, fmt.Errorf("unable to lock cached clone in %s: %w", dir, err)
+	}
+	if !locked {
+		log.Infof("Waiting for another promote using the cached clone in %s\n", dir)
+		if err := fileLock.Lock(); err != nil {
+			return nil, fmt.Errorf("unable to lock cached clone in %s: %w", dir, err)
+		}
+	}
+	return func() {
+		_ = fileLock.Unlock()
+	}, nil
+}
+
+func update(dir string, options *git.CloneOptions) (*git.Repository, error) {
+	log.Debugf("Updating cached clone in %s\n", dir)
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return nil, err
+	}
+	if urls := remote.Config().URLs; len(urls) == 0 || urls[0] != options.URL {
+		return nil, fmt.Errorf("cached clone is of %v, not %s", urls, options.URL)
+	}
+	branch := options.ReferenceName
+	if branch == "" {
+		if branch, err = defaultBranch(remote, options.Auth); err != nil {
+			return nil, err
+		}
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := fetchHead(repo, options.Auth, branch.Short(), options.Depth)
+	if err != nil {
+		return nil, err
+	}
+	checkout := &git.CheckoutOptions{Branch: branch, Force: true}
+	if _, err := repo.Reference(branch, false); err != nil {
+		checkout.Create = true
+		checkout.Hash = hash
+	}
+	if err := worktree.Checkout(checkout); err != nil {
+		return nil, err
+	}
+	if err := worktree.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset}); err != nil {
+		return nil, err
+	}
+	if err := worktree.Clean(&git.CleanOptions{Dir: true}); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// defaultBranch returns the branch which HEAD of remote points to. The branch checked out in a cached clone
+// can't be used, since it's the one used by the previous promote.
+func defaultBranch(remote *git.Remote, auth transport.AuthMethod) (plumbing.ReferenceName, error) {
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", err
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target(), nil
+		}
+	}
+	return "", fmt.Errorf("unable to find the default branch of %s", remote.Config().URLs[0])
+}
+
+// cacheKey is the name of the directory for the clone of url in the cache
+func cacheKey(url string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.TrimSuffix(url, ".git"))
+}
@@ -0,0 +1,246 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package promote
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+func logLength(t *testing.T, repo *git.Repository) int {
+	commits, err := repo.Log(&git.LogOptions{})
+	assert.NoError(t, err)
+	count := 0
+	_ = commits.ForEach(func(*object.Commit) error {
+		count++
+		return nil
+	})
+	return count
+}
+
+func TestClone_Temporary(t *testing.T) {
+	log.SetHandler(mocks.New())
+	gitops, _ := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	pushCompeting(t, gitops, "other")
+
+	repo, cleanup, err := clone(gitops, "", nil, config.Git{})
+
+	assert.NoError(t, err)
+	worktree, err := repo.Worktree()
+	assert.NoError(t, err)
+	root := worktree.Filesystem.Root()
+	assert.FileExists(t, filepath.Join(root, "other"))
+	assert.Equal(t, 2, logLength(t, repo))
+	cleanup()
+	assert.NoDirExists(t, root)
+}
+
+func TestClone_Shallow(t *testing.T) {
+	log.SetHandler(mocks.New())
+	gitops, _ := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	_, err := publish(cloneRepo(t, gitops), nil, testChange("commit: abc123\n"), config.Git{})
+	assert.NoError(t, err)
+	pushCompeting(t, gitops, "other")
+
+	full, cleanup, err := clone("file://"+gitops, "", nil, config.Git{})
+	assert.NoError(t, err)
+	defer cleanup()
+	shallow, cleanup, err := clone("file://"+gitops, "", nil, config.Git{Shallow: true})
+	assert.NoError(t, err)
+	defer cleanup()
+
+	assert.Equal(t, 3, logLength(t, full))
+	assert.Equal(t, 1, logLength(t, shallow))
+	previous, err := previousPromotion(full, "app/deploy.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", previous)
+	previous, err = previousPromotion(shallow, "app/deploy.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "", previous)
+}
+
+func TestClone_Cache(t *testing.T) {
+	log.SetHandler(mocks.New())
+	gitops, _ := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	cache := t.TempDir()
+	gitConfig := config.Git{CacheDir: cache}
+
+	repo, cleanup, err := clone(gitops, "", nil, gitConfig)
+	assert.NoError(t, err)
+	cleanup()
+	dir := filepath.Join(cache, cacheKey(gitops))
+	assert.DirExists(t, dir)
+	// leftovers from an earlier promote which failed to push
+	commitFile(t, repo, dir, "unpushed", "Unpushed")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "untracked"), []byte("data"), 0o666))
+	pushCompeting(t, gitops, "other")
+
+	repo, cleanup, err = clone(gitops, "", nil, gitConfig)
+	assert.NoError(t, err)
+	cleanup()
+	assert.DirExists(t, dir)
+	assert.FileExists(t, filepath.Join(dir, "other"))
+	assert.NoFileExists(t, filepath.Join(dir, "unpushed"))
+	assert.NoFileExists(t, filepath.Join(dir, "untracked"))
+	head, err := repo.Head()
+	assert.NoError(t, err)
+	assert.Equal(t, GetCommits(t, gitops)[0].Hash, head.Hash())
+	assert.Equal(t, plumbing.NewBranchReferenceName("master"), head.Name())
+}
+
+func TestClone_CacheBranch(t *testing.T) {
+	log.SetHandler(mocks.New())
+	gitops, _ := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	upstream, err := git.PlainOpen(gitops)
+	assert.NoError(t, err)
+	head, err := upstream.Head()
+	assert.NoError(t, err)
+	assert.NoError(t, upstream.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("release"), head.Hash())))
+	gitConfig := config.Git{CacheDir: t.TempDir()}
+
+	_, cleanup, err := clone(gitops, "", nil, gitConfig)
+	assert.NoError(t, err)
+	cleanup()
+	repo, cleanup, err := clone(gitops, "release", nil, gitConfig)
+	assert.NoError(t, err)
+	cleanup()
+
+	head, err = repo.Head()
+	assert.NoError(t, err)
+	assert.Equal(t, plumbing.NewBranchReferenceName("release"), head.Name())
+
+	pushCompeting(t, gitops, "other")
+	repo, cleanup, err = clone(gitops, "", nil, gitConfig)
+	assert.NoError(t, err)
+	cleanup()
+
+	head, err = repo.Head()
+	assert.NoError(t, err)
+	assert.Equal(t, plumbing.NewBranchReferenceName("master"), head.Name())
+	assert.Equal(t, GetCommits(t, gitops)[0].Hash, head.Hash())
+}
+
+func TestClone_CacheLocked(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	gitops, _ := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	cache := t.TempDir()
+	dir := filepath.Join(cache, cacheKey(gitops))
+	unlock, err := lock(dir)
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		_, cleanup, err := clone(gitops, "", nil, config.Git{CacheDir: cache})
+		if err == nil {
+			cleanup()
+		}
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected clone to wait for the lock")
+	case <-time.After(200 * time.Millisecond):
+	}
+	unlock()
+
+	assert.NoError(t, <-done)
+	assert.DirExists(t, dir)
+	CheckLogged(t, []string{"^info: Waiting for another promote using the cached clone in .*git-repo.*\n$"}, logMock.Logged)
+}
+
+func TestClone_CacheOfOtherRepository(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	gitops, _ := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	other, _ := InitRepo(t, "other-repo", true)
+	defer func() { _ = os.RemoveAll(other) }()
+	cache := t.TempDir()
+	dir := filepath.Join(cache, cacheKey(gitops))
+	_, err := git.PlainClone(dir, false, &git.CloneOptions{URL: other})
+	assert.NoError(t, err)
+
+	repo, _, err := clone(gitops, "", nil, config.Git{CacheDir: cache})
+
+	assert.NoError(t, err)
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{gitops}, remote.Config().URLs)
+	CheckLogged(t, []string{"^warn: Unable to update cached clone in .*, cloning again: cached clone is of \\[.*other-repo.*\\], not .*git-repo.*\n$"}, logMock.Logged)
+}
+
+func TestClone_Error(t *testing.T) {
+	log.SetHandler(mocks.New())
+	cache := t.TempDir()
+
+	_, _, err := clone("/missing/repo", "", nil, config.Git{})
+	assert.EqualError(t, err, "repository not found")
+	_, _, err = clone("/missing/repo", "", nil, config.Git{CacheDir: cache})
+	assert.EqualError(t, err, "repository not found")
+	assert.NoDirExists(t, filepath.Join(cache, cacheKey("/missing/repo")))
+}
+
+func TestCacheKey(t *testing.T) {
+	assert.Equal(t, "git_github.com_org_gitops", cacheKey("git@github.com:org/gitops.git"))
+	assert.Equal(t, "https___gitlab.example.org_group_sub_gitops", cacheKey("https://gitlab.example.org/group/sub/gitops"))
+}
+
+func TestPromote_CachedShallowClone(t *testing.T) {
+	log.SetHandler(mocks.New())
+	gitops, _ := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "k8s"), 0o777))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "k8s", "deploy.yaml"), []byte("commit: ${COMMIT}\n"), 0o666))
+	generateSSHKey(t, filepath.Join(dir, ".ssh"))
+	cfg := config.InitEmptyConfig()
+	cfg.Git = config.Git{Shallow: true, CacheDir: filepath.Join(dir, "cache")}
+	target := &config.Gitops{URL: "file://" + gitops}
+
+	for _, tag := range []string{"abc123", "def4567"} {
+		err := Promote(dir, "app", "", target, Args{Target: "prod", Tag: tag, shortSha: tag, PrivateKey: filepath.Join(dir, ".ssh", "id_rsa")}, cfg)
+		assert.NoError(t, err)
+	}
+
+	commits := GetCommits(t, gitops)
+	assert.Equal(t, 3, len(commits))
+	assert.Equal(t, "ci: promoting app to prod, commit def4567", commits[0].Message)
+	assert.Equal(t, "ci: promoting app to prod, commit abc123", commits[1].Message)
+}
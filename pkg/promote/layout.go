@@ -0,0 +1,137 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package promote
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+const defaultFile = "{{ .App }}/deploy.yaml"
+
+// layoutData is available in the file template
+type layoutData struct {
+	App    string
+	Target string
+}
+
+// layout determines the files in the gitops repository, relative to its root, for the generated descriptors
+func layout(target *config.Gitops, app, env string, content []byte) (change, error) {
+	file, rendered, err := gitopsFile(target, app, env)
+	if err != nil {
+		return change{}, err
+	}
+	if !target.Split {
+		return change{path: file, files: map[string][]byte{file: content}}, nil
+	}
+	dir := path.Dir(file)
+	if dir == "." {
+		return change{}, fmt.Errorf("invalid gitops file '%s', must be in a directory when splitting", rendered)
+	}
+	// everything in the directory is replaced, so it must not be shared with other applications
+	other, _, err := gitopsFile(target, app+"-other", env)
+	if err != nil {
+		return change{}, err
+	}
+	if path.Dir(other) == dir {
+		return change{}, fmt.Errorf("invalid gitops file '%s', the directory must be unique to the application when splitting, i.e. include {{ .App }}", rendered)
+	}
+	files, err := split(dir, content)
+	if err != nil {
+		return change{}, err
+	}
+	return change{path: dir, files: files, split: true}, nil
+}
+
+// gitopsFile returns the file for app in the gitops repository relative to its root, and the rendered file template
+func gitopsFile(target *config.Gitops, app, env string) (string, string, error) {
+	tmpl, err := template.New("file").Option("missingkey=error").Parse(defaultIfEmpty(target.File, defaultFile))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid gitops file: %w", err)
+	}
+	buff := &bytes.Buffer{}
+	if err := tmpl.Execute(buff, layoutData{App: app, Target: env}); err != nil {
+		return "", "", fmt.Errorf("invalid gitops file: %w", err)
+	}
+	relative := path.Clean(buff.String())
+	if relative == "." || relative == ".." || strings.HasPrefix(relative, "../") || path.IsAbs(relative) {
+		return "", "", fmt.Errorf("invalid gitops file '%s', must be a file below path", buff.String())
+	}
+	return strings.TrimPrefix(path.Join(filepath.ToSlash(target.Path), relative), "/"), buff.String(), nil
+}
+
+// split writes each yaml document in content to a file in dir named after the kind and name of the object
+func split(dir string, content []byte) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(content)))
+	for documents := 0; ; {
+		doc, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return files, nil
+			}
+			return nil, err
+		}
+		doc = bytes.TrimSpace(bytes.TrimPrefix(bytes.TrimSpace(doc), []byte("---")))
+		if len(doc) == 0 {
+			continue
+		}
+		documents++
+		name := fmt.Sprintf("document-%d", documents)
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(doc, &obj.Object); err == nil && obj.GetKind() != "" && obj.GetName() != "" {
+			name = fmt.Sprintf("%s-%s", obj.GetKind(), obj.GetName())
+		}
+		file := path.Join(dir, fileName(name)+".yaml")
+		if _, exists := files[file]; exists {
+			return nil, fmt.Errorf("more than one object would be written to %s", file)
+		}
+		files[file] = append(doc, '\n')
+	}
+}
+
+// fileName lower cases name and replaces characters which aren't safe in file names
+func fileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		default:
+			return '-'
+		}
+	}, name)
+}
@@ -0,0 +1,264 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package promote
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+const twoObjects = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+data:
+  KEY: value
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: default
+spec:
+  replicas: 1
+`
+
+func TestLayout(t *testing.T) {
+	content := []byte(twoObjects)
+	tests := []struct {
+		name    string
+		target  config.Gitops
+		want    change
+		wantErr string
+	}{
+		{
+			name:   "default",
+			target: config.Gitops{},
+			want:   change{path: "app/deploy.yaml", files: map[string][]byte{"app/deploy.yaml": content}},
+		},
+		{
+			name:   "root path",
+			target: config.Gitops{Path: "/"},
+			want:   change{path: "app/deploy.yaml", files: map[string][]byte{"app/deploy.yaml": content}},
+		},
+		{
+			name:   "path and file template",
+			target: config.Gitops{Path: "/clusters/", File: "{{ .Target }}/{{ .App }}/manifests.yaml"},
+			want:   change{path: "clusters/prod/app/manifests.yaml", files: map[string][]byte{"clusters/prod/app/manifests.yaml": content}},
+		},
+		{
+			name:   "split",
+			target: config.Gitops{Path: "apps", File: "{{ .App }}/{{ .Target }}/ignored.yaml", Split: true},
+			want: change{path: "apps/app/prod", split: true, files: map[string][]byte{
+				"apps/app/prod/configmap-app-config.yaml": []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app-config\ndata:\n  KEY: value\n"),
+				"apps/app/prod/deployment-app.yaml":       []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n  namespace: default\nspec:\n  replicas: 1\n"),
+			}},
+		},
+		{
+			name:    "invalid template",
+			target:  config.Gitops{File: "{{ .App "},
+			wantErr: "invalid gitops file: template: file:1: unclosed action",
+		},
+		{
+			name:    "unknown field",
+			target:  config.Gitops{File: "{{ .Name }}.yaml"},
+			wantErr: `invalid gitops file: template: file:1:3: executing "file" at <.Name>: can't evaluate field Name in type promote.layoutData`,
+		},
+		{
+			name:    "outside path",
+			target:  config.Gitops{Path: "apps", File: "../{{ .App }}.yaml"},
+			wantErr: "invalid gitops file '../app.yaml', must be a file below path",
+		},
+		{
+			name:    "empty",
+			target:  config.Gitops{File: "{{ if false }}x{{ end }}"},
+			wantErr: "invalid gitops file '', must be a file below path",
+		},
+		{
+			name:    "split into shared directory",
+			target:  config.Gitops{Path: "apps", File: "{{ .Target }}/manifests.yaml", Split: true},
+			wantErr: "invalid gitops file 'prod/manifests.yaml', the directory must be unique to the application when splitting, i.e. include {{ .App }}",
+		},
+		{
+			name:    "split with app in file name",
+			target:  config.Gitops{File: "{{ .Target }}/{{ .App }}.yaml", Split: true},
+			wantErr: "invalid gitops file 'prod/app.yaml', the directory must be unique to the application when splitting, i.e. include {{ .App }}",
+		},
+		{
+			name:    "split without directory",
+			target:  config.Gitops{File: "{{ .App }}.yaml", Split: true},
+			wantErr: "invalid gitops file 'app.yaml', must be in a directory when splitting",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := layout(&tt.target, "app", "prod", content)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	content := `---
+kind: Service
+metadata:
+  name: App_Service
+---
+# only a comment
+---
+sops:
+  mac: ENC[AES256_GCM,data:abc]
+---
+kind: Service
+metadata:
+  name: other
+`
+	files, err := split("app", []byte(content))
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"app/service-app-service.yaml": []byte("kind: Service\nmetadata:\n  name: App_Service\n"),
+		"app/document-2.yaml":          []byte("# only a comment\n"),
+		"app/document-3.yaml":          []byte("sops:\n  mac: ENC[AES256_GCM,data:abc]\n"),
+		"app/service-other.yaml":       []byte("kind: Service\nmetadata:\n  name: other\n"),
+	}, files)
+}
+
+func TestSplit_Duplicate(t *testing.T) {
+	_, err := split("app", []byte("kind: Service\nmetadata:\n  name: app\n---\nkind: Service\nmetadata:\n  name: app\n  namespace: other\n"))
+	assert.EqualError(t, err, "more than one object would be written to app/service-app.yaml")
+}
+
+func treeFiles(t *testing.T, commit *object.Commit) []string {
+	files, err := commit.Files()
+	assert.NoError(t, err)
+	var names []string
+	_ = files.ForEach(func(f *object.File) error {
+		names = append(names, f.Name)
+		return nil
+	})
+	sort.Strings(names)
+	return names
+}
+
+func TestPromote_BranchAndSplit(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	gitops, initial := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	upstream, err := git.PlainOpen(gitops)
+	assert.NoError(t, err)
+	release := plumbing.NewBranchReferenceName("release")
+	assert.NoError(t, upstream.Storer.SetReference(plumbing.NewHashReference(release, initial)))
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "k8s"), 0o777))
+	generateSSHKey(t, filepath.Join(dir, ".ssh"))
+	key := filepath.Join(dir, ".ssh", "id_rsa")
+	target := &config.Gitops{URL: gitops, Branch: "release", File: "{{ .Target }}/{{ .App }}/manifests.yaml", Split: true}
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "k8s", "deploy.yaml"), []byte(twoObjects), 0o666))
+	err = Promote(dir, "app", "", target, Args{Target: "prod", Tag: "abc123", shortSha: "abc123", PrivateKey: key}, config.InitEmptyConfig())
+	assert.NoError(t, err)
+	ref, err := upstream.Reference(release, true)
+	assert.NoError(t, err)
+	commit, err := upstream.CommitObject(ref.Hash())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file", "prod/app/configmap-app-config.yaml", "prod/app/deployment-app.yaml"}, treeFiles(t, commit))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "k8s", "deploy.yaml"), []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app-config\n"), 0o666))
+	err = Promote(dir, "app", "", target, Args{Target: "prod", Tag: "def456", shortSha: "def456", PrivateKey: key}, config.InitEmptyConfig())
+	assert.NoError(t, err)
+	ref, err = upstream.Reference(release, true)
+	assert.NoError(t, err)
+	commit, err = upstream.CommitObject(ref.Hash())
+	assert.NoError(t, err)
+	assert.Equal(t, "ci: promoting app to prod, commit def456", commit.Message)
+	assert.Equal(t, []string{"file", "prod/app/configmap-app-config.yaml"}, treeFiles(t, commit))
+
+	master, err := upstream.Reference(plumbing.NewBranchReferenceName("master"), true)
+	assert.NoError(t, err)
+	assert.Equal(t, initial, master.Hash())
+	clone, err := git.PlainClone(t.TempDir(), false, &git.CloneOptions{URL: gitops, ReferenceName: release})
+	assert.NoError(t, err)
+	previous, err := previousPromotion(clone, "prod/app")
+	assert.NoError(t, err)
+	assert.Equal(t, "def456", previous)
+	CheckLogged(t, []string{
+		"info: generating...\n",
+		"^info: pushing commit [0-9a-f]+ to .*git-repo.*/prod/app\n$",
+		"info: generating...\n",
+		"^info: pushing commit [0-9a-f]+ to .*git-repo.*/prod/app\n$",
+	}, logMock.Logged)
+}
+
+func TestPromote_SplitAppsSharingTarget(t *testing.T) {
+	log.SetHandler(mocks.New())
+	gitops, initial := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	upstream, err := git.PlainOpen(gitops)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "k8s"), 0o777))
+	generateSSHKey(t, filepath.Join(dir, ".ssh"))
+	key := filepath.Join(dir, ".ssh", "id_rsa")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "k8s", "deploy.yaml"), []byte(twoObjects), 0o666))
+	target := &config.Gitops{URL: gitops, File: "{{ .Target }}/{{ .App }}/manifests.yaml", Split: true}
+
+	for _, app := range []string{"app", "other"} {
+		err = Promote(dir, app, "", target, Args{Target: "prod", Tag: "abc123", shortSha: "abc123", PrivateKey: key}, config.InitEmptyConfig())
+		assert.NoError(t, err)
+	}
+	head, err := upstream.Head()
+	assert.NoError(t, err)
+	commit, err := upstream.CommitObject(head.Hash())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"file",
+		"prod/app/configmap-app-config.yaml", "prod/app/deployment-app.yaml",
+		"prod/other/configmap-app-config.yaml", "prod/other/deployment-app.yaml",
+	}, treeFiles(t, commit))
+
+	shared := &config.Gitops{URL: gitops, File: "{{ .Target }}/manifests.yaml", Split: true}
+	err = Promote(dir, "app", "", shared, Args{Target: "prod", Tag: "def456", shortSha: "def456", PrivateKey: key}, config.InitEmptyConfig())
+	assert.EqualError(t, err, "invalid gitops file 'prod/manifests.yaml', the directory must be unique to the application when splitting, i.e. include {{ .App }}")
+	after, err := upstream.Head()
+	assert.NoError(t, err)
+	assert.Equal(t, head.Hash(), after.Hash())
+	assert.NotEqual(t, initial, after.Hash())
+}
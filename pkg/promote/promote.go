@@ -24,6 +24,7 @@ package promote
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -32,30 +33,47 @@ import (
 	"time"
 
 	"github.com/apex/log"
-	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"golang.org/x/exp/utf8string"
 
-	"github.com/buildtool/build-tools/pkg/file"
-
 	"github.com/buildtool/build-tools/pkg/args"
 	"github.com/buildtool/build-tools/pkg/ci"
 	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/helm"
+	"github.com/buildtool/build-tools/pkg/notify"
+	"github.com/buildtool/build-tools/pkg/policy"
+	"github.com/buildtool/build-tools/pkg/registry"
+	"github.com/buildtool/build-tools/pkg/render"
+	"github.com/buildtool/build-tools/pkg/schema"
+	"github.com/buildtool/build-tools/pkg/templating"
 	"github.com/buildtool/build-tools/pkg/version"
 )
 
 type Args struct {
 	args.Globals
-	Target     string `arg:"" name:"target" help:"the target in the .buildtools.yaml" default:""`
-	Tag        string `name:"tag" help:"override the tag to deploy, not using the CI or VCS evaluated value" default:""`
-	URL        string `name:"url" help:"override the URL to the Git repository where files will be generated" default:""`
-	Path       string `name:"path" help:"override the path in the Git repository where files will be generated" default:""`
-	User       string `name:"user" help:"username for Git access" default:"git"`
-	PrivateKey string `name:"key" help:"private key for Git access (defaults to ~/.ssh/id_rsa)" default:""`
-	Password   string `name:"password" help:"password for private key" default:""`
-	Out        string `name:"out" short:"o" help:"write output to specified file instead of committing and pushing to Git" default:""`
-	shortSha   string
+	Target        string            `arg:"" name:"target" help:"the target in the .buildtools.yaml" default:""`
+	Tag           string            `name:"tag" help:"override the tag to deploy, not using the CI or VCS evaluated value" default:""`
+	URL           string            `name:"url" help:"override the URL to the Git repository where files will be generated" default:""`
+	Path          string            `name:"path" help:"override the path in the Git repository where files will be generated" default:""`
+	User          string            `name:"user" help:"username for Git access" default:"git"`
+	PrivateKey    string            `name:"key" help:"private key for Git access (defaults to ~/.ssh/id_rsa)" default:""`
+	Password      string            `name:"password" help:"password for private key" default:""`
+	Out           string            `name:"out" short:"o" help:"write output to specified file instead of committing and pushing to Git" default:""`
+	Variables     map[string]string `name:"var" help:"set a variable (KEY=VALUE) for substitution in deployment descriptors, overriding the gitops configuration"`
+	KeepEncrypted bool              `name:"keep-encrypted" help:"keep SOPS encrypted descriptors encrypted in the generated files instead of decrypting them"`
+	Validate      bool              `name:"validate" help:"validate the generated descriptors against the Kubernetes schemas before promoting them"`
+	PinDigest     bool              `name:"pin-digest" help:"resolve the tag to its digest in the registry and reference the image by digest"`
+	VerifyImage   bool              `name:"verify-image" help:"check that the image exists in the registry before promoting"`
+	PullRequest   bool              `name:"pull-request" help:"push to a new branch and open a pull/merge request instead of pushing to the default branch"`
+	shortSha      string
+	templating    bool
+	chart         string
+	validator     *schema.Validator
+	checker       *policy.Checker
+	// image is where Promote records the promoted image if set, i.e. pinned by digest
+	image *string
 }
 
 func DoPromote(dir string, info version.Info, osArgs ...string) int {
@@ -86,11 +104,23 @@ func DoPromote(dir string, info version.Info, osArgs ...string) int {
 		} else if target.Path == "" {
 			target.Path = "/"
 		}
+		if promoteArgs.PullRequest && target.PullRequest == nil {
+			target.PullRequest = &config.PullRequest{}
+		}
 		currentCI := cfg.CurrentCI()
+		started := time.Now()
+		var failure error
+		promoteArgs.image = new(string)
+		if cfg.Notifications.Configured() {
+			defer func() {
+				notifyCompleted(cfg, currentCI, promoteArgs, time.Since(started), failure)
+			}()
+		}
 		promoteArgs.shortSha = promoteArgs.Tag
 		if promoteArgs.Tag == "" {
 			if !ci.IsValid(currentCI) {
 				log.Errorf("Commit and/or branch information is <red>missing</red>. Perhaps your not in a Git repository or forgot to set environment variables?\n")
+				failure = errors.New("commit and/or branch information is missing")
 				return -3
 			}
 			promoteArgs.Tag = currentCI.Commit()
@@ -104,39 +134,108 @@ func DoPromote(dir string, info version.Info, osArgs ...string) int {
 			log.Infof("Using passed tag <green>%s</green> to promote\n", promoteArgs.Tag)
 		}
 
+		promoteArgs.Variables = config.MergeVariables(promoteArgs.Variables, target.Variables)
+		promoteArgs.KeepEncrypted = promoteArgs.KeepEncrypted || target.KeepEncrypted
+		promoteArgs.PinDigest = promoteArgs.PinDigest || target.PinDigest
+		promoteArgs.VerifyImage = promoteArgs.VerifyImage || target.VerifyImage
+		promoteArgs.templating = cfg.Templating.Enabled
+		promoteArgs.chart = cfg.Helm.Chart
+		if promoteArgs.Validate || cfg.Validation.Enabled {
+			if promoteArgs.validator, err = schema.NewFromConfig(dir, cfg.Validation); err != nil {
+				log.Error(err.Error())
+				failure = err
+				return -1
+			}
+		}
+		if cfg.Policy.Configured() {
+			if promoteArgs.checker, err = policy.New(cfg.Policy); err != nil {
+				log.Error(err.Error())
+				failure = err
+				return -1
+			}
+		}
+
 		tstamp := time.Now().Format(time.RFC3339)
 		if err := Promote(dir, currentCI.BuildName(), tstamp, target, promoteArgs, cfg); err != nil {
 			log.Error(err.Error())
+			failure = err
 			return -4
 		}
 	}
 	return 0
 }
 
+// notifyCompleted sends notifications about the completed promote, failing to do so doesn't fail the promote
+func notifyCompleted(cfg *config.Config, currentCI ci.CI, args Args, duration time.Duration, failure error) {
+	event := notify.Event{
+		Command:  "promote",
+		Target:   args.Target,
+		App:      currentCI.BuildName(),
+		Commit:   args.Tag,
+		Branch:   currentCI.Branch(),
+		Outcome:  notify.Succeeded,
+		Duration: duration.Round(time.Second),
+	}
+	if args.image != nil && *args.image != "" {
+		event.Image = *args.image
+	} else if args.Tag != "" {
+		event.Image = fmt.Sprintf("%s/%s:%s", cfg.CurrentRegistry().RegistryUrl(), currentCI.BuildName(), args.Tag)
+	}
+	if failure != nil {
+		event.Outcome = notify.Failed
+		event.Error = failure.Error()
+	}
+	if err := notify.Send(cfg.Notifications, event); err != nil {
+		log.Warnf("Failed to send notifications: %v\n", err)
+	}
+}
+
 func Promote(dir, name, timestamp string, target *config.Gitops, args Args, cfg *config.Config) error {
-	imageName := fmt.Sprintf("%s/%s:%s", cfg.CurrentRegistry().RegistryUrl(), cfg.CurrentCI().BuildName(), args.Tag)
-	buffer, err := generate(dir, args, timestamp, imageName)
+	imageName := fmt.Sprintf("%s/%s:%s", cfg.CurrentRegistry().RegistryUrl(), name, args.Tag)
+	if args.PinDigest {
+		pinned, err := registry.Pin(cfg.CurrentRegistry(), imageName)
+		if err != nil {
+			return err
+		}
+		log.Infof("Using image <green>%s</green>\n", pinned)
+		imageName = pinned
+	} else if args.VerifyImage {
+		if _, err := cfg.CurrentRegistry().Resolve(imageName); err != nil {
+			return err
+		}
+		log.Infof("Found image <green>%s</green> in registry\n", imageName)
+	}
+	if args.image != nil {
+		*args.image = imageName
+	}
+	buffer, err := generate(dir, name, args, timestamp, imageName)
 	if err != nil {
 		return err
 	}
-	if args.Out == "" {
-		keys, err := handleSSHKey(args, cfg.Git)
-		if err != nil {
+	if args.validator != nil {
+		log.Info("Validating deployment descriptors\n")
+		if err := args.validator.Validate(buffer.String()); err != nil {
 			return err
 		}
-		err = commitAndPush(target, keys, name, buffer, args, cfg.Git)
-		if err != nil {
-			if strings.HasPrefix(err.Error(), "git push error") || strings.Contains(err.Error(), "cannot lock ref") {
-				// Retry one more time
-				log.Infof("error during push, retrying\n")
-				err = commitAndPush(target, keys, name, buffer, args, cfg.Git)
-				if err != nil {
-					return err
-				}
-			} else {
+	}
+	if args.checker != nil {
+		log.Info("Checking deployment descriptors against policies\n")
+		if err := args.checker.Check(buffer.String()); err != nil {
+			return err
+		}
+	}
+	if args.Out == "" {
+		var pr *pullRequest
+		if target.PullRequest != nil {
+			if pr, err = newPullRequest(target.PullRequest, target.URL); err != nil {
 				return err
 			}
 		}
+		auth, err := handleAuth(target.URL, args, cfg.Git)
+		if err != nil {
+			return err
+		}
+		return commitAndPush(dir, target, auth, name, buffer, args, cfg.Git, pr)
 	} else {
 		err := os.WriteFile(args.Out, buffer.Bytes(), 0o666)
 		if err != nil {
@@ -147,103 +246,105 @@ func Promote(dir, name, timestamp string, target *config.Gitops, args Args, cfg
 	return nil
 }
 
-func commitAndPush(target *config.Gitops, keys *ssh.PublicKeys, name string, buffer *bytes.Buffer, args Args, gitConfig config.Git) error {
-	cloneDir, err := os.MkdirTemp(os.TempDir(), "build-tools")
-	if err != nil {
-		return err
-	}
-	defer func(path string) {
-		_ = os.RemoveAll(path)
-	}(cloneDir)
-	log.Debugf("Cloning into %s\n", cloneDir)
-	repo, err := git.PlainClone(cloneDir, false, &git.CloneOptions{
-		URL:  target.URL,
-		Auth: keys,
-	})
-	if err != nil {
-		return err
+// commitAndPush commits the generated descriptors to the gitops repository and pushes them, to a new branch
+// for which a pull request is opened if pr is set
+func commitAndPush(dir string, target *config.Gitops, auth transport.AuthMethod, name string, buffer *bytes.Buffer, args Args, gitConfig config.Git, pr *pullRequest) error {
+	base := target.Branch
+	if pr != nil && pr.base != "" {
+		base = pr.base
 	}
-	worktree, err := repo.Worktree()
+	repo, cleanup, err := clone(target.URL, base, auth, gitConfig)
 	if err != nil {
 		return err
 	}
-
+	defer cleanup()
 	normalized := strings.ReplaceAll(name, "_", "-")
 	if name != normalized {
 		log.Debugf("Normalized name from %s to %s\n", name, normalized)
 	}
-	err = os.MkdirAll(filepath.Join(cloneDir, target.Path, normalized), 0o777)
+	c, err := layout(target, normalized, args.Target, buffer.Bytes())
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(filepath.Join(cloneDir, target.Path, normalized, "deploy.yaml"), buffer.Bytes(), 0o666)
+	head, err := repo.Head()
 	if err != nil {
 		return err
 	}
-	_, err = worktree.Add(filepath.Join(target.Path, normalized, "deploy.yaml"))
-	if err != nil {
+	c.base = head.Name().Short()
+	c.message = fmt.Sprintf("ci: promoting %s to %s, commit %s", normalized, args.Target, args.shortSha)
+	c.destination = filepath.Join(target.URL, c.path)
+	if !c.split {
+		c.destination = filepath.Join(target.URL, filepath.Dir(c.path))
+	}
+	c.refSpec = gitconfig.RefSpec(fmt.Sprintf("%s:%s", head.Name(), head.Name()))
+	if pr == nil {
+		_, err = publish(repo, auth, c, gitConfig)
 		return err
 	}
-	hash, err := worktree.Commit(
-		fmt.Sprintf("ci: promoting %s to %s, commit %s", normalized, args.Target, args.shortSha),
-		&git.CommitOptions{
-			Author: &object.Signature{
-				Name:  defaultIfEmpty(gitConfig.Name, "Buildtools"),
-				Email: defaultIfEmpty(gitConfig.Email, "git@buildtools.io"),
-				When:  time.Now(),
-			},
-		},
-	)
+
+	previous, err := previousPromotion(repo, c.path)
 	if err != nil {
 		return err
 	}
-	commit, err := repo.CommitObject(hash)
-	if err != nil {
+	if pr.base == "" {
+		pr.base = c.base
+	}
+	branch := fmt.Sprintf("promote/%s-%s-%s", normalized, args.Target, args.shortSha)
+	c.refSpec = gitconfig.RefSpec(fmt.Sprintf("+%s:%s", head.Name(), plumbing.NewBranchReferenceName(branch)))
+	c.destination = fmt.Sprintf("branch %s of %s", branch, c.destination)
+	if _, err = publish(repo, auth, c, gitConfig); err != nil {
 		return err
 	}
-	log.Infof("pushing commit %s to %s\n", commit.Hash, filepath.Join(target.URL, target.Path, normalized))
-	err = repo.Push(&git.PushOptions{
-		Auth: keys,
+	url, err := pr.open(branch, pullRequestData{
+		App:         normalized,
+		Target:      args.Target,
+		Commit:      args.Tag,
+		ShortCommit: args.shortSha,
+		Previous:    previous,
+		Commits:     commitsSince(dir, args.Tag, previous),
+		Branch:      branch,
 	})
 	if err != nil {
-		return fmt.Errorf("git push error: %w", err)
+		return err
 	}
+	log.Infof("Opened pull request <green>%s</green>\n", url)
 	return nil
 }
 
-func handleSSHKey(args Args, gitConfig config.Git) (*ssh.PublicKeys, error) {
-	privKey := "~/.ssh/id_rsa"
-	if args.PrivateKey != "" {
-		privKey = args.PrivateKey
-	} else if gitConfig.Key != "" {
-		privKey = gitConfig.Key
+func generate(dir, name string, args Args, timestamp, imageName string) (*bytes.Buffer, error) {
+	chart := filepath.Join(dir, "k8s")
+	if args.chart != "" {
+		chart = filepath.Join(dir, args.chart)
 	}
-	if strings.HasPrefix(privKey, "~") {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, err
+	if !helm.IsChart(chart) {
+		if _, err := os.Lstat(filepath.Join(dir, "k8s")); os.IsNotExist(err) {
+			return nil, fmt.Errorf("no deployment descriptors found in k8s directory")
 		}
-		privKey = fmt.Sprintf("%s%s", home, strings.TrimPrefix(privKey, "~"))
-	}
-	log.Debugf("Will use SSH-key from %s\n", privKey)
-	keys, err := ssh.NewPublicKeysFromFile(args.User, privKey, args.Password)
-	if err != nil {
-		return nil, fmt.Errorf("ssh key: %w", err)
-	}
-	return keys, err
-}
-
-func generate(dir string, args Args, timestamp, imageName string) (*bytes.Buffer, error) {
-	deploymentFiles := filepath.Join(dir, "k8s")
-	if _, err := os.Lstat(deploymentFiles); os.IsNotExist(err) {
-		return nil, fmt.Errorf("no deployment descriptors found in k8s directory")
 	}
 
 	log.Info("generating...\n")
-	buffer := &bytes.Buffer{}
-	if err := processDir(buffer, deploymentFiles, args.Tag, timestamp, args.Target, imageName); err != nil {
+	d, err := render.Render(dir, render.Options{
+		Name:          name,
+		Chart:         args.chart,
+		Templating:    args.templating,
+		KeepEncrypted: args.KeepEncrypted,
+		Data: templating.Data{
+			Commit:    args.Tag,
+			Timestamp: timestamp,
+			Image:     imageName,
+			Target:    args.Target,
+			Variables: args.Variables,
+		},
+	})
+	if err != nil {
 		return nil, err
 	}
+	buffer := &bytes.Buffer{}
+	for _, content := range d.Contents {
+		if err := writeDocument(buffer, content); err != nil {
+			return nil, err
+		}
+	}
 	return buffer, nil
 }
 
@@ -254,39 +355,15 @@ func defaultIfEmpty(s string, def string) string {
 	return s
 }
 
-func processDir(writer io.StringWriter, dir, commit, timestamp, target, imageName string) error {
-	files, err := file.FindFilesForTarget(dir, target)
+func writeDocument(writer io.StringWriter, content string) error {
+	_, err := writer.WriteString(content)
 	if err != nil {
 		return err
 	}
-	for _, info := range files {
-		if f, err := os.Open(filepath.Join(dir, info.Name())); err != nil {
-			return err
-		} else {
-			if err := processFile(writer, f, commit, timestamp, imageName); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
-}
-
-func processFile(writer io.StringWriter, file *os.File, commit, timestamp, imageName string) error {
-	if buff, err := io.ReadAll(file); err != nil {
+	_, err = writer.WriteString("\n---\n")
+	if err != nil {
 		return err
-	} else {
-		content := string(buff)
-		r := strings.NewReplacer("${COMMIT}", commit, "${TIMESTAMP}", timestamp, "${IMAGE}", imageName)
-		kubeContent := r.Replace(content)
-		_, err := writer.WriteString(kubeContent)
-		if err != nil {
-			return err
-		}
-		_, err = writer.WriteString("\n---\n")
-		if err != nil {
-			return err
-		}
-
-		return nil
 	}
+
+	return nil
 }
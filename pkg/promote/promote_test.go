@@ -31,8 +31,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"text/template"
 
@@ -81,21 +84,35 @@ func TestDoPromote(t *testing.T) {
 				"info:   \\[<target>\\]    the target in the .buildtools.yaml\n",
 				"info: \n",
 				"info: Flags:\n",
-				"info:   -h, --help           Show context-sensitive help.\n",
-				"info:       --version        Print args information and exit\n",
-				"info:   -v, --verbose        Enable verbose mode\n",
-				"info:       --config         Print parsed config and exit\n",
-				"info:       --tag=\"\"         override the tag to deploy, not using the CI or VCS\n",
-				"info:                        evaluated value\n",
-				"info:       --url=\"\"         override the URL to the Git repository where files will\n",
-				"info:                        be generated\n",
-				"info:       --path=\"\"        override the path in the Git repository where files will\n",
-				"info:                        be generated\n",
-				"info:       --user=\"git\"     username for Git access\n",
-				"info:       --key=\"\"         private key for Git access \\(defaults to ~/.ssh/id_rsa\\)\n",
-				"info:       --password=\"\"    password for private key\n",
-				"info:   -o, --out=\"\"         write output to specified file instead of committing and\n",
-				"info:                        pushing to Git\n",
+				"info:   -h, --help                 Show context-sensitive help.\n",
+				"info:       --version              Print args information and exit\n",
+				"info:   -v, --verbose              Enable verbose mode\n",
+				"info:       --config               Print parsed config and exit\n",
+				"info:       --tag=\"\"               override the tag to deploy, not using the CI or VCS\n",
+				"info:                              evaluated value\n",
+				"info:       --url=\"\"               override the URL to the Git repository where files\n",
+				"info:                              will be generated\n",
+				"info:       --path=\"\"              override the path in the Git repository where files\n",
+				"info:                              will be generated\n",
+				"info:       --user=\"git\"           username for Git access\n",
+				"info:       --key=\"\"               private key for Git access \\(defaults to\n",
+				"info:                              ~/.ssh/id_rsa\\)\n",
+				"info:       --password=\"\"          password for private key\n",
+				"info:   -o, --out=\"\"               write output to specified file instead of\n",
+				"info:                              committing and pushing to Git\n",
+				"info:       --var=KEY=VALUE;...    set a variable \\(KEY=VALUE\\) for substitution in\n",
+				"info:                              deployment descriptors, overriding the gitops\n",
+				"info:                              configuration\n",
+				"info:       --keep-encrypted       keep SOPS encrypted descriptors encrypted in the\n",
+				"info:                              generated files instead of decrypting them\n",
+				"info:       --validate             validate the generated descriptors against the\n",
+				"info:                              Kubernetes schemas before promoting them\n",
+				"info:       --pin-digest           resolve the tag to its digest in the registry and\n",
+				"info:                              reference the image by digest\n",
+				"info:       --verify-image         check that the image exists in the registry before\n",
+				"info:                              promoting\n",
+				"info:       --pull-request         push to a new branch and open a pull/merge request\n",
+				"info:                              instead of pushing to the default branch\n",
 			},
 		},
 		{
@@ -430,6 +447,306 @@ func TestPromote_OutParam(t *testing.T) {
 	}
 }
 
+func TestPromote_GoTemplates(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	name := t.TempDir()
+	err := os.MkdirAll(filepath.Join(name, "k8s"), 0o777)
+	assert.NoError(t, err)
+	descriptor := `tag: {{ .Commit }}
+target: {{ .Target | upper }}
+commit: ${COMMIT}
+`
+	err = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte(descriptor), 0o666)
+	assert.NoError(t, err)
+	cfg := config.InitEmptyConfig()
+	out := filepath.Join(name, "output.yaml")
+
+	err = Promote(name, "dummy", "", nil, Args{Target: "prod", Tag: "abc123", Out: out, templating: true}, cfg)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "tag: abc123\ntarget: PROD\ncommit: abc123\n\n---\n", string(content))
+}
+
+func TestDoPromote_Variables(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	name := t.TempDir()
+	yaml := `
+gitops:
+  target:
+    url: git@example.org:test/gitops.git
+    variables:
+      REPLICAS: "1"
+      HOST: example.org
+`
+	err := os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(name, "k8s"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte("replicas: ${REPLICAS}\nhost: ${HOST}\n"), 0o666)
+	assert.NoError(t, err)
+	out := filepath.Join(name, "output.yaml")
+
+	got := DoPromote(name, version.Info{}, "target", "--tag", "abc123", "--out", out, "--var", "REPLICAS=3")
+	assert.Equal(t, 0, got)
+	content, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "replicas: 3\nhost: example.org\n\n---\n", string(content))
+}
+
+func TestDoPromote_Notifications(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	var notifications []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		notifications = append(notifications, r.URL.Path+" "+string(body))
+	}))
+	defer server.Close()
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	name := t.TempDir()
+	yaml := fmt.Sprintf(`
+gitops:
+  target:
+    url: git@example.org:test/gitops.git
+notifications:
+  slack:
+    - url: %[1]s/slack
+      message: "{{ .App }} {{ .Commit }} promoted to {{ .Target }}"
+  webhooks:
+    - url: %[1]s/failure
+      on: failure
+`, server.URL)
+	err := os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(name, "k8s"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte("image: ${IMAGE}\n"), 0o666)
+	assert.NoError(t, err)
+	out := filepath.Join(name, "output.yaml")
+
+	got := DoPromote(name, version.Info{}, "target", "--tag", "abc123", "--out", out)
+	assert.Equal(t, 0, got)
+	assert.Equal(t, []string{"/slack {\"text\":\"promote abc123 promoted to target\"}"}, notifications)
+
+	notifications = nil
+	got = DoPromote(name, version.Info{}, "target", "--tag", "abc123", "--out", filepath.Join(name, "missing", "output.yaml"))
+	assert.Equal(t, -4, got)
+	assert.Equal(t, []string{
+		"/slack {\"text\":\"promote abc123 promoted to target\"}",
+		fmt.Sprintf(`/failure {"command":"promote","target":"target","app":"promote","commit":"abc123","image":"noregistry/promote:abc123","outcome":"failed","error":"open %[1]s/missing/output.yaml: no such file or directory","durationSeconds":0,"message":"promote (abc123) promote to target failed in 0s: open %[1]s/missing/output.yaml: no such file or directory"}`, name),
+	}, notifications)
+}
+
+func TestDoPromote_NotificationsPinnedImage(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	registryServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Content-Digest", "sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7")
+	}))
+	defer registryServer.Close()
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = registryServer.Client().Transport
+	defer func() { http.DefaultClient.Transport = transport }()
+	host := strings.TrimPrefix(registryServer.URL, "https://")
+	var notifications []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		notifications = append(notifications, string(body))
+	}))
+	defer server.Close()
+	log.SetHandler(mocks.New())
+	name := t.TempDir()
+	yaml := fmt.Sprintf(`
+registry:
+  gitlab:
+    registry: %s/group
+gitops:
+  target:
+    url: git@example.org:test/gitops.git
+    pinDigest: true
+notifications:
+  slack:
+    - url: %s/slack
+      message: "{{ .Image }}"
+`, host, server.URL)
+	err := os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(name, "k8s"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte("image: ${IMAGE}\n"), 0o666)
+	assert.NoError(t, err)
+
+	got := DoPromote(name, version.Info{}, "target", "--tag", "abc123", "--out", filepath.Join(name, "output.yaml"))
+	assert.Equal(t, 0, got)
+	assert.Equal(t, []string{fmt.Sprintf(`{"text":"%s/group/promote@sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7"}`, host)}, notifications)
+}
+
+func TestPromote_Kustomize(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	name := t.TempDir()
+	k8s := filepath.Join(name, "k8s")
+	err := os.MkdirAll(filepath.Join(k8s, "overlays", "prod"), 0o777)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(k8s, "base"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(k8s, "base", "kustomization.yaml"), []byte("resources:\n- deploy.yaml\n"), 0o666)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(k8s, "base", "deploy.yaml"), []byte(`apiVersion: v1
+kind: Pod
+metadata:
+  name: dummy
+spec:
+  containers:
+  - name: dummy
+    image: dummy
+`), 0o666)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(k8s, "overlays", "prod", "kustomization.yaml"), []byte("resources:\n- ../../base\nnamePrefix: prod-\n"), 0o666)
+	assert.NoError(t, err)
+	cfg := config.InitEmptyConfig()
+	out := filepath.Join(name, "output.yaml")
+
+	err = Promote(name, "dummy", "", nil, Args{Target: "prod", Tag: "abc123", Out: out}, cfg)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, `apiVersion: v1
+kind: Pod
+metadata:
+  name: prod-dummy
+spec:
+  containers:
+  - image: noregistry/dummy:abc123
+    name: dummy
+
+---
+`, string(content))
+}
+
+func TestPromote_PinDigest(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/group/dummy/manifests/abc123" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7")
+	}))
+	defer server.Close()
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = server.Client().Transport
+	defer func() { http.DefaultClient.Transport = transport }()
+	host := strings.TrimPrefix(server.URL, "https://")
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	name := t.TempDir()
+	err := os.MkdirAll(filepath.Join(name, "k8s"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte("commit: ${COMMIT}\nimage: ${IMAGE}\n"), 0o666)
+	assert.NoError(t, err)
+	cfg := config.InitEmptyConfig()
+	cfg.Registry.Gitlab.Registry = host + "/group"
+	out := filepath.Join(name, "output.yaml")
+
+	err = Promote(name, "dummy", "", nil, Args{Target: "prod", Tag: "abc123", Out: out, PinDigest: true}, cfg)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("commit: abc123\nimage: %s/group/dummy@sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7\n\n---\n", host), string(content))
+
+	err = Promote(name, "missing", "", nil, Args{Target: "prod", Tag: "abc123", Out: out, PinDigest: true}, cfg)
+	assert.EqualError(t, err, fmt.Sprintf("image not found: '%s/group/missing:abc123' doesn't exist in Gitlab, make sure that it has been built and pushed", host))
+}
+
+func TestPromote_VerifyImage(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/group/dummy/manifests/abc123" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7")
+	}))
+	defer server.Close()
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = server.Client().Transport
+	defer func() { http.DefaultClient.Transport = transport }()
+	host := strings.TrimPrefix(server.URL, "https://")
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	name := t.TempDir()
+	err := os.MkdirAll(filepath.Join(name, "k8s"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(name, "k8s", "deploy.yaml"), []byte("image: ${IMAGE}\n"), 0o666)
+	assert.NoError(t, err)
+	cfg := config.InitEmptyConfig()
+	cfg.Registry.Gitlab.Registry = host + "/group"
+	out := filepath.Join(name, "output.yaml")
+
+	err = Promote(name, "dummy", "", nil, Args{Target: "prod", Tag: "abc123", Out: out, VerifyImage: true}, cfg)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("image: %s/group/dummy:abc123\n\n---\n", host), string(content))
+
+	err = os.Remove(out)
+	assert.NoError(t, err)
+	err = Promote(name, "dummy", "", nil, Args{Target: "prod", Tag: "def456", Out: out, VerifyImage: true}, cfg)
+	assert.EqualError(t, err, fmt.Sprintf("image not found: '%s/group/dummy:def456' doesn't exist in Gitlab, make sure that it has been built and pushed", host))
+	_, err = os.Stat(out)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPromote_HelmChart(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	name := t.TempDir()
+	k8s := filepath.Join(name, "k8s")
+	err := os.MkdirAll(filepath.Join(k8s, "templates"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(k8s, "Chart.yaml"), []byte("apiVersion: v2\nname: dummy\nversion: 0.1.0\n"), 0o666)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(k8s, "values-prod.yaml"), []byte("host: prod.example.com\n"), 0o666)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(k8s, "templates", "pod.yaml"), []byte(`apiVersion: v1
+kind: Pod
+metadata:
+  name: {{ .Release.Name }}
+  annotations:
+    host: {{ .Values.host }}
+    timestamp: ${TIMESTAMP}
+spec:
+  containers:
+  - name: dummy
+    image: {{ .Values.image.repository }}:{{ .Values.image.tag }}`), 0o666)
+	assert.NoError(t, err)
+	cfg := config.InitEmptyConfig()
+	out := filepath.Join(name, "output.yaml")
+
+	err = Promote(name, "dummy", "2019-05-13T17:22:36Z01:00", nil, Args{Target: "prod", Tag: "abc123", Out: out}, cfg)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, `---
+# Source: dummy/templates/pod.yaml
+apiVersion: v1
+kind: Pod
+metadata:
+  name: dummy
+  annotations:
+    host: prod.example.com
+    timestamp: 2019-05-13T17:22:36Z01:00
+spec:
+  containers:
+  - name: dummy
+    image: noregistry/dummy:abc123
+
+---
+`, string(content))
+}
+
 func generateSSHKey(t *testing.T, dir string) {
 	err := os.MkdirAll(dir, 0o777)
 	assert.NoError(t, err)
@@ -536,3 +853,184 @@ func Template(t *testing.T, text, repo, otherrepo string) *bytes.Buffer {
 func strPointer(s string) *string {
 	return &s
 }
+
+func TestPromote_EncryptedSecret(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join("..", "sops", "testdata", "age-key.txt"))
+	encrypted, err := os.ReadFile(filepath.Join("..", "sops", "testdata", "secret.enc.yaml"))
+	assert.NoError(t, err)
+	tests := []struct {
+		name          string
+		keepEncrypted bool
+		want          string
+	}{
+		{
+			name: "decrypted",
+			want: "apiVersion: v1\nkind: Secret\nmetadata:\n    name: db\nstringData:\n    password: s3cr3t\n\n---\n",
+		},
+		{
+			name:          "kept encrypted",
+			keepEncrypted: true,
+			want:          string(encrypted) + "\n---\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logMock := mocks.New()
+			log.SetHandler(logMock)
+			name := t.TempDir()
+			err := os.MkdirAll(filepath.Join(name, "k8s"), 0o777)
+			assert.NoError(t, err)
+			err = os.WriteFile(filepath.Join(name, "k8s", "secret.yaml"), encrypted, 0o666)
+			assert.NoError(t, err)
+			cfg := config.InitEmptyConfig()
+			out := filepath.Join(name, "output.yaml")
+
+			err = Promote(name, "dummy", "", nil, Args{Target: "prod", Tag: "abc123", Out: out, KeepEncrypted: tt.keepEncrypted}, cfg)
+			assert.NoError(t, err)
+			content, err := os.ReadFile(out)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(content))
+		})
+	}
+}
+
+func TestDoPromote_KeepEncrypted(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	name := t.TempDir()
+	yaml := `
+gitops:
+  target:
+    url: git@example.org:test/gitops.git
+    keepEncrypted: true
+`
+	err := os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+	assert.NoError(t, err)
+	encrypted, err := os.ReadFile(filepath.Join("..", "sops", "testdata", "secret.enc.yaml"))
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(name, "k8s"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(name, "k8s", "secret.yaml"), encrypted, 0o666)
+	assert.NoError(t, err)
+	out := filepath.Join(name, "output.yaml")
+
+	got := DoPromote(name, version.Info{}, "target", "--tag", "abc123", "--out", out)
+	assert.Equal(t, 0, got)
+	content, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, string(encrypted)+"\n---\n", string(content))
+}
+
+func TestDoPromote_ValidationFails(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	name := t.TempDir()
+	yaml := `
+gitops:
+  target:
+    url: git@example.org:test/gitops.git
+validation:
+  apiVersionsFor: "1.25"
+`
+	err := os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(name, "k8s"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(name, "k8s", "pdb.yaml"), []byte("apiVersion: policy/v1beta1\nkind: PodDisruptionBudget\nmetadata:\n  name: app\n"), 0o666)
+	assert.NoError(t, err)
+	out := filepath.Join(name, "output.yaml")
+
+	got := DoPromote(name, version.Info{}, "target", "--tag", "abc123", "--out", out, "--validate")
+	assert.Equal(t, -4, got)
+	_, err = os.Stat(out)
+	assert.True(t, os.IsNotExist(err))
+	CheckLogged(t, []string{
+		"info: Using passed tag <green>abc123</green> to promote\n",
+		"info: generating...\n",
+		"info: Validating deployment descriptors\n",
+		"error: validation failed:\n  poddisruptionbudget/app: policy/v1beta1 PodDisruptionBudget was removed in Kubernetes 1.25, use policy/v1 instead",
+	}, logMock.Logged)
+}
+
+func TestDoPromote_InvalidValidationConfig(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	name := t.TempDir()
+	yaml := `
+gitops:
+  target:
+    url: git@example.org:test/gitops.git
+validation:
+  enabled: true
+  apiVersionsFor: latest
+`
+	err := os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+	assert.NoError(t, err)
+
+	got := DoPromote(name, version.Info{}, "target", "--tag", "abc123")
+	assert.Equal(t, -1, got)
+	CheckLogged(t, []string{
+		"info: Using passed tag <green>abc123</green> to promote\n",
+		"error: invalid kubernetes version 'latest', must be like 1.29",
+	}, logMock.Logged)
+}
+
+func TestDoPromote_PolicyViolation(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	name := t.TempDir()
+	yaml := `
+gitops:
+  target:
+    url: git@example.org:test/gitops.git
+policy:
+  requiredLabels:
+    - team
+`
+	err := os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(name, "k8s"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(name, "k8s", "config.yaml"), []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n"), 0o666)
+	assert.NoError(t, err)
+	out := filepath.Join(name, "output.yaml")
+
+	got := DoPromote(name, version.Info{}, "target", "--tag", "abc123", "--out", out)
+	assert.Equal(t, -4, got)
+	_, err = os.Stat(out)
+	assert.True(t, os.IsNotExist(err))
+	CheckLogged(t, []string{
+		"info: Using passed tag <green>abc123</green> to promote\n",
+		"info: generating...\n",
+		"info: Checking deployment descriptors against policies\n",
+		"error: policy check failed:\n  configmap/config: missing required labels team \\(requiredLabels\\)",
+	}, logMock.Logged)
+}
+
+func TestDoPromote_InvalidPolicyConfig(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	name := t.TempDir()
+	yaml := `
+gitops:
+  target:
+    url: git@example.org:test/gitops.git
+policy:
+  rules:
+    - name: replicas
+`
+	err := os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0o777)
+	assert.NoError(t, err)
+
+	got := DoPromote(name, version.Info{}, "target", "--tag", "abc123")
+	assert.Equal(t, -1, got)
+	CheckLogged(t, []string{
+		"info: Using passed tag <green>abc123</green> to promote\n",
+		"error: expression is mandatory for policy rule 'replicas'",
+	}, logMock.Logged)
+}
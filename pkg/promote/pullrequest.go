@@ -0,0 +1,307 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package promote
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+const (
+	defaultTitle = "Promote {{ .App }} to {{ .Target }}, commit {{ .ShortCommit }}"
+	defaultBody  = "Promotes {{ .App }} to {{ .Target }}: {{ if .Previous }}{{ .Previous }}..{{ end }}{{ .ShortCommit }}\n" +
+		"{{ range .Commits }}\n* {{ .Hash }} {{ .Message }} ({{ .Author }}){{ end }}\n"
+	// maxCommits limits the number of commits listed in the pull request
+	maxCommits = 50
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// promotedCommit matches the commit message used when promoting, to find the previously promoted commit
+var promotedCommit = regexp.MustCompile(`^ci: promoting \S+ to \S+, commit (\S+)`)
+
+// pullRequest is how to open a pull/merge request through the API of a provider
+type pullRequest struct {
+	provider   string
+	api        string
+	repository string
+	token      string
+	base       string
+	title      string
+	body       string
+}
+
+// pullRequestData is available in the title and body templates
+type pullRequestData struct {
+	App         string
+	Target      string
+	Commit      string
+	ShortCommit string
+	// Previous is the previously promoted commit, empty if this is the first promotion
+	Previous string
+	// Commits are the commits since the previously promoted commit
+	Commits []commitInfo
+	Branch  string
+}
+
+type commitInfo struct {
+	Hash    string
+	Message string
+	Author  string
+}
+
+// newPullRequest determines the provider, API, repository and token for opening pull requests in the
+// repository with the given git url
+func newPullRequest(cfg *config.PullRequest, gitURL string) (*pullRequest, error) {
+	host, path, err := parseGitURL(gitURL)
+	if err != nil && (cfg.Provider == "" || cfg.API == "" || cfg.Repository == "") {
+		return nil, err
+	}
+	pr := &pullRequest{
+		provider:   cfg.Provider,
+		api:        strings.TrimSuffix(cfg.API, "/"),
+		repository: cfg.Repository,
+		token:      os.ExpandEnv(cfg.Token),
+		base:       cfg.Base,
+		title:      defaultIfEmpty(cfg.Title, defaultTitle),
+		body:       defaultIfEmpty(cfg.Body, defaultBody),
+	}
+	if pr.provider == "" {
+		switch {
+		case host == "github.com":
+			pr.provider = "github"
+		case strings.Contains(host, "gitlab"):
+			pr.provider = "gitlab"
+		case strings.Contains(host, "gitea"):
+			pr.provider = "gitea"
+		default:
+			return nil, fmt.Errorf("unable to determine pull request provider for '%s', set provider to github, gitlab or gitea", gitURL)
+		}
+	}
+	if pr.repository == "" {
+		pr.repository = strings.TrimSuffix(path, ".git")
+	}
+	var tokenEnv string
+	switch pr.provider {
+	case "github":
+		tokenEnv = "GITHUB_TOKEN"
+		if pr.api == "" {
+			if host == "github.com" {
+				pr.api = "https://api.github.com"
+			} else {
+				pr.api = fmt.Sprintf("https://%s/api/v3", host)
+			}
+		}
+	case "gitlab":
+		tokenEnv = "GITLAB_TOKEN"
+		if pr.api == "" {
+			pr.api = fmt.Sprintf("https://%s/api/v4", host)
+		}
+	case "gitea":
+		tokenEnv = "GITEA_TOKEN"
+		if pr.api == "" {
+			pr.api = fmt.Sprintf("https://%s/api/v1", host)
+		}
+	default:
+		return nil, fmt.Errorf("unknown pull request provider '%s', must be one of github, gitlab or gitea", pr.provider)
+	}
+	if pr.token == "" {
+		pr.token = os.Getenv(tokenEnv)
+	}
+	if pr.token == "" {
+		return nil, fmt.Errorf("no token for opening pull requests, set token in the configuration or %s", tokenEnv)
+	}
+	return pr, nil
+}
+
+var scpLike = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// parseGitURL returns the host and repository path of a git url, like git@github.com:org/repo.git or
+// https://github.com/org/repo.git
+func parseGitURL(gitURL string) (string, string, error) {
+	if !strings.Contains(gitURL, "://") {
+		if match := scpLike.FindStringSubmatch(gitURL); match != nil {
+			return match[1], strings.TrimPrefix(match[2], "/"), nil
+		}
+	}
+	u, err := url.Parse(gitURL)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Hostname() == "" {
+		return "", "", fmt.Errorf("unable to determine host of '%s'", gitURL)
+	}
+	return u.Hostname(), strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// open renders the title and body for data and opens a pull request from branch, returning its URL
+func (pr *pullRequest) open(branch string, data pullRequestData) (string, error) {
+	title, err := renderTemplate("title", pr.title, data)
+	if err != nil {
+		return "", err
+	}
+	body, err := renderTemplate("body", pr.body, data)
+	if err != nil {
+		return "", err
+	}
+	var endpoint string
+	var payload map[string]string
+	header := http.Header{}
+	switch pr.provider {
+	case "gitlab":
+		endpoint = fmt.Sprintf("%s/projects/%s/merge_requests", pr.api, url.PathEscape(pr.repository))
+		payload = map[string]string{"source_branch": branch, "target_branch": pr.base, "title": title, "description": body}
+		header.Set("PRIVATE-TOKEN", pr.token)
+	case "gitea":
+		endpoint = fmt.Sprintf("%s/repos/%s/pulls", pr.api, pr.repository)
+		payload = map[string]string{"head": branch, "base": pr.base, "title": title, "body": body}
+		header.Set("Authorization", "token "+pr.token)
+	default:
+		endpoint = fmt.Sprintf("%s/repos/%s/pulls", pr.api, pr.repository)
+		payload = map[string]string{"head": branch, "base": pr.base, "title": title, "body": body}
+		header.Set("Authorization", "Bearer "+pr.token)
+		header.Set("Accept", "application/vnd.github+json")
+	}
+	content, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to open pull request: %s: %s", resp.Status, strings.TrimSpace(string(response)))
+	}
+	var created struct {
+		HTMLURL string `json:"html_url"`
+		WebURL  string `json:"web_url"`
+	}
+	if err := json.Unmarshal(response, &created); err != nil {
+		return "", fmt.Errorf("failed to parse pull request response: %w", err)
+	}
+	return defaultIfEmpty(created.HTMLURL, created.WebURL), nil
+}
+
+func renderTemplate(name, text string, data pullRequestData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid pull request %s: %w", name, err)
+	}
+	out := &bytes.Buffer{}
+	if err := tmpl.Execute(out, data); err != nil {
+		return "", fmt.Errorf("invalid pull request %s: %w", name, err)
+	}
+	return out.String(), nil
+}
+
+// previousPromotion returns the commit of the last promotion of file, or directory, in repo, or an empty string if it
+// hasn't been promoted before
+func previousPromotion(repo *git.Repository, file string) (string, error) {
+	commits, err := repo.Log(&git.LogOptions{PathFilter: func(path string) bool {
+		return path == file || strings.HasPrefix(path, file+"/")
+	}})
+	if err != nil {
+		return "", err
+	}
+	defer commits.Close()
+	previous := ""
+	err = commits.ForEach(func(c *object.Commit) error {
+		if match := promotedCommit.FindStringSubmatch(c.Message); match != nil {
+			previous = match[1]
+			return errStop
+		}
+		return nil
+	})
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		log.Debugf("History of %s not available in shallow clone, unable to find previous promotion\n", file)
+		return previous, nil
+	}
+	if err != nil && !errors.Is(err, errStop) {
+		return "", err
+	}
+	return previous, nil
+}
+
+var errStop = errors.New("stop")
+
+// commitsSince lists the commits in the repository in dir from commit back to, but not including, previous
+func commitsSince(dir, commit, previous string) []commitInfo {
+	if previous == "" {
+		return nil
+	}
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		log.Debugf("Unable to open repository to list commits: %v\n", err)
+		return nil
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(commit))
+	if err != nil {
+		log.Debugf("Unable to find commit %s to list commits: %v\n", commit, err)
+		return nil
+	}
+	commits, err := repo.Log(&git.LogOptions{From: *hash})
+	if err != nil {
+		log.Debugf("Unable to list commits: %v\n", err)
+		return nil
+	}
+	defer commits.Close()
+	var result []commitInfo
+	_ = commits.ForEach(func(c *object.Commit) error {
+		if strings.HasPrefix(c.Hash.String(), previous) || len(result) == maxCommits {
+			return errStop
+		}
+		message, _, _ := strings.Cut(c.Message, "\n")
+		result = append(result, commitInfo{Hash: c.Hash.String()[:7], Message: message, Author: c.Author.Name})
+		return nil
+	})
+	return result
+}
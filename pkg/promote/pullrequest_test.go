@@ -0,0 +1,344 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package promote
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+func TestParseGitURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		wantHost string
+		wantPath string
+		wantErr  string
+	}{
+		{url: "git@github.com:org/gitops.git", wantHost: "github.com", wantPath: "org/gitops.git"},
+		{url: "gitlab.example.org:/group/sub/gitops", wantHost: "gitlab.example.org", wantPath: "group/sub/gitops"},
+		{url: "https://gitea.example.org/org/gitops.git", wantHost: "gitea.example.org", wantPath: "org/gitops.git"},
+		{url: "ssh://git@gitlab.com:2222/group/gitops.git", wantHost: "gitlab.com", wantPath: "group/gitops.git"},
+		{url: "/local/gitops", wantErr: "unable to determine host of '/local/gitops'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			host, path, err := parseGitURL(tt.url)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantHost, host)
+			assert.Equal(t, tt.wantPath, path)
+		})
+	}
+}
+
+func TestNewPullRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.PullRequest
+		url     string
+		env     map[string]string
+		want    *pullRequest
+		wantErr string
+	}{
+		{
+			name: "github",
+			url:  "git@github.com:org/gitops.git",
+			env:  map[string]string{"GITHUB_TOKEN": "gh-token"},
+			want: &pullRequest{provider: "github", api: "https://api.github.com", repository: "org/gitops", token: "gh-token", title: defaultTitle, body: defaultBody},
+		},
+		{
+			name: "github enterprise",
+			cfg:  config.PullRequest{Provider: "github", Token: "$PROMOTE_TOKEN", Base: "release"},
+			url:  "https://github.example.org/org/gitops.git",
+			env:  map[string]string{"PROMOTE_TOKEN": "promote-token", "GITHUB_TOKEN": "gh-token"},
+			want: &pullRequest{provider: "github", api: "https://github.example.org/api/v3", repository: "org/gitops", token: "promote-token", base: "release", title: defaultTitle, body: defaultBody},
+		},
+		{
+			name: "gitlab",
+			url:  "git@gitlab.example.org:group/sub/gitops.git",
+			env:  map[string]string{"GITLAB_TOKEN": "gl-token"},
+			want: &pullRequest{provider: "gitlab", api: "https://gitlab.example.org/api/v4", repository: "group/sub/gitops", token: "gl-token", title: defaultTitle, body: defaultBody},
+		},
+		{
+			name: "gitea with overrides",
+			cfg:  config.PullRequest{Provider: "gitea", API: "http://localhost:3000/api/v1/", Repository: "other/repo", Title: "title", Body: "body"},
+			url:  "git@git.example.org:org/gitops.git",
+			env:  map[string]string{"GITEA_TOKEN": "gitea-token"},
+			want: &pullRequest{provider: "gitea", api: "http://localhost:3000/api/v1", repository: "other/repo", token: "gitea-token", title: "title", body: "body"},
+		},
+		{
+			name:    "unknown host",
+			url:     "git@git.example.org:org/gitops.git",
+			wantErr: "unable to determine pull request provider for 'git@git.example.org:org/gitops.git', set provider to github, gitlab or gitea",
+		},
+		{
+			name:    "unknown provider",
+			cfg:     config.PullRequest{Provider: "bitbucket"},
+			url:     "git@bitbucket.org:org/gitops.git",
+			wantErr: "unknown pull request provider 'bitbucket', must be one of github, gitlab or gitea",
+		},
+		{
+			name:    "missing token",
+			url:     "git@gitlab.com:org/gitops.git",
+			wantErr: "no token for opening pull requests, set token in the configuration or GITLAB_TOKEN",
+		},
+		{
+			name:    "invalid url",
+			url:     "/local/gitops",
+			wantErr: "unable to determine host of '/local/gitops'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"GITHUB_TOKEN", "GITLAB_TOKEN", "GITEA_TOKEN"} {
+				t.Setenv(key, "")
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			got, err := newPullRequest(&tt.cfg, tt.url)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+type apiRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   map[string]string
+}
+
+func newAPI(t *testing.T, status int, response string) (*httptest.Server, *[]apiRequest) {
+	var requests []apiRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := io.ReadAll(r.Body)
+		request := apiRequest{method: r.Method, path: r.URL.EscapedPath(), header: r.Header}
+		_ = json.Unmarshal(content, &request.body)
+		requests = append(requests, request)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestPullRequest_Open(t *testing.T) {
+	tests := []struct {
+		provider   string
+		response   string
+		wantPath   string
+		wantHeader map[string]string
+		wantBody   map[string]string
+		wantURL    string
+	}{
+		{
+			provider:   "github",
+			response:   `{"number": 1, "html_url": "https://github.com/org/gitops/pull/1"}`,
+			wantPath:   "/repos/org/gitops/pulls",
+			wantHeader: map[string]string{"Authorization": "Bearer s3cr3t", "Accept": "application/vnd.github+json"},
+			wantBody:   map[string]string{"head": "promote/app-prod-abc1234", "base": "main", "title": "Promote app to prod", "body": "abc1234"},
+			wantURL:    "https://github.com/org/gitops/pull/1",
+		},
+		{
+			provider:   "gitlab",
+			response:   `{"iid": 1, "web_url": "https://gitlab.com/org/gitops/-/merge_requests/1"}`,
+			wantPath:   "/projects/org%2Fgitops/merge_requests",
+			wantHeader: map[string]string{"PRIVATE-TOKEN": "s3cr3t"},
+			wantBody:   map[string]string{"source_branch": "promote/app-prod-abc1234", "target_branch": "main", "title": "Promote app to prod", "description": "abc1234"},
+			wantURL:    "https://gitlab.com/org/gitops/-/merge_requests/1",
+		},
+		{
+			provider:   "gitea",
+			response:   `{"number": 1, "html_url": "https://gitea.example.org/org/gitops/pulls/1"}`,
+			wantPath:   "/repos/org/gitops/pulls",
+			wantHeader: map[string]string{"Authorization": "token s3cr3t"},
+			wantBody:   map[string]string{"head": "promote/app-prod-abc1234", "base": "main", "title": "Promote app to prod", "body": "abc1234"},
+			wantURL:    "https://gitea.example.org/org/gitops/pulls/1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			server, requests := newAPI(t, http.StatusCreated, tt.response)
+			pr := &pullRequest{provider: tt.provider, api: server.URL, repository: "org/gitops", token: "s3cr3t", base: "main", title: "Promote {{ .App }} to {{ .Target }}", body: "{{ .ShortCommit }}"}
+
+			url, err := pr.open("promote/app-prod-abc1234", pullRequestData{App: "app", Target: "prod", ShortCommit: "abc1234"})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantURL, url)
+			if assert.Len(t, *requests, 1) {
+				request := (*requests)[0]
+				assert.Equal(t, http.MethodPost, request.method)
+				assert.Equal(t, tt.wantPath, request.path)
+				assert.Equal(t, "application/json", request.header.Get("Content-Type"))
+				for k, v := range tt.wantHeader {
+					assert.Equal(t, v, request.header.Get(k))
+				}
+				assert.Equal(t, tt.wantBody, request.body)
+			}
+		})
+	}
+}
+
+func TestPullRequest_OpenErrors(t *testing.T) {
+	server, _ := newAPI(t, http.StatusUnprocessableEntity, `{"message":"A pull request already exists"}`)
+	invalid, _ := newAPI(t, http.StatusCreated, `<html>`)
+	tests := []struct {
+		name    string
+		pr      pullRequest
+		wantErr string
+	}{
+		{
+			name:    "error response",
+			pr:      pullRequest{provider: "github", api: server.URL, title: defaultTitle, body: defaultBody},
+			wantErr: `failed to open pull request: 422 Unprocessable Entity: {"message":"A pull request already exists"}`,
+		},
+		{
+			name:    "invalid response",
+			pr:      pullRequest{provider: "github", api: invalid.URL, title: defaultTitle, body: defaultBody},
+			wantErr: "failed to parse pull request response: invalid character '<' looking for beginning of value",
+		},
+		{
+			name:    "invalid title",
+			pr:      pullRequest{provider: "github", api: server.URL, title: "{{ .Missing }}", body: defaultBody},
+			wantErr: `invalid pull request title: template: title:1:3: executing "title" at <.Missing>: can't evaluate field Missing in type promote.pullRequestData`,
+		},
+		{
+			name:    "invalid body",
+			pr:      pullRequest{provider: "github", api: server.URL, title: defaultTitle, body: "{{ .App "},
+			wantErr: "invalid pull request body: template: body:1: unclosed action",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.pr.open("branch", pullRequestData{})
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func commitFile(t *testing.T, repo *git.Repository, dir, name, message string) plumbing.Hash {
+	tree, err := repo.Worktree()
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, name), []byte(message), 0o666)
+	assert.NoError(t, err)
+	_, err = tree.Add(name)
+	assert.NoError(t, err)
+	hash, err := tree.Commit(message, &git.CommitOptions{Author: &object.Signature{Name: "Some User", Email: "some.user@example.org"}})
+	assert.NoError(t, err)
+	return hash
+}
+
+func TestPromote_PullRequest(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	server, requests := newAPI(t, http.StatusCreated, `{"html_url": "https://github.com/org/gitops/pull/2"}`)
+	gitops, _ := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	dir, first := InitRepo(t, "app-repo", false)
+	defer func() { _ = os.RemoveAll(dir) }()
+	app, err := git.PlainOpen(dir)
+	assert.NoError(t, err)
+	second := commitFile(t, app, dir, "second", "Add second\n\nWith details")
+	third := commitFile(t, app, dir, "third", "Add third")
+	err = os.MkdirAll(filepath.Join(dir, "k8s"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "k8s", "deploy.yaml"), []byte("commit: ${COMMIT}\n"), 0o666)
+	assert.NoError(t, err)
+	generateSSHKey(t, filepath.Join(dir, ".ssh"))
+	key := filepath.Join(dir, ".ssh", "id_rsa")
+	cfg := config.InitEmptyConfig()
+	target := &config.Gitops{URL: gitops}
+
+	err = Promote(dir, "app", "", target, Args{Target: "prod", Tag: first.String()[:7], shortSha: first.String()[:7], PrivateKey: key}, cfg)
+	assert.NoError(t, err)
+
+	target.PullRequest = &config.PullRequest{Provider: "github", API: server.URL, Repository: "org/gitops", Token: "s3cr3t"}
+	err = Promote(dir, "app", "", target, Args{Target: "prod", Tag: third.String(), shortSha: third.String()[:7], PrivateKey: key}, cfg)
+	assert.NoError(t, err)
+
+	branch := fmt.Sprintf("promote/app-prod-%s", third.String()[:7])
+	if assert.Len(t, *requests, 1) {
+		assert.Equal(t, map[string]string{
+			"head":  branch,
+			"base":  "master",
+			"title": fmt.Sprintf("Promote app to prod, commit %s", third.String()[:7]),
+			"body": fmt.Sprintf("Promotes app to prod: %s..%s\n\n* %s Add third (Some User)\n* %s Add second (Some User)\n",
+				first.String()[:7], third.String()[:7], third.String()[:7], second.String()[:7]),
+		}, (*requests)[0].body)
+	}
+	repo, err := git.PlainOpen(gitops)
+	assert.NoError(t, err)
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	assert.NoError(t, err)
+	commit, err := repo.CommitObject(ref.Hash())
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("ci: promoting app to prod, commit %s", third.String()[:7]), commit.Message)
+	commits := GetCommits(t, gitops)
+	assert.Equal(t, 2, len(commits))
+	assert.Equal(t, fmt.Sprintf("ci: promoting app to prod, commit %s", first.String()[:7]), commits[0].Message)
+	CheckLogged(t, []string{
+		"info: generating...\n",
+		"^info: pushing commit [0-9a-f]+ to .*git-repo.*/app\n$",
+		"info: generating...\n",
+		fmt.Sprintf("^info: pushing commit [0-9a-f]+ to branch %s of .*git-repo.*/app\n$", branch),
+		"info: Opened pull request <green>https://github.com/org/gitops/pull/2</green>\n",
+	}, logMock.Logged)
+}
+
+func TestPromote_PullRequestConfigError(t *testing.T) {
+	log.SetHandler(mocks.New())
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "k8s"), 0o777)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "k8s", "deploy.yaml"), []byte("commit: ${COMMIT}\n"), 0o666)
+	assert.NoError(t, err)
+	target := &config.Gitops{URL: "git@git.example.org:org/gitops.git", PullRequest: &config.PullRequest{}}
+
+	err = Promote(dir, "app", "", target, Args{Target: "prod", Tag: "abc123"}, config.InitEmptyConfig())
+	assert.EqualError(t, err, "unable to determine pull request provider for 'git@git.example.org:org/gitops.git', set provider to github, gitlab or gitea")
+}
@@ -0,0 +1,232 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package promote
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+const (
+	defaultPushAttempts = 5
+	defaultPushBackoff  = time.Second
+	maxPushBackoff      = 30 * time.Second
+)
+
+// ErrPushConflict is returned (wrapped in a PushError) when a push is rejected since the branch has been
+// updated by someone else
+var ErrPushConflict = errors.New("branch updated concurrently")
+
+// sleep waits between push attempts, replaced in tests
+var sleep = time.Sleep
+
+// PushError is returned when pushing to the gitops repository fails
+type PushError struct {
+	Err error
+}
+
+func (e *PushError) Error() string {
+	return fmt.Sprintf("git push error: %v", e.Err)
+}
+
+func (e *PushError) Unwrap() error {
+	return e.Err
+}
+
+// change is the generated files to commit on base and push using refSpec. The path is the generated file, or
+// the directory in which the files replace everything if split.
+type change struct {
+	base        string
+	refSpec     gitconfig.RefSpec
+	path        string
+	files       map[string][]byte
+	split       bool
+	message     string
+	destination string
+}
+
+// publish commits the change and pushes it. If the push is rejected since the branch has been updated
+// concurrently, the new remote head is fetched, the worktree reset onto it and the change committed and
+// pushed again, up to the configured number of attempts with exponential backoff in between.
+func publish(repo *git.Repository, auth transport.AuthMethod, c change, gitConfig config.Git) (plumbing.Hash, error) {
+	attempts, backoff, err := pushPolicy(gitConfig)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	for attempt := 1; ; attempt++ {
+		hash, err := commitChange(worktree, c, gitConfig)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		log.Infof("pushing commit %s to %s\n", hash, c.destination)
+		err = push(repo, auth, c.refSpec)
+		if err == nil {
+			return hash, nil
+		}
+		if !errors.Is(err, ErrPushConflict) || attempt >= attempts {
+			return plumbing.ZeroHash, err
+		}
+		wait := retryWait(backoff, attempt)
+		log.Infof("<yellow>push rejected since %s has been updated, retrying (attempt %d of %d)</yellow>\n", c.base, attempt+1, attempts)
+		log.Debugf("Waiting %s before retrying\n", wait)
+		sleep(wait)
+		if err := resetToRemote(repo, worktree, auth, c.base, 1); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+}
+
+// pushPolicy returns the number of push attempts and the backoff before the first retry
+func pushPolicy(gitConfig config.Git) (int, time.Duration, error) {
+	attempts := gitConfig.PushAttempts
+	if attempts <= 0 {
+		attempts = defaultPushAttempts
+	}
+	backoff := defaultPushBackoff
+	if gitConfig.PushBackoff != "" {
+		var err error
+		if backoff, err = time.ParseDuration(gitConfig.PushBackoff); err != nil {
+			return 0, 0, fmt.Errorf("invalid push backoff '%s': %w", gitConfig.PushBackoff, err)
+		}
+	}
+	return attempts, backoff, nil
+}
+
+// retryWait is the time to wait after the given failed attempt, doubling the backoff for each attempt and adding
+// up to 50% jitter so that concurrent promotes don't retry in lockstep
+func retryWait(backoff time.Duration, attempt int) time.Duration {
+	wait := min(backoff<<(attempt-1), maxPushBackoff)
+	return wait + rand.N(wait/2+1)
+}
+
+func commitChange(worktree *git.Worktree, c change, gitConfig config.Git) (plumbing.Hash, error) {
+	root := worktree.Filesystem.Root()
+	if c.split {
+		if err := os.RemoveAll(filepath.Join(root, c.path)); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if err := os.MkdirAll(filepath.Join(root, c.path), 0o777); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	for file, content := range c.files {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if err := os.WriteFile(path, content, 0o666); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if _, err := worktree.Add(file); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	if c.split {
+		// stages the removal of files for objects no longer generated
+		if _, err := worktree.Add(c.path); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	return worktree.Commit(c.message, &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  defaultIfEmpty(gitConfig.Name, "Buildtools"),
+			Email: defaultIfEmpty(gitConfig.Email, "git@buildtools.io"),
+			When:  time.Now(),
+		},
+	})
+}
+
+func push(repo *git.Repository, auth transport.AuthMethod, refSpec gitconfig.RefSpec) error {
+	err := repo.Push(&git.PushOptions{
+		Auth:     auth,
+		RefSpecs: []gitconfig.RefSpec{refSpec},
+	})
+	if err == nil {
+		return nil
+	}
+	if conflict(err) {
+		err = fmt.Errorf("%w: %v", ErrPushConflict, err)
+	}
+	return &PushError{Err: err}
+}
+
+// conflict reports whether a push was rejected since the remote branch has moved, either detected by go-git
+// before pushing or reported by the server, which is only available as text
+func conflict(err error) bool {
+	if errors.Is(err, git.ErrNonFastForwardUpdate) {
+		return true
+	}
+	for _, reason := range []string{"non-fast-forward", "fetch first", "cannot lock ref", "failed to update ref"} {
+		if strings.Contains(err.Error(), reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// resetToRemote fetches the current head of branch, with the given depth (0 for all history), and resets the
+// worktree onto it
+func resetToRemote(repo *git.Repository, worktree *git.Worktree, auth transport.AuthMethod, branch string, depth int) error {
+	hash, err := fetchHead(repo, auth, branch, depth)
+	if err != nil {
+		return err
+	}
+	return worktree.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset})
+}
+
+// fetchHead fetches the current head of branch into its remote-tracking branch and returns it
+func fetchHead(repo *git.Repository, auth transport.AuthMethod, branch string, depth int) (plumbing.Hash, error) {
+	remote := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branch)
+	err := repo.Fetch(&git.FetchOptions{
+		Auth:     auth,
+		RefSpecs: []gitconfig.RefSpec{gitconfig.RefSpec(fmt.Sprintf("+%s:%s", plumbing.NewBranchReferenceName(branch), remote))},
+		Depth:    depth,
+		Force:    true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return plumbing.ZeroHash, fmt.Errorf("git fetch error: %w", err)
+	}
+	ref, err := repo.Reference(remote, true)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return ref.Hash(), nil
+}
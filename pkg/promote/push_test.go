@@ -0,0 +1,220 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package promote
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/config"
+)
+
+func cloneRepo(t *testing.T, url string) *git.Repository {
+	repo, err := git.PlainClone(t.TempDir(), false, &git.CloneOptions{URL: url})
+	assert.NoError(t, err)
+	return repo
+}
+
+// pushCompeting pushes a commit to the repository at url, as another promote running concurrently would
+func pushCompeting(t *testing.T, url, file string) {
+	repo := cloneRepo(t, url)
+	tree, err := repo.Worktree()
+	assert.NoError(t, err)
+	commitFile(t, repo, tree.Filesystem.Root(), file, fmt.Sprintf("Add %s", file))
+	assert.NoError(t, repo.Push(&git.PushOptions{}))
+}
+
+func stubSleep(t *testing.T, fn func(time.Duration)) *[]time.Duration {
+	var waits []time.Duration
+	sleep = func(d time.Duration) {
+		waits = append(waits, d)
+		if fn != nil {
+			fn(d)
+		}
+	}
+	t.Cleanup(func() { sleep = time.Sleep })
+	return &waits
+}
+
+func testChange(content string) change {
+	return change{
+		base:        "master",
+		refSpec:     gitconfig.RefSpec("refs/heads/master:refs/heads/master"),
+		path:        "app/deploy.yaml",
+		files:       map[string][]byte{"app/deploy.yaml": []byte(content)},
+		message:     "ci: promoting app to prod, commit abc123",
+		destination: "gitops/app",
+	}
+}
+
+func TestPublish(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	gitops, _ := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	waits := stubSleep(t, nil)
+
+	hash, err := publish(cloneRepo(t, gitops), nil, testChange("commit: abc123\n"), config.Git{})
+
+	assert.NoError(t, err)
+	assert.Empty(t, *waits)
+	commits := GetCommits(t, gitops)
+	assert.Equal(t, 2, len(commits))
+	assert.Equal(t, hash, commits[0].Hash)
+	assert.Equal(t, []string{fmt.Sprintf("info: pushing commit %s to gitops/app\n", hash)}, logMock.Logged)
+}
+
+func TestPublish_RetriesOnConflict(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	gitops, _ := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	repo := cloneRepo(t, gitops)
+	pushCompeting(t, gitops, "other")
+	waits := stubSleep(t, nil)
+
+	hash, err := publish(repo, nil, testChange("commit: abc123\n"), config.Git{PushBackoff: "10ms"})
+
+	assert.NoError(t, err)
+	if assert.Len(t, *waits, 1) {
+		assert.GreaterOrEqual(t, (*waits)[0], 10*time.Millisecond)
+		assert.LessOrEqual(t, (*waits)[0], 15*time.Millisecond)
+	}
+	commits := GetCommits(t, gitops)
+	assert.Equal(t, 3, len(commits))
+	assert.Equal(t, hash, commits[0].Hash)
+	assert.Equal(t, "ci: promoting app to prod, commit abc123", commits[0].Message)
+	assert.Equal(t, "Add other", commits[1].Message)
+	files, err := commits[0].Files()
+	assert.NoError(t, err)
+	var names []string
+	_ = files.ForEach(func(f *object.File) error {
+		names = append(names, f.Name)
+		return nil
+	})
+	assert.ElementsMatch(t, []string{"app/deploy.yaml", "file", "other"}, names)
+	CheckLogged(t, []string{
+		"^info: pushing commit [0-9a-f]+ to gitops/app\n$",
+		"info: <yellow>push rejected since master has been updated, retrying \\(attempt 2 of 5\\)</yellow>\n",
+		fmt.Sprintf("info: pushing commit %s to gitops/app\n", hash),
+	}, logMock.Logged)
+}
+
+func TestPublish_GivesUp(t *testing.T) {
+	log.SetHandler(mocks.New())
+	gitops, _ := InitRepo(t, "git-repo", true)
+	defer func() { _ = os.RemoveAll(gitops) }()
+	repo := cloneRepo(t, gitops)
+	pushCompeting(t, gitops, "other")
+	waits := stubSleep(t, nil)
+
+	_, err := publish(repo, nil, testChange("commit: abc123\n"), config.Git{PushAttempts: 1})
+
+	assert.ErrorIs(t, err, ErrPushConflict)
+	var pushErr *PushError
+	assert.True(t, errors.As(err, &pushErr))
+	assert.EqualError(t, err, "git push error: branch updated concurrently: non-fast-forward update: refs/heads/master")
+	assert.Empty(t, *waits)
+	assert.Equal(t, 2, len(GetCommits(t, gitops)))
+}
+
+func TestPublish_OtherPushError(t *testing.T) {
+	log.SetHandler(mocks.New())
+	gitops, _ := InitRepo(t, "git-repo", true)
+	repo := cloneRepo(t, gitops)
+	assert.NoError(t, os.RemoveAll(gitops))
+	waits := stubSleep(t, nil)
+
+	_, err := publish(repo, nil, testChange("commit: abc123\n"), config.Git{})
+
+	assert.EqualError(t, err, "git push error: repository not found")
+	assert.NotErrorIs(t, err, ErrPushConflict)
+	assert.Empty(t, *waits)
+}
+
+func TestPublish_InvalidBackoff(t *testing.T) {
+	_, err := publish(nil, nil, testChange(""), config.Git{PushBackoff: "soon"})
+	assert.EqualError(t, err, `invalid push backoff 'soon': time: invalid duration "soon"`)
+}
+
+func TestPushPolicy(t *testing.T) {
+	attempts, backoff, err := pushPolicy(config.Git{})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, attempts)
+	assert.Equal(t, time.Second, backoff)
+
+	attempts, backoff, err = pushPolicy(config.Git{PushAttempts: 1, PushBackoff: "250ms"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, 250*time.Millisecond, backoff)
+}
+
+func TestRetryWait(t *testing.T) {
+	tests := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{attempt: 1, min: time.Second, max: 1500 * time.Millisecond},
+		{attempt: 2, min: 2 * time.Second, max: 3 * time.Second},
+		{attempt: 3, min: 4 * time.Second, max: 6 * time.Second},
+		{attempt: 10, min: maxPushBackoff, max: maxPushBackoff * 3 / 2},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("attempt %d", tt.attempt), func(t *testing.T) {
+			wait := retryWait(time.Second, tt.attempt)
+			assert.GreaterOrEqual(t, wait, tt.min)
+			assert.LessOrEqual(t, wait, tt.max)
+		})
+	}
+}
+
+func TestConflict(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: git.ErrNonFastForwardUpdate, want: true},
+		{err: fmt.Errorf("wrapped: %w", git.ErrNonFastForwardUpdate), want: true},
+		{err: errors.New("command error on refs/heads/master: cannot lock ref 'refs/heads/master'"), want: true},
+		{err: errors.New("command error on refs/heads/main: failed to update ref"), want: true},
+		{err: errors.New("! [rejected] main -> main (fetch first)"), want: true},
+		{err: errors.New("authentication required"), want: false},
+		{err: errors.New("repository not found"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			assert.Equal(t, tt.want, conflict(tt.err))
+		})
+	}
+}
@@ -452,6 +452,10 @@ func (m mockRegistry) PushImage(client docker.Client, auth, image string) (strin
 	panic("implement me")
 }
 
+func (m mockRegistry) Resolve(image string) (string, error) {
+	panic("implement me")
+}
+
 var _ registry.Registry = &mockRegistry{}
 
 type no struct {
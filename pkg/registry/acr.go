@@ -67,7 +67,8 @@ func (r *ACR) Name() string {
 	return "ACR"
 }
 
-func (r *ACR) Login(client docker.Client) error {
+// authorize exchanges an Azure token for a refresh token for the registry
+func (r *ACR) authorize() error {
 	token, err := r.credential.GetToken(context.Background(), policy.TokenRequestOptions{
 		Scopes: []string{"https://management.azure.com/.default"},
 	})
@@ -90,7 +91,14 @@ func (r *ACR) Login(client docker.Client) error {
 		return err
 	}
 	r.token = response["refresh_token"].(string)
-	_, err = client.RegistryLogin(context.Background(), toLoginOptions(registry.AuthConfig{
+	return nil
+}
+
+func (r *ACR) Login(client docker.Client) error {
+	if err := r.authorize(); err != nil {
+		return err
+	}
+	_, err := client.RegistryLogin(context.Background(), toLoginOptions(registry.AuthConfig{
 		Username:      "00000000-0000-0000-0000-000000000000",
 		Password:      r.token,
 		ServerAddress: r.Url,
@@ -115,6 +123,13 @@ func (r *ACR) GetAuthInfo() string {
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
+func (r *ACR) Resolve(image string) (string, error) {
+	if err := r.authorize(); err != nil {
+		return "", err
+	}
+	return resolve(r.GetAuthConfig(), r.Name(), image)
+}
+
 func (r *ACR) RegistryUrl() string {
 	return r.Url
 }
@@ -144,6 +144,17 @@ func TestAcr_LoginSuccess(t *testing.T) {
 	logMock.Check(t, []string{"debug: Logged in\n"})
 }
 
+func TestAcr_ResolveTokenRequestFailed(t *testing.T) {
+	registry := &ACR{Url: "ecr-url", credential: &MockCredential{
+		getToken: func(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+			return azcore.AccessToken{}, fmt.Errorf("auth failure")
+		},
+	}}
+	digest, err := registry.Resolve("ecr-url/image:abc123")
+	assert.EqualError(t, err, "auth failure")
+	assert.Empty(t, digest)
+}
+
 func TestAcr_GetAuthInfo(t *testing.T) {
 	registry := &ACR{Url: "ecr-url", token: "aaabbb"}
 	auth := registry.GetAuthInfo()
@@ -69,6 +69,10 @@ func (r Dockerhub) GetAuthInfo() string {
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
+func (r Dockerhub) Resolve(image string) (string, error) {
+	return resolve(r.GetAuthConfig(), r.Name(), image)
+}
+
 func (r Dockerhub) RegistryUrl() string {
 	return r.Namespace
 }
@@ -107,6 +107,19 @@ func (r *ECR) registry() (*string, error) {
 }
 
 func (r *ECR) Login(client docker.Client) error {
+	if err := r.authorize(); err != nil {
+		return err
+	}
+	if _, err := client.RegistryLogin(context.Background(), toLoginOptions(registry.AuthConfig{Username: r.username, Password: r.password, ServerAddress: r.Url})); err == nil {
+		log.Debugf("Logged in\n")
+		return nil
+	} else {
+		return err
+	}
+}
+
+// authorize fetches a username and password for the registry from ECR
+func (r *ECR) authorize() error {
 	input := &ecr.GetAuthorizationTokenInput{}
 
 	result, err := r.ecrSvc.GetAuthorizationToken(context.Background(), input)
@@ -121,13 +134,7 @@ func (r *ECR) Login(client docker.Client) error {
 	parts := strings.Split(string(decoded), ":")
 	r.username = parts[0]
 	r.password = parts[1]
-
-	if _, err := client.RegistryLogin(context.Background(), toLoginOptions(registry.AuthConfig{Username: r.username, Password: r.password, ServerAddress: r.Url})); err == nil {
-		log.Debugf("Logged in\n")
-		return nil
-	} else {
-		return err
-	}
+	return nil
 }
 
 func (r *ECR) GetAuthConfig() registry.AuthConfig {
@@ -139,6 +146,13 @@ func (r *ECR) GetAuthInfo() string {
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
+func (r *ECR) Resolve(image string) (string, error) {
+	if err := r.authorize(); err != nil {
+		return "", err
+	}
+	return resolve(r.GetAuthConfig(), r.Name(), image)
+}
+
 func (r ECR) RegistryUrl() string {
 	return r.Url
 }
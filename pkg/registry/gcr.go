@@ -76,6 +76,10 @@ func (r *GCR) GetAuthInfo() string {
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
+func (r *GCR) Resolve(image string) (string, error) {
+	return resolve(r.GetAuthConfig(), r.Name(), image)
+}
+
 func (r GCR) RegistryUrl() string {
 	return r.Url
 }
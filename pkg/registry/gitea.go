@@ -86,6 +86,10 @@ func (r Gitea) GetAuthInfo() string {
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
+func (r Gitea) Resolve(image string) (string, error) {
+	return resolve(r.GetAuthConfig(), r.Name(), image)
+}
+
 func (r Gitea) RegistryUrl() string {
 	if len(r.Repository) != 0 {
 		if strings.Contains(r.Repository, "/") {
@@ -77,6 +77,10 @@ func (r Github) GetAuthInfo() string {
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
+func (r Github) Resolve(image string) (string, error) {
+	return resolve(r.GetAuthConfig(), r.Name(), image)
+}
+
 func (r Github) RegistryUrl() string {
 	return fmt.Sprintf("ghcr.io/%s", r.Repository)
 }
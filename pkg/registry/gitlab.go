@@ -70,6 +70,10 @@ func (r Gitlab) GetAuthInfo() string {
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
+func (r Gitlab) Resolve(image string) (string, error) {
+	return resolve(r.GetAuthConfig(), r.Name(), image)
+}
+
 func (r Gitlab) RegistryUrl() string {
 	if len(r.Repository) != 0 {
 		if strings.Contains(r.Repository, "/") {
@@ -66,4 +66,8 @@ func (n NoDockerRegistry) PushImage(client docker.Client, auth, image string) (s
 	return "", fmt.Errorf("push not supported by registry")
 }
 
+func (n NoDockerRegistry) Resolve(image string) (string, error) {
+	return "", fmt.Errorf("resolve not supported by registry")
+}
+
 var _ Registry = &NoDockerRegistry{}
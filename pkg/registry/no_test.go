@@ -31,3 +31,9 @@ import (
 func Test_NoDockerRegistry_Name(t *testing.T) {
 	assert.Equal(t, true, NoDockerRegistry{}.Configured())
 }
+
+func Test_NoDockerRegistry_Resolve(t *testing.T) {
+	digest, err := NoDockerRegistry{}.Resolve("noregistry/image:abc123")
+	assert.EqualError(t, err, "resolve not supported by registry")
+	assert.Empty(t, digest)
+}
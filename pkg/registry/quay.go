@@ -69,6 +69,10 @@ func (r Quay) GetAuthInfo() string {
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
+func (r Quay) Resolve(image string) (string, error) {
+	return resolve(r.GetAuthConfig(), r.Name(), image)
+}
+
 func (r Quay) RegistryUrl() string {
 	return fmt.Sprintf("quay.io/%s", r.Repository)
 }
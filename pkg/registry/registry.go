@@ -48,6 +48,7 @@ type Registry interface {
 	RegistryUrl() string
 	Create(repository string) error
 	PushImage(client docker.Client, auth, image string) (string, error)
+	Resolve(image string) (string, error)
 }
 
 type responsetype struct {
@@ -0,0 +1,167 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	authutil "github.com/containerd/containerd/v2/core/remotes/docker/auth"
+	"github.com/distribution/reference"
+	"github.com/moby/moby/api/types/registry"
+)
+
+// httpClient is used for requests to the registry HTTP API
+var httpClient = http.DefaultClient
+
+// manifestTypes are the media types accepted when resolving a manifest, with image indexes first so that the
+// digest of multi-platform images is the digest of the index
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ErrImageNotFound is returned when resolving an image which doesn't exist in the registry
+var ErrImageNotFound = errors.New("image not found")
+
+// resolve resolves the manifest digest of image (i.e. registry/name:tag) through the registry HTTP API, using
+// auth as credentials. name is the name of the registry, used in errors.
+func resolve(auth registry.AuthConfig, name, image string) (string, error) {
+	ref, err := reference.ParseDockerRef(image)
+	if err != nil {
+		return "", fmt.Errorf("invalid image '%s': %w", image, err)
+	}
+	if digested, ok := ref.(reference.Digested); ok {
+		return digested.Digest().String(), nil
+	}
+	host := reference.Domain(ref)
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	url := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, reference.Path(ref), ref.(reference.Tagged).Tag())
+	m := &manifest{url: url, auth: auth}
+	resp, err := m.request(http.MethodHead)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", fmt.Errorf("%w: '%s' doesn't exist in %s, make sure that it has been built and pushed", ErrImageNotFound, image, name)
+	default:
+		return "", fmt.Errorf("failed to resolve digest for '%s': %s", image, resp.Status)
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	// Not all registries return the digest for HEAD requests, in which case it's calculated from the manifest
+	resp, err = m.request(http.MethodGet)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to resolve digest for '%s': %s", image, resp.Status)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
+// Pin returns image (i.e. registry/name:tag) referenced by its manifest digest in r, i.e. registry/name@sha256:...
+func Pin(r Registry, image string) (string, error) {
+	digest, err := r.Resolve(image)
+	if err != nil {
+		return "", err
+	}
+	name := image
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name = image[:i]
+	}
+	return fmt.Sprintf("%s@%s", name, digest), nil
+}
+
+// manifest requests a manifest, authorizing with the challenge returned by the registry when needed
+type manifest struct {
+	url           string
+	auth          registry.AuthConfig
+	authorization string
+}
+
+func (m *manifest) request(method string) (*http.Response, error) {
+	resp, err := m.do(method)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || m.authorization != "" {
+		return resp, err
+	}
+	_ = resp.Body.Close()
+	if m.authorization, err = m.authorize(resp.Header); err != nil {
+		return nil, err
+	}
+	return m.do(method)
+}
+
+func (m *manifest) do(method string) (*http.Response, error) {
+	req, err := http.NewRequest(method, m.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if m.authorization != "" {
+		req.Header.Set("Authorization", m.authorization)
+	}
+	return httpClient.Do(req)
+}
+
+// authorize returns the Authorization header value for the challenges in header
+func (m *manifest) authorize(header http.Header) (string, error) {
+	for _, challenge := range authutil.ParseAuthHeader(header) {
+		switch challenge.Scheme {
+		case authutil.BearerAuth:
+			to, err := authutil.GenerateTokenOptions(context.Background(), m.url, m.auth.Username, m.auth.Password, challenge)
+			if err != nil {
+				return "", err
+			}
+			token, err := authutil.FetchToken(context.Background(), httpClient, nil, to)
+			if err != nil {
+				return "", fmt.Errorf("failed to fetch token: %w", err)
+			}
+			return "Bearer " + token.Token, nil
+		case authutil.BasicAuth:
+			if m.auth.Username != "" {
+				return "Basic " + base64.StdEncoding.EncodeToString([]byte(m.auth.Username+":"+m.auth.Password)), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unauthorized to access %s", m.url)
+}
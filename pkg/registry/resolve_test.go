@@ -0,0 +1,231 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testManifest = `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`
+
+// testRegistry is a registry serving the image app/image:v1, requiring auth with user/secret using either
+// the bearer or basic scheme
+func testRegistry(t *testing.T, scheme string, headDigest bool) (*httptest.Server, string) {
+	t.Helper()
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(testManifest)))
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if r.Header.Get("Authorization") != basic {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			assert.Equal(t, "registry", r.URL.Query().Get("service"))
+			assert.Equal(t, "repository:app/image:pull", r.URL.Query().Get("scope"))
+			_, _ = w.Write([]byte(`{"token":"abc"}`))
+			return
+		case scheme == "bearer" && r.Header.Get("Authorization") != "Bearer abc":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:app/image:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case scheme == "basic" && r.Header.Get("Authorization") != basic:
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Contains(t, r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json")
+		switch r.URL.Path {
+		case "/v2/app/image/manifests/v1":
+			if headDigest {
+				w.Header().Set("Docker-Content-Digest", digest)
+			}
+			if r.Method == http.MethodGet {
+				_, _ = w.Write([]byte(testManifest))
+			}
+		case "/v2/app/broken/manifests/v1":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	httpClient = server.Client()
+	t.Cleanup(func() { httpClient = http.DefaultClient })
+	return server, strings.TrimPrefix(server.URL, "https://")
+}
+
+func TestResolve(t *testing.T) {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(testManifest)))
+	tests := []struct {
+		name       string
+		scheme     string
+		headDigest bool
+		registry   Registry
+		image      string
+		want       string
+		wantErr    string
+	}{
+		{
+			name:       "bearer token",
+			scheme:     "bearer",
+			headDigest: true,
+			registry:   &Gitlab{User: "user", Token: "secret"},
+			image:      "<host>/app/image:v1",
+			want:       digest,
+		},
+		{
+			name:       "basic auth",
+			scheme:     "basic",
+			headDigest: true,
+			registry:   &Gitlab{User: "user", Token: "secret"},
+			image:      "<host>/app/image:v1",
+			want:       digest,
+		},
+		{
+			name:     "no digest header",
+			registry: &Gitlab{},
+			image:    "<host>/app/image:v1",
+			want:     digest,
+		},
+		{
+			name:       "registry with credentials from authorize",
+			scheme:     "bearer",
+			headDigest: true,
+			registry:   &ECR{ecrSvc: &MockECR{authData: base64.StdEncoding.EncodeToString([]byte("user:secret"))}},
+			image:      "<host>/app/image:v1",
+			want:       digest,
+		},
+		{
+			name:       "docker hub",
+			scheme:     "bearer",
+			headDigest: true,
+			registry:   &Dockerhub{Username: "user", Password: "secret"},
+			image:      "<host>/app/image:v1",
+			want:       digest,
+		},
+		{
+			name:       "wrong basic auth credentials",
+			scheme:     "basic",
+			headDigest: true,
+			registry:   &GCR{KeyFileContent: base64.StdEncoding.EncodeToString([]byte("secret"))},
+			image:      "<host>/app/image:v1",
+			wantErr:    "failed to resolve digest for '<host>/app/image:v1': 401 Unauthorized",
+		},
+		{
+			name:     "authorize fails",
+			registry: &ECR{ecrSvc: &MockECR{loginError: errors.New("auth failure")}},
+			image:    "<host>/app/image:v1",
+			wantErr:  "auth failure",
+		},
+		{
+			name:     "not found",
+			registry: &Gitlab{},
+			image:    "<host>/app/image:v2",
+			wantErr:  "image not found: '<host>/app/image:v2' doesn't exist in Gitlab, make sure that it has been built and pushed",
+		},
+		{
+			name:     "registry error",
+			registry: &Gitlab{},
+			image:    "<host>/app/broken:v1",
+			wantErr:  "failed to resolve digest for '<host>/app/broken:v1': 500 Internal Server Error",
+		},
+		{
+			name:     "invalid credentials",
+			scheme:   "bearer",
+			registry: &Gitlab{User: "user", Token: "wrong"},
+			image:    "<host>/app/image:v1",
+			wantErr:  "failed to fetch token: unexpected status",
+		},
+		{
+			name:     "basic auth without credentials",
+			scheme:   "basic",
+			registry: &Gitlab{},
+			image:    "<host>/app/image:v1",
+			wantErr:  "unauthorized to access https://<host>/v2/app/image/manifests/v1",
+		},
+		{
+			name:     "already pinned",
+			registry: &Gitlab{},
+			image:    "<host>/app/image@sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7",
+			want:     "sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7",
+		},
+		{
+			name:     "invalid image",
+			registry: &Gitlab{},
+			image:    "<host>/App:v1",
+			wantErr:  "invalid image '<host>/App:v1': invalid reference format: repository name (App) must be lowercase",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, host := testRegistry(t, tt.scheme, tt.headDigest)
+			image := strings.ReplaceAll(tt.image, "<host>", host)
+
+			got, err := tt.registry.Resolve(image)
+
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, strings.ReplaceAll(tt.wantErr, "<host>", host))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResolve_NotFound(t *testing.T) {
+	_, host := testRegistry(t, "", false)
+
+	_, err := (&Gitlab{}).Resolve(host + "/app/missing:v1")
+
+	assert.ErrorIs(t, err, ErrImageNotFound)
+}
+
+func TestPin(t *testing.T) {
+	_, host := testRegistry(t, "bearer", true)
+
+	got, err := Pin(&Gitlab{User: "user", Token: "secret"}, host+"/app/image:v1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s/app/image@sha256:%x", host, sha256.Sum256([]byte(testManifest))), got)
+}
+
+func TestPin_Error(t *testing.T) {
+	_, host := testRegistry(t, "", false)
+
+	got, err := Pin(&Gitlab{}, host+"/app/image:v2")
+
+	assert.ErrorIs(t, err, ErrImageNotFound)
+	assert.Empty(t, got)
+}
@@ -0,0 +1,246 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package render
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/buildtool/build-tools/pkg/file"
+	"github.com/buildtool/build-tools/pkg/helm"
+	"github.com/buildtool/build-tools/pkg/kubectl"
+	"github.com/buildtool/build-tools/pkg/kustomize"
+	"github.com/buildtool/build-tools/pkg/sops"
+	"github.com/buildtool/build-tools/pkg/templating"
+)
+
+// Options controls how the deployment descriptors are rendered
+type Options struct {
+	// Name is the name of the application, used as release name for charts and name prefix for kustomizations
+	Name string
+	// Namespace is the namespace of the release when rendering a chart
+	Namespace string
+	// Chart is the path of the Helm chart relative to the project directory, defaults to k8s
+	Chart string
+	// Templating renders plain descriptors and kustomizations as Go templates
+	Templating bool
+	// KeepEncrypted keeps SOPS encrypted descriptors as is instead of decrypting them
+	KeepEncrypted bool
+	// Labels are added to all objects
+	Labels map[string]string
+	// Data is the values available when rendering
+	Data templating.Data
+}
+
+// Descriptors are the rendered deployment descriptors of a project
+type Descriptors struct {
+	// Dir is the directory the descriptors were rendered from
+	Dir string
+	// Contents are the rendered descriptors, one for each file, kustomization or chart. The resources of a
+	// kustomization are added one by one if any of them are kept encrypted with SOPS
+	Contents []string
+	// Scripts are the scripts for the target found next to plain descriptors
+	Scripts []os.DirEntry
+	// Decrypted is set if any of the descriptors were decrypted with SOPS and must not be logged
+	Decrypted bool
+}
+
+// Render renders the deployment descriptors of the project in dir, either from a Helm chart, from a
+// kustomization or from the plain descriptor files in the k8s directory
+func Render(dir string, opts Options) (*Descriptors, error) {
+	deploymentFiles := filepath.Join(dir, "k8s")
+	chart := deploymentFiles
+	if opts.Chart != "" {
+		chart = filepath.Join(dir, opts.Chart)
+	}
+	if helm.IsChart(chart) {
+		return renderChart(chart, opts)
+	}
+	return renderDir(deploymentFiles, opts)
+}
+
+func renderChart(dir string, opts Options) (*Descriptors, error) {
+	release := helm.Release{Name: opts.Name, Namespace: opts.Namespace, Target: opts.Data.Target, Image: opts.Data.Image}
+	content, err := helm.Render(dir, release)
+	if err != nil {
+		return nil, err
+	}
+	d := &Descriptors{Dir: dir}
+	// The chart has already been rendered, only replace placeholders and variables
+	if err := d.add(filepath.Base(dir), content, opts, false); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func renderDir(dir string, opts Options) (*Descriptors, error) {
+	var files []os.DirEntry
+	kustomization, useKustomize := kustomize.Find(dir, opts.Data.Target)
+	if !useKustomize {
+		var err error
+		if files, err = file.FindFilesForTarget(dir, opts.Data.Target); err != nil {
+			return nil, err
+		}
+	}
+	scripts, err := file.FindScriptsForTarget(dir, opts.Data.Target)
+	if err != nil {
+		return nil, err
+	}
+	d := &Descriptors{Dir: dir, Scripts: scripts}
+	if useKustomize {
+		var read kustomize.ReadFunc
+		if !opts.KeepEncrypted {
+			read = d.decryptResource
+		}
+		content, err := kustomize.Render(kustomization, opts.Name, opts.Data.Image, read)
+		if err != nil && opts.Templating {
+			return nil, fmt.Errorf("%w, Go templates are executed after kustomize so template actions in kustomize sources must be quoted strings", err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := d.addKustomized(filepath.Base(kustomization), content, opts); err != nil {
+			return nil, err
+		}
+	}
+	for _, info := range files {
+		content, err := os.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		descriptor := string(content)
+		if sops.IsEncrypted(descriptor) {
+			err = d.addEncrypted(info.Name(), descriptor, opts)
+		} else {
+			err = d.add(info.Name(), descriptor, opts, opts.Templating)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// decryptResource decrypts the file at path if it's encrypted with SOPS, before kustomize transforms it and
+// invalidates the SOPS message authentication code
+func (d *Descriptors) decryptResource(path string, content []byte) ([]byte, error) {
+	if !sops.IsEncrypted(string(content)) {
+		return content, nil
+	}
+	name, err := filepath.Rel(d.Dir, path)
+	if err != nil {
+		name = path
+	}
+	log.Debugf("Decrypting '<green>%s</green>'\n", name)
+	decrypted, err := sops.Decrypt(name, string(content))
+	if err != nil {
+		return nil, err
+	}
+	d.Decrypted = true
+	return []byte(decrypted), nil
+}
+
+// addKustomized adds the output of the kustomization name. If any of the resources are kept encrypted with SOPS,
+// each resource is added separately so that the encrypted ones can be handled in the same way as encrypted files
+func (d *Descriptors) addKustomized(name, content string, opts Options) error {
+	if !sops.IsEncrypted(content) {
+		return d.add(name, content, opts, opts.Templating)
+	}
+	reader := yaml.NewYAMLReader(bufio.NewReader(strings.NewReader(content)))
+	for {
+		doc, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		descriptor := string(doc)
+		if !sops.IsEncrypted(descriptor) {
+			if err := d.add(name, descriptor, opts, opts.Templating); err != nil {
+				return err
+			}
+			continue
+		}
+		objects, err := kubectl.Objects(descriptor)
+		if err != nil {
+			return err
+		}
+		if err := d.addEncrypted(fmt.Sprintf("%s in kustomization %s", objects[0], name), descriptor, opts); err != nil {
+			return err
+		}
+	}
+}
+
+// addEncrypted adds the SOPS encrypted descriptor name, decrypted unless it should be kept encrypted
+func (d *Descriptors) addEncrypted(name, descriptor string, opts Options) error {
+	if opts.KeepEncrypted {
+		// Templating would invalidate the SOPS message authentication code, so the descriptor is kept as is
+		log.Debugf("Keeping '<green>%s</green>' encrypted\n", name)
+		d.Contents = append(d.Contents, descriptor)
+		return nil
+	}
+	log.Debugf("Decrypting '<green>%s</green>'\n", name)
+	decrypted, err := sops.Decrypt(name, descriptor)
+	if err != nil {
+		return err
+	}
+	d.Decrypted = true
+	return d.add(name, decrypted, opts, opts.Templating)
+}
+
+// add renders the descriptor file name and adds the result to the contents, empty files are ignored
+func (d *Descriptors) add(name, content string, opts Options, goTemplates bool) error {
+	if len(strings.TrimSpace(content)) == 0 {
+		log.Debugf("ignoring empty file '<yellow>%s</yellow>'\n", name)
+		return nil
+	}
+	kubeContent, err := templating.Render(name, content, opts.Data, goTemplates)
+	if err != nil {
+		return err
+	}
+	if len(opts.Labels) > 0 {
+		if kubeContent, err = kubectl.Label(kubeContent, opts.Labels); err != nil {
+			return err
+		}
+	}
+	d.Contents = append(d.Contents, kubeContent)
+	return nil
+}
+
+// Content returns all descriptors as a single yaml stream
+func (d *Descriptors) Content() string {
+	docs := make([]kubectl.Document, len(d.Contents))
+	for i, content := range d.Contents {
+		docs[i] = kubectl.Document{Content: content}
+	}
+	return kubectl.Join(docs)
+}
@@ -0,0 +1,189 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/templating"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o777))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o777))
+	}
+}
+
+var data = templating.Data{
+	Commit:    "abc123",
+	Image:     "registry/app:abc123",
+	Target:    "prod",
+	Variables: map[string]string{"REPLICAS": "3"},
+}
+
+func TestRender_Files(t *testing.T) {
+	log.SetHandler(mocks.New())
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"k8s/deploy.yaml":      "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\ndata:\n  replicas: \"${REPLICAS}\"\n  target: {{ .Target }}\n",
+		"k8s/empty.yaml":       "\n",
+		"k8s/other-test.yaml":  "kind: ConfigMap\n",
+		"k8s/setup-prod.sh":    "#!/bin/sh\n",
+		"k8s/setup-staging.sh": "#!/bin/sh\n",
+	})
+
+	d, err := Render(dir, Options{Name: "app", Templating: true, Labels: map[string]string{"owner": "app"}, Data: data})
+
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "k8s"), d.Dir)
+	assert.Equal(t, []string{"apiVersion: v1\ndata:\n  replicas: \"3\"\n  target: prod\nkind: ConfigMap\nmetadata:\n  labels:\n    owner: app\n  name: app\n"}, d.Contents)
+	if assert.Len(t, d.Scripts, 1) {
+		assert.Equal(t, "setup-prod.sh", d.Scripts[0].Name())
+	}
+	assert.False(t, d.Decrypted)
+}
+
+func TestRender_Kustomize(t *testing.T) {
+	log.SetHandler(mocks.New())
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"k8s/base/kustomization.yaml":          "resources:\n  - deployment.yaml\n",
+		"k8s/base/deployment.yaml":             "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\nspec:\n  template:\n    spec:\n      containers:\n        - name: app\n          image: app\n",
+		"k8s/overlays/prod/kustomization.yaml": "resources:\n  - ../../base\nlabels:\n  - pairs:\n      target: \"{{ .Target }}\"\n",
+	})
+
+	d, err := Render(dir, Options{Name: "app", Templating: true, Data: data})
+
+	assert.NoError(t, err)
+	if assert.Len(t, d.Contents, 1) {
+		assert.Contains(t, d.Contents[0], "target: 'prod'")
+		assert.Contains(t, d.Contents[0], "image: registry/app:abc123")
+	}
+}
+
+func TestRender_Chart(t *testing.T) {
+	log.SetHandler(mocks.New())
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"deploy/chart/Chart.yaml":               "apiVersion: v2\nname: app\nversion: 0.1.0\n",
+		"deploy/chart/templates/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n  namespace: {{ .Release.Namespace }}\ndata:\n  commit: ${COMMIT}\n",
+	})
+
+	d, err := Render(dir, Options{Name: "app", Namespace: "apps", Chart: "deploy/chart", Templating: true, Data: data})
+
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "deploy", "chart"), d.Dir)
+	assert.Equal(t, []string{"---\n# Source: app/templates/configmap.yaml\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n  namespace: apps\ndata:\n  commit: abc123\n"}, d.Contents)
+}
+
+func TestRender_Encrypted(t *testing.T) {
+	log.SetHandler(mocks.New())
+	t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join("..", "sops", "testdata", "age-key.txt"))
+	encrypted, err := os.ReadFile(filepath.Join("..", "sops", "testdata", "secret.enc.yaml"))
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"k8s/secret.yaml": string(encrypted)})
+
+	d, err := Render(dir, Options{Name: "app", Data: data})
+	assert.NoError(t, err)
+	assert.True(t, d.Decrypted)
+	assert.Equal(t, []string{"apiVersion: v1\nkind: Secret\nmetadata:\n    name: db\nstringData:\n    password: s3cr3t\n"}, d.Contents)
+
+	d, err = Render(dir, Options{Name: "app", KeepEncrypted: true, Data: data})
+	assert.NoError(t, err)
+	assert.False(t, d.Decrypted)
+	assert.Equal(t, []string{string(encrypted)}, d.Contents)
+}
+
+func TestRender_KustomizeEncrypted(t *testing.T) {
+	log.SetHandler(mocks.New())
+	t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join("..", "sops", "testdata", "age-key.txt"))
+	encrypted, err := os.ReadFile(filepath.Join("..", "sops", "testdata", "secret.enc.yaml"))
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"k8s/kustomization.yaml": "resources:\n  - configmap.yaml\n  - secret.yaml\nnamespace: prod\nnamePrefix: prod-\n",
+		"k8s/configmap.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\ndata:\n  target: \"{{ .Target }}\"\n",
+		"k8s/secret.yaml":        string(encrypted),
+	})
+
+	d, err := Render(dir, Options{Name: "app", Templating: true, Data: data})
+	assert.NoError(t, err)
+	assert.True(t, d.Decrypted)
+	assert.Equal(t, []string{"apiVersion: v1\ndata:\n  target: 'prod'\nkind: ConfigMap\nmetadata:\n  name: prod-app\n  namespace: prod\n---\napiVersion: v1\nkind: Secret\nmetadata:\n  name: prod-db\n  namespace: prod\nstringData:\n  password: s3cr3t\n"}, d.Contents)
+
+	d, err = Render(dir, Options{Name: "app", KeepEncrypted: true, Data: data})
+	assert.NoError(t, err)
+	assert.False(t, d.Decrypted)
+	if assert.Len(t, d.Contents, 2) {
+		assert.Contains(t, d.Contents[1], "password: ENC[AES256_GCM,")
+	}
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join(dir, "missing.txt"))
+	t.Setenv("GNUPGHOME", t.TempDir())
+	_, err = Render(dir, Options{Name: "app", Data: data})
+	assert.ErrorContains(t, err, "failed to decrypt 'secret.yaml': ")
+}
+
+func TestRender_Errors(t *testing.T) {
+	log.SetHandler(mocks.New())
+	dir := t.TempDir()
+	_, err := Render(dir, Options{Data: data})
+	assert.EqualError(t, err, "open "+filepath.Join(dir, "k8s")+": no such file or directory")
+
+	writeFiles(t, dir, map[string]string{"k8s/deploy.yaml": "value: {{ .Missing }}"})
+	_, err = Render(dir, Options{Templating: true, Data: data})
+	assert.EqualError(t, err, `template: deploy.yaml:1:10: executing "deploy.yaml" at <.Missing>: can't evaluate field Missing in type templating.Data`)
+}
+
+func TestRender_KustomizeUnquotedTemplate(t *testing.T) {
+	log.SetHandler(mocks.New())
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"k8s/kustomization.yaml": "resources:\n  - deployment.yaml\n",
+		"k8s/deployment.yaml":    "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\nspec:\n  replicas: {{ .Variables.REPLICAS }}\n",
+	})
+
+	_, err := Render(dir, Options{Name: "app", Templating: true, Data: data})
+	assert.ErrorContains(t, err, "yaml: invalid map key")
+	assert.ErrorContains(t, err, ", Go templates are executed after kustomize so template actions in kustomize sources must be quoted strings")
+
+	_, err = Render(dir, Options{Name: "app", Data: data})
+	assert.ErrorContains(t, err, "yaml: invalid map key")
+	assert.NotContains(t, err.Error(), "Go templates")
+}
+
+func TestDescriptors_Content(t *testing.T) {
+	d := &Descriptors{Contents: []string{"kind: A", "kind: B\n"}}
+	assert.Equal(t, "kind: A\n---\nkind: B\n", d.Content())
+}
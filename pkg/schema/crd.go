@@ -0,0 +1,150 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+// customResources are the schemas of the versions of CustomResourceDefinitions
+type customResources struct {
+	schemas map[string]*spec.Schema
+	kinds   map[schema.GroupVersionKind]bool
+}
+
+// load adds the CustomResourceDefinitions in all yaml and json files in dir
+func (c *customResources) load(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		objects, err := Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("failed to read schemas from '%s': %w", path, err)
+		}
+		for _, obj := range objects {
+			if !isCRD(obj) {
+				continue
+			}
+			log.Debugf("Using schema for <green>%s</green> from '%s'\n", obj.GetName(), path)
+			if err := c.add(obj); err != nil {
+				return fmt.Errorf("failed to read schemas from '%s': %w", path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// add adds the schemas of all versions of a CustomResourceDefinition
+func (c *customResources) add(crd *unstructured.Unstructured) error {
+	if c.schemas == nil {
+		c.schemas = map[string]*spec.Schema{}
+		c.kinds = map[schema.GroupVersionKind]bool{}
+	}
+	group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+	kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(version, "name")
+		openAPI, found, _ := unstructured.NestedMap(version, "schema", "openAPIV3Schema")
+		if !found {
+			continue
+		}
+		s, err := toSchema(openAPI)
+		if err != nil {
+			return fmt.Errorf("invalid schema for %s/%s %s: %w", group, name, kind, err)
+		}
+		gvk := schema.GroupVersionKind{Group: group, Version: name, Kind: kind}
+		s.AddExtension("x-kubernetes-group-version-kind", []interface{}{
+			map[string]interface{}{"group": group, "version": name, "kind": kind},
+		})
+		c.schemas[fmt.Sprintf("%s.%s.%s", group, name, kind)] = s
+		c.kinds[gvk] = true
+	}
+	return nil
+}
+
+// toSchema converts an openAPIV3Schema of a CustomResourceDefinition, adding the fields which are common to all
+// objects since they aren't part of the schema
+func toSchema(openAPI map[string]interface{}) (*spec.Schema, error) {
+	content, err := json.Marshal(openAPI)
+	if err != nil {
+		return nil, err
+	}
+	s := &spec.Schema{}
+	if err := json.Unmarshal(content, s); err != nil {
+		return nil, err
+	}
+	if s.Properties == nil {
+		s.Properties = map[string]spec.Schema{}
+	}
+	metadata := spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"object"}}}
+	metadata.AddExtension("x-kubernetes-preserve-unknown-fields", true)
+	s.Properties["apiVersion"] = *spec.StringProperty()
+	s.Properties["kind"] = *spec.StringProperty()
+	s.Properties["metadata"] = metadata
+	return s, nil
+}
+
+func (c *customResources) has(gvk schema.GroupVersionKind) bool {
+	return c.kinds[gvk]
+}
+
+func (c *customResources) copy() *customResources {
+	if c.schemas == nil {
+		return &customResources{}
+	}
+	return &customResources{schemas: maps.Clone(c.schemas), kinds: maps.Clone(c.kinds)}
+}
+
+func (c *customResources) converter() (managedfields.TypeConverter, error) {
+	if len(c.schemas) == 0 {
+		return nil, nil
+	}
+	return managedfields.NewTypeConverter(c.schemas, false)
+}
@@ -0,0 +1,212 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package schema
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/applyconfigurations"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/kubectl"
+	"github.com/buildtool/build-tools/pkg/sops"
+)
+
+// Validator validates Kubernetes objects offline, against the schemas of the built-in types bundled with
+// build-tools and the schemas of CustomResourceDefinitions
+type Validator struct {
+	// minor is the minor version of Kubernetes to validate against, 0 if any version is accepted
+	minor   int
+	builtin managedfields.TypeConverter
+	crds    *customResources
+}
+
+// Problem is a validation failure for an object
+type Problem struct {
+	Object  kubectl.Object
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Object, p.Message)
+}
+
+// Error is returned by Validate when any of the objects are invalid
+type Error struct {
+	Problems []Problem
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = fmt.Sprintf("  %s", problem)
+	}
+	return fmt.Sprintf("validation failed:\n%s", strings.Join(messages, "\n"))
+}
+
+// New creates a Validator which reports built-in apiVersions that aren't served by the Kubernetes version
+// apiVersionsFor (i.e. 1.29, not checked if empty), using the bundled schemas for built-in kinds and the
+// CustomResourceDefinitions found in the yaml and json files in schemaDir (if set) for custom resources.
+// The fields of built-in kinds are validated against the schemas of SchemaVersion regardless of apiVersionsFor
+func New(apiVersionsFor, schemaDir string) (*Validator, error) {
+	minor, err := parseVersion(apiVersionsFor)
+	if err != nil {
+		return nil, err
+	}
+	bundled, _ := parseVersion(SchemaVersion)
+	if minor > bundled {
+		return nil, fmt.Errorf("unable to check apiVersions for Kubernetes %s, the bundled schemas are from Kubernetes %s", apiVersionsFor, SchemaVersion)
+	}
+	if minor > 0 && minor < bundled {
+		log.Debugf("Checking apiVersions for Kubernetes 1.%d, fields are validated against the schemas of Kubernetes %s\n", minor, SchemaVersion)
+	}
+	crds := &customResources{}
+	if schemaDir != "" {
+		if err := crds.load(schemaDir); err != nil {
+			return nil, err
+		}
+	}
+	return &Validator{
+		minor:   minor,
+		builtin: applyconfigurations.NewTypeConverter(scheme.Scheme),
+		crds:    crds,
+	}, nil
+}
+
+// NewFromConfig creates a Validator from the validation configuration of the project in dir
+func NewFromConfig(dir string, cfg *config.ValidationConfig) (*Validator, error) {
+	schemas := cfg.Schemas
+	if schemas != "" && !filepath.IsAbs(schemas) {
+		schemas = filepath.Join(dir, schemas)
+	}
+	return New(cfg.APIVersionsFor, schemas)
+}
+
+// Validate validates all objects in the yaml documents in content. CustomResourceDefinitions in content are used
+// to validate custom resources in content. SOPS encrypted documents can't be validated and are ignored.
+func (v *Validator) Validate(content string) error {
+	objects, err := Parse(content)
+	if err != nil {
+		return err
+	}
+	crds := v.crds.copy()
+	for _, obj := range objects {
+		if isCRD(obj) {
+			if err := crds.add(obj); err != nil {
+				return err
+			}
+		}
+	}
+	converter, err := crds.converter()
+	if err != nil {
+		return err
+	}
+	var problems []Problem
+	for _, obj := range objects {
+		if message := v.validate(obj, converter, crds); message != "" {
+			problems = append(problems, Problem{Object: kubectl.Object{Kind: obj.GetKind(), Name: obj.GetName(), Namespace: obj.GetNamespace()}, Message: message})
+		}
+	}
+	if len(problems) > 0 {
+		return &Error{Problems: problems}
+	}
+	return nil
+}
+
+func (v *Validator) validate(obj *unstructured.Unstructured, crdConverter managedfields.TypeConverter, crds *customResources) string {
+	if obj.GetAPIVersion() == "" || obj.GetKind() == "" {
+		return "apiVersion and kind are required"
+	}
+	gvk := obj.GroupVersionKind()
+	if message := v.available(gvk); message != "" {
+		return message
+	}
+	var err error
+	switch {
+	case scheme.Scheme.Recognizes(gvk):
+		_, err = v.builtin.ObjectToTyped(obj)
+	case crds.has(gvk):
+		_, err = crdConverter.ObjectToTyped(obj)
+	case scheme.Scheme.IsGroupRegistered(gvk.Group):
+		return fmt.Sprintf("unknown kind %s in %s", gvk.Kind, gvk.GroupVersion())
+	default:
+		log.Warnf("No schema found for <yellow>%s</yellow> (%s), skipping validation\n", obj.GetName(), gvk.GroupVersion().WithKind(gvk.Kind))
+		return ""
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// Parse finds all objects in the yaml documents in content, expanding Lists. SOPS encrypted documents are ignored.
+func Parse(content string) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	reader := yaml.NewYAMLReader(bufio.NewReader(strings.NewReader(content)))
+	for i := 1; ; i++ {
+		doc, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, nil
+			}
+			return nil, err
+		}
+		if sops.IsEncrypted(string(doc)) {
+			log.Debugf("Not validating SOPS encrypted document %d\n", i)
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(doc, &obj.Object); err != nil {
+			return nil, fmt.Errorf("document %d is not valid yaml: %w", i, err)
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.IsList() {
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, err
+			}
+			for j := range list.Items {
+				objects = append(objects, &list.Items[j])
+			}
+			continue
+		}
+		objects = append(objects, obj)
+	}
+}
+
+func isCRD(obj *unstructured.Unstructured) bool {
+	return obj.GroupVersionKind() == schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}
+}
@@ -0,0 +1,270 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package schema
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/kubectl"
+)
+
+const deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: app
+  template:
+    metadata:
+      labels:
+        app: app
+    spec:
+      containers:
+        - name: app
+          image: registry/app:abc123
+          ports:
+            - containerPort: 8080
+`
+
+const widgetCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+    plural: widgets
+  scope: Namespaced
+  versions:
+    - name: v1
+      served: true
+      storage: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              properties:
+                size:
+                  type: integer
+`
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		content string
+		want    []Problem
+	}{
+		{
+			name:    "valid",
+			content: deployment + "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\ndata:\n  key: value\n",
+		},
+		{
+			name:    "unknown field",
+			content: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\nspec:\n  replica: 2\n",
+			want:    []Problem{{Object: kubectl.Object{Kind: "Deployment", Name: "app"}, Message: ".spec.replica: field not declared in schema"}},
+		},
+		{
+			name:    "wrong type",
+			content: "apiVersion: v1\nkind: Service\nmetadata:\n  name: app\n  namespace: apps\nspec:\n  ports:\n    - port: http\n",
+			want:    []Problem{{Object: kubectl.Object{Kind: "Service", Name: "app", Namespace: "apps"}, Message: ".spec.ports[port=\"http\",protocol=\"TCP\"].port: expected numeric (int or float), got string"}},
+		},
+		{
+			name:    "missing kind",
+			content: "apiVersion: v1\nmetadata:\n  name: app\n",
+			want:    []Problem{{Object: kubectl.Object{Name: "app"}, Message: "apiVersion and kind are required"}},
+		},
+		{
+			name:    "unknown built-in kind",
+			content: "apiVersion: apps/v1\nkind: Deploymnet\nmetadata:\n  name: app\n",
+			want:    []Problem{{Object: kubectl.Object{Kind: "Deploymnet", Name: "app"}, Message: "unknown kind Deploymnet in apps/v1"}},
+		},
+		{
+			name:    "removed api",
+			version: "1.25",
+			content: "apiVersion: policy/v1beta1\nkind: PodDisruptionBudget\nmetadata:\n  name: app\n",
+			want:    []Problem{{Object: kubectl.Object{Kind: "PodDisruptionBudget", Name: "app"}, Message: "policy/v1beta1 PodDisruptionBudget was removed in Kubernetes 1.25, use policy/v1 instead"}},
+		},
+		{
+			name:    "removed api without replacement",
+			version: "v1.30.2",
+			content: "apiVersion: policy/v1beta1\nkind: PodSecurityPolicy\nmetadata:\n  name: app\n",
+			want:    []Problem{{Object: kubectl.Object{Kind: "PodSecurityPolicy", Name: "app"}, Message: "policy/v1beta1 PodSecurityPolicy was removed in Kubernetes 1.25"}},
+		},
+		{
+			name:    "removed api in older version",
+			version: "1.24",
+			content: "apiVersion: policy/v1beta1\nkind: PodDisruptionBudget\nmetadata:\n  name: app\n",
+		},
+		{
+			name:    "api not yet available",
+			version: "1.22",
+			content: "apiVersion: autoscaling/v2\nkind: HorizontalPodAutoscaler\nmetadata:\n  name: app\n",
+			want:    []Problem{{Object: kubectl.Object{Kind: "HorizontalPodAutoscaler", Name: "app"}, Message: "autoscaling/v2 HorizontalPodAutoscaler is not available before Kubernetes 1.23"}},
+		},
+		{
+			name:    "list",
+			content: "apiVersion: v1\nkind: List\nitems:\n  - apiVersion: v1\n    kind: ConfigMap\n    metadata:\n      name: config\n    data:\n      key: 1\n",
+			want:    []Problem{{Object: kubectl.Object{Kind: "ConfigMap", Name: "config"}, Message: ".data.key: expected string, got &value.valueUnstructured{Value:1}"}},
+		},
+		{
+			name:    "custom resource defined in content",
+			content: widgetCRD + "---\napiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: small\nspec:\n  size: 1\n---\napiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: large\nspec:\n  sise: 10\n",
+			want:    []Problem{{Object: kubectl.Object{Kind: "Widget", Name: "large"}, Message: ".spec.sise: field not declared in schema"}},
+		},
+		{
+			name:    "custom resource from schema directory",
+			content: "apiVersion: cert-manager.io/v1\nkind: Certificate\nmetadata:\n  name: tls\n  labels:\n    app: app\nspec:\n  secretName: tls\n  dnsNames: example.org\n",
+			want:    []Problem{{Object: kubectl.Object{Kind: "Certificate", Name: "tls"}, Message: ".spec.dnsNames: expected list, got &{example.org}"}},
+		},
+		{
+			name:    "empty documents",
+			content: "---\n\n---\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator, err := New(tt.version, "testdata/crds")
+			assert.NoError(t, err)
+			err = validator.Validate(tt.content)
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var validationErr *Error
+			if assert.ErrorAs(t, err, &validationErr) {
+				assert.Equal(t, tt.want, validationErr.Problems)
+			}
+		})
+	}
+}
+
+func TestValidate_NoSchema(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	validator, err := New("", "")
+	assert.NoError(t, err)
+
+	err = validator.Validate("apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: small\n")
+
+	assert.NoError(t, err)
+	logMock.Check(t, []string{
+		"warn: No schema found for <yellow>small</yellow> (example.com/v1, Kind=Widget), skipping validation\n",
+	})
+}
+
+func TestValidate_Encrypted(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	validator, err := New("", "")
+	assert.NoError(t, err)
+
+	err = validator.Validate("apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\nstringData:\n  password: ENC[AES256_GCM,data:AmjAJwJL]\nsops:\n  mac: ENC[AES256_GCM,data:Pt99WSsf]\n")
+
+	assert.NoError(t, err)
+	logMock.Check(t, []string{"debug: Not validating SOPS encrypted document 1\n"})
+}
+
+func TestValidate_InvalidYAML(t *testing.T) {
+	validator, err := New("", "")
+	assert.NoError(t, err)
+
+	err = validator.Validate("kind: ConfigMap\n---\nkind: [ConfigMap\n")
+
+	assert.ErrorContains(t, err, "document 2 is not valid yaml: ")
+}
+
+func TestError(t *testing.T) {
+	err := &Error{Problems: []Problem{
+		{Object: kubectl.Object{Kind: "Deployment", Name: "app"}, Message: ".spec.replica: field not declared in schema"},
+		{Object: kubectl.Object{Kind: "Service", Name: "app"}, Message: "unknown kind Service in apps/v1"},
+	}}
+
+	assert.EqualError(t, err, "validation failed:\n  deployment/app: .spec.replica: field not declared in schema\n  service/app: unknown kind Service in apps/v1")
+}
+
+func TestNew_InvalidVersion(t *testing.T) {
+	for _, version := range []string{"2.1", "1", "1.x", "latest"} {
+		_, err := New(version, "")
+		assert.EqualError(t, err, "invalid kubernetes version '"+version+"', must be like 1.29")
+	}
+}
+
+func TestNew_NewerVersion(t *testing.T) {
+	_, err := New("1.37", "")
+
+	assert.EqualError(t, err, "unable to check apiVersions for Kubernetes 1.37, the bundled schemas are from Kubernetes 1.36")
+}
+
+func TestNew_OlderVersion(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	defer log.SetLevel(log.InfoLevel)
+
+	_, err := New("v1.29.3", "")
+
+	assert.NoError(t, err)
+	logMock.Check(t, []string{"debug: Checking apiVersions for Kubernetes 1.29, fields are validated against the schemas of Kubernetes 1.36\n"})
+}
+
+func TestSchemaVersion(t *testing.T) {
+	// the bundled schemas are those of client-go, whose minor version follows Kubernetes
+	goMod, err := os.ReadFile(filepath.Join("..", "..", "go.mod"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(goMod), "\tk8s.io/client-go v0."+strings.TrimPrefix(SchemaVersion, "1.")+".")
+}
+
+func TestNew_MissingSchemaDir(t *testing.T) {
+	_, err := New("", "testdata/missing")
+
+	assert.EqualError(t, err, "lstat testdata/missing: no such file or directory")
+}
+
+func TestNew_InvalidSchemaFile(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, writeFile(dir, "broken.yaml", "kind: [CustomResourceDefinition\n"))
+
+	_, err := New("", dir)
+
+	assert.ErrorContains(t, err, "failed to read schemas from '"+dir+"/broken.yaml': document 1 is not valid yaml: ")
+}
+
+func writeFile(dir, name, content string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(content), 0o666)
+}
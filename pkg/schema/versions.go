@@ -0,0 +1,128 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// SchemaVersion is the version of Kubernetes the bundled schemas of the built-in kinds are from, i.e. the
+// version of client-go build-tools is built with. Fields are only validated against these schemas, there are
+// no schemas for other versions
+const SchemaVersion = "1.36"
+
+// api is a version of a built-in kind which is only served by some versions of Kubernetes
+type api struct {
+	// introduced is the minor version in which the api was first served by default, 0 if it's older than 1.16
+	introduced int
+	// removed is the minor version in which the api is no longer served, 0 if it's still served
+	removed     int
+	replacement string
+}
+
+var apis = map[schema.GroupVersionKind]api{}
+
+func init() {
+	removed := func(minor int, replacement, groupVersion string, kinds ...string) {
+		for _, kind := range kinds {
+			apis[schema.FromAPIVersionAndKind(groupVersion, kind)] = api{removed: minor, replacement: replacement}
+		}
+	}
+	introduced := func(minor int, groupVersion string, kinds ...string) {
+		for _, kind := range kinds {
+			apis[schema.FromAPIVersionAndKind(groupVersion, kind)] = api{introduced: minor}
+		}
+	}
+	removed(16, "apps/v1", "extensions/v1beta1", "Deployment", "DaemonSet", "ReplicaSet")
+	removed(16, "networking.k8s.io/v1", "extensions/v1beta1", "NetworkPolicy")
+	removed(16, "apps/v1", "apps/v1beta1", "Deployment", "StatefulSet")
+	removed(16, "apps/v1", "apps/v1beta2", "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet")
+	removed(22, "networking.k8s.io/v1", "extensions/v1beta1", "Ingress")
+	removed(22, "networking.k8s.io/v1", "networking.k8s.io/v1beta1", "Ingress", "IngressClass")
+	removed(22, "admissionregistration.k8s.io/v1", "admissionregistration.k8s.io/v1beta1", "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration")
+	removed(22, "apiextensions.k8s.io/v1", "apiextensions.k8s.io/v1beta1", "CustomResourceDefinition")
+	removed(22, "rbac.authorization.k8s.io/v1", "rbac.authorization.k8s.io/v1beta1", "ClusterRole", "ClusterRoleBinding", "Role", "RoleBinding")
+	removed(22, "scheduling.k8s.io/v1", "scheduling.k8s.io/v1beta1", "PriorityClass")
+	removed(22, "storage.k8s.io/v1", "storage.k8s.io/v1beta1", "StorageClass", "CSIDriver", "CSINode", "VolumeAttachment")
+	removed(22, "coordination.k8s.io/v1", "coordination.k8s.io/v1beta1", "Lease")
+	removed(22, "certificates.k8s.io/v1", "certificates.k8s.io/v1beta1", "CertificateSigningRequest")
+	removed(25, "batch/v1", "batch/v1beta1", "CronJob")
+	removed(25, "discovery.k8s.io/v1", "discovery.k8s.io/v1beta1", "EndpointSlice")
+	removed(25, "events.k8s.io/v1", "events.k8s.io/v1beta1", "Event")
+	removed(25, "autoscaling/v2", "autoscaling/v2beta1", "HorizontalPodAutoscaler")
+	removed(25, "policy/v1", "policy/v1beta1", "PodDisruptionBudget")
+	removed(25, "", "policy/v1beta1", "PodSecurityPolicy")
+	removed(25, "node.k8s.io/v1", "node.k8s.io/v1beta1", "RuntimeClass")
+	removed(26, "autoscaling/v2", "autoscaling/v2beta2", "HorizontalPodAutoscaler")
+	removed(26, "flowcontrol.apiserver.k8s.io/v1", "flowcontrol.apiserver.k8s.io/v1beta1", "FlowSchema", "PriorityLevelConfiguration")
+	removed(27, "storage.k8s.io/v1", "storage.k8s.io/v1beta1", "CSIStorageCapacity")
+	removed(29, "flowcontrol.apiserver.k8s.io/v1", "flowcontrol.apiserver.k8s.io/v1beta2", "FlowSchema", "PriorityLevelConfiguration")
+	removed(32, "flowcontrol.apiserver.k8s.io/v1", "flowcontrol.apiserver.k8s.io/v1beta3", "FlowSchema", "PriorityLevelConfiguration")
+	introduced(19, "networking.k8s.io/v1", "Ingress", "IngressClass")
+	introduced(20, "node.k8s.io/v1", "RuntimeClass")
+	introduced(21, "batch/v1", "CronJob")
+	introduced(21, "discovery.k8s.io/v1", "EndpointSlice")
+	introduced(21, "policy/v1", "PodDisruptionBudget")
+	introduced(23, "autoscaling/v2", "HorizontalPodAutoscaler")
+	introduced(24, "storage.k8s.io/v1", "CSIStorageCapacity")
+	introduced(29, "flowcontrol.apiserver.k8s.io/v1", "FlowSchema", "PriorityLevelConfiguration")
+	introduced(30, "admissionregistration.k8s.io/v1", "ValidatingAdmissionPolicy", "ValidatingAdmissionPolicyBinding")
+}
+
+// parseVersion returns the minor version of a Kubernetes version like 1.29, v1.29 or 1.29.3, 0 if version is empty
+func parseVersion(version string) (int, error) {
+	if version == "" {
+		return 0, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 || parts[0] != "1" {
+		return 0, fmt.Errorf("invalid kubernetes version '%s', must be like 1.29", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid kubernetes version '%s', must be like 1.29", version)
+	}
+	return minor, nil
+}
+
+// available returns why gvk isn't served by the Kubernetes version of the validator, if it isn't
+func (v *Validator) available(gvk schema.GroupVersionKind) string {
+	a, found := apis[gvk]
+	if !found || v.minor == 0 {
+		return ""
+	}
+	apiVersion := gvk.GroupVersion().String()
+	switch {
+	case a.removed > 0 && v.minor >= a.removed && a.replacement != "":
+		return fmt.Sprintf("%s %s was removed in Kubernetes 1.%d, use %s instead", apiVersion, gvk.Kind, a.removed, a.replacement)
+	case a.removed > 0 && v.minor >= a.removed:
+		return fmt.Sprintf("%s %s was removed in Kubernetes 1.%d", apiVersion, gvk.Kind, a.removed)
+	case v.minor < a.introduced:
+		return fmt.Sprintf("%s %s is not available before Kubernetes 1.%d", apiVersion, gvk.Kind, a.introduced)
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sops
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/getsops/sops/v3/decrypt"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// IsEncrypted returns true if any of the yaml documents in content has been encrypted with SOPS
+func IsEncrypted(content string) bool {
+	reader := yaml.NewYAMLReader(bufio.NewReader(strings.NewReader(content)))
+	for {
+		doc, err := reader.Read()
+		if err != nil {
+			return false
+		}
+		var fields map[string]interface{}
+		if err := yaml.Unmarshal(doc, &fields); err != nil {
+			continue
+		}
+		if metadata, ok := fields["sops"].(map[string]interface{}); ok && metadata["mac"] != nil {
+			return true
+		}
+	}
+}
+
+// Decrypt decrypts the SOPS encrypted yaml file name in memory. Keys are found in the same way as by the sops
+// command, i.e. age keys from SOPS_AGE_KEY or SOPS_AGE_KEY_FILE and PGP keys from the GnuPG keyring.
+func Decrypt(name, content string) (string, error) {
+	out, err := decrypt.Data([]byte(content), "yaml")
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt '%s': %w", name, err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,96 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sops
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsEncrypted(t *testing.T) {
+	encrypted, err := os.ReadFile("testdata/secret.enc.yaml")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{name: "encrypted", content: string(encrypted), want: true},
+		{name: "encrypted in second document", content: "kind: ConfigMap\n---\n" + string(encrypted), want: true},
+		{name: "plain", content: "apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\n", want: false},
+		{name: "sops field without mac", content: "kind: Config\nsops:\n  enabled: true\n", want: false},
+		{name: "not yaml", content: "#!/bin/sh\necho", want: false},
+		{name: "empty", content: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsEncrypted(tt.content))
+		})
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY_FILE", "testdata/age-key.txt")
+	encrypted, err := os.ReadFile("testdata/secret.enc.yaml")
+	assert.NoError(t, err)
+
+	decrypted, err := Decrypt("secret.yaml", string(encrypted))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "apiVersion: v1\nkind: Secret\nmetadata:\n    name: db\nstringData:\n    password: s3cr3t\n", decrypted)
+}
+
+func TestDecrypt_KeyFromEnv(t *testing.T) {
+	key, err := os.ReadFile("testdata/age-key.txt")
+	assert.NoError(t, err)
+	t.Setenv("SOPS_AGE_KEY", string(key))
+	encrypted, err := os.ReadFile("testdata/secret.enc.yaml")
+	assert.NoError(t, err)
+
+	decrypted, err := Decrypt("secret.yaml", string(encrypted))
+
+	assert.NoError(t, err)
+	assert.Contains(t, decrypted, "password: s3cr3t")
+}
+
+func TestDecrypt_MissingKey(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("SOPS_AGE_KEY_FILE", "testdata/missing.txt")
+	t.Setenv("GNUPGHOME", t.TempDir())
+	encrypted, err := os.ReadFile("testdata/secret.enc.yaml")
+	assert.NoError(t, err)
+
+	_, err = Decrypt("secret.yaml", string(encrypted))
+
+	assert.ErrorContains(t, err, "failed to decrypt 'secret.yaml': ")
+}
+
+func TestDecrypt_Invalid(t *testing.T) {
+	_, err := Decrypt("config.yaml", "kind: ConfigMap\n")
+
+	assert.EqualError(t, err, "failed to decrypt 'config.yaml': sops metadata not found")
+}
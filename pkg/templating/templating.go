@@ -0,0 +1,227 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package templating
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Data contains the values available when rendering deployment descriptors
+type Data struct {
+	Commit    string
+	Timestamp string
+	Image     string
+	Target    string
+	Variables map[string]string
+}
+
+// Render executes content as a Go template with data and the functions from Funcs if goTemplates is set,
+// and then replaces the ${COMMIT}, ${TIMESTAMP} and ${IMAGE} placeholders as well as a ${KEY} placeholder
+// for each of the variables. Placeholders are replaced afterwards so that values are never parsed as
+// template code.
+func Render(name, content string, data Data, goTemplates bool) (string, error) {
+	if !goTemplates {
+		return replacer(data).Replace(content), nil
+	}
+	tpl, err := template.New(name).Funcs(Funcs()).Parse(content)
+	if err != nil {
+		return "", err
+	}
+	buff := &bytes.Buffer{}
+	if err := tpl.Execute(buff, data); err != nil {
+		return "", err
+	}
+	return replacer(data).Replace(buff.String()), nil
+}
+
+// replacer creates a strings.Replacer for the placeholders, COMMIT, TIMESTAMP and IMAGE
+// take precedence over variables with the same name
+func replacer(data Data) *strings.Replacer {
+	pairs := []string{"${COMMIT}", data.Commit, "${TIMESTAMP}", data.Timestamp, "${IMAGE}", data.Image}
+	keys := make([]string, 0, len(data.Variables))
+	for k := range data.Variables {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("${%s}", k), data.Variables[k])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// Funcs returns the functions available in descriptor templates
+func Funcs() template.FuncMap {
+	return template.FuncMap{
+		"default":    defaultValue,
+		"required":   required,
+		"empty":      empty,
+		"b64enc":     b64enc,
+		"b64dec":     b64dec,
+		"sha256sum":  sha256sum,
+		"toYaml":     toYaml,
+		"toJson":     toJson,
+		"quote":      quote,
+		"squote":     squote,
+		"indent":     indent,
+		"nindent":    nindent,
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       join,
+		"list":       func(v ...interface{}) []interface{} { return v },
+		"dict":       dict,
+	}
+}
+
+func defaultValue(def interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || empty(given[0]) {
+		return def
+	}
+	return given[0]
+}
+
+func required(msg string, value interface{}) (interface{}, error) {
+	if empty(value) {
+		return nil, errors.New(msg)
+	}
+	return value, nil
+}
+
+func empty(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
+
+func b64enc(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func b64dec(s string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+func sha256sum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func toYaml(value interface{}) (string, error) {
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func toJson(value interface{}) (string, error) {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func quote(value interface{}) string {
+	return fmt.Sprintf("%q", fmt.Sprint(value))
+}
+
+func squote(value interface{}) string {
+	return fmt.Sprintf("'%s'", fmt.Sprint(value))
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func nindent(spaces int, s string) string {
+	return "\n" + indent(spaces, s)
+}
+
+func join(sep string, values interface{}) string {
+	v := reflect.ValueOf(values)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return fmt.Sprint(values)
+	}
+	parts := make([]string, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		parts[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(parts, sep)
+}
+
+func dict(values ...interface{}) (map[string]interface{}, error) {
+	if len(values)%2 != 0 {
+		return nil, errors.New("dict requires an even number of arguments")
+	}
+	result := make(map[string]interface{}, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		key, ok := values[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings, got %T", values[i])
+		}
+		result[key] = values[i+1]
+	}
+	return result, nil
+}
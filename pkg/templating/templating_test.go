@@ -0,0 +1,176 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package templating
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	data := Data{
+		Commit:    "abc123",
+		Timestamp: "2019-05-13T17:22:36Z",
+		Image:     "registry/image:abc123",
+		Target:    "prod",
+		Variables: map[string]string{"REPLICAS": "3", "IMAGE": "ignored", "ALERT": "{{ $labels.instance }} is down"},
+	}
+	tests := []struct {
+		name        string
+		content     string
+		goTemplates bool
+		want        string
+		wantErr     string
+	}{
+		{
+			name:    "placeholders",
+			content: "image: ${IMAGE}\ncommit: ${COMMIT}\ntimestamp: ${TIMESTAMP}",
+			want:    "image: registry/image:abc123\ncommit: abc123\ntimestamp: 2019-05-13T17:22:36Z",
+		},
+		{
+			name:    "variables",
+			content: "replicas: ${REPLICAS}\nimage: ${IMAGE}\nunknown: ${UNKNOWN}",
+			want:    "replicas: 3\nimage: registry/image:abc123\nunknown: ${UNKNOWN}",
+		},
+		{
+			name:        "variables in go templates",
+			content:     `replicas: {{ .Variables.REPLICAS }}{{ with .Variables.MISSING }} missing{{ end }}`,
+			goTemplates: true,
+			want:        "replicas: 3",
+		},
+		{
+			name:    "go templates disabled",
+			content: "value: {{ .Image }}",
+			want:    "value: {{ .Image }}",
+		},
+		{
+			name:        "placeholders and go templates",
+			content:     "image: ${IMAGE}\ntarget: {{ .Target }}\ncommit: {{ .Commit }}",
+			goTemplates: true,
+			want:        "image: registry/image:abc123\ntarget: prod\ncommit: abc123",
+		},
+		{
+			name:        "template code in variables",
+			content:     "alert: ${ALERT}\nimage: {{ .Image }}",
+			goTemplates: true,
+			want:        "alert: {{ $labels.instance }} is down\nimage: registry/image:abc123",
+		},
+		{
+			name:        "conditionals",
+			content:     `replicas: {{ if eq .Target "prod" }}3{{ else }}1{{ end }}`,
+			goTemplates: true,
+			want:        "replicas: 3",
+		},
+		{
+			name:        "loops",
+			content:     `{{ range $i, $v := list "a" "b" }}{{ $i }}={{ $v }} {{ end }}`,
+			goTemplates: true,
+			want:        "0=a 1=b ",
+		},
+		{
+			name:        "default",
+			content:     `{{ "" | default "fallback" }} {{ "value" | default "fallback" }}`,
+			goTemplates: true,
+			want:        "fallback value",
+		},
+		{
+			name:        "b64enc and b64dec",
+			content:     `{{ b64enc "secret" }} {{ b64dec "c2VjcmV0" }}`,
+			goTemplates: true,
+			want:        "c2VjcmV0 secret",
+		},
+		{
+			name:        "toYaml and nindent",
+			content:     "labels:{{ dict \"app\" \"test\" \"target\" .Target | toYaml | nindent 2 }}",
+			goTemplates: true,
+			want:        "labels:\n  app: test\n  target: prod",
+		},
+		{
+			name:        "toJson",
+			content:     `{{ list 1 "two" | toJson }}`,
+			goTemplates: true,
+			want:        `[1,"two"]`,
+		},
+		{
+			name:        "string functions",
+			content:     `{{ upper "a" }}{{ lower "B" }}{{ trim " c " }}{{ replace "x" "d" "x" }}{{ trimSuffix ".yaml" "e.yaml" }}{{ join "," (split "-" "f-g") }} {{ quote .Target }} {{ squote .Target }}`,
+			goTemplates: true,
+			want:        `Abcdef,g "prod" 'prod'`,
+		},
+		{
+			name:        "required present",
+			content:     `{{ required "target is required" .Target }}`,
+			goTemplates: true,
+			want:        "prod",
+		},
+		{
+			name:        "required missing",
+			content:     `{{ required "value is required" "" }}`,
+			goTemplates: true,
+			wantErr:     `template: deploy.yaml:1:3: executing "deploy.yaml" at <required "value is required" "">: error calling required: value is required`,
+		},
+		{
+			name:        "parse error",
+			content:     `{{ .Target `,
+			goTemplates: true,
+			wantErr:     `template: deploy.yaml:1: unclosed action`,
+		},
+		{
+			name:        "unknown field",
+			content:     `{{ .Missing }}`,
+			goTemplates: true,
+			wantErr:     `template: deploy.yaml:1:3: executing "deploy.yaml" at <.Missing>: can't evaluate field Missing in type templating.Data`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Render("deploy.yaml", tt.content, data, tt.goTemplates)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDict_Errors(t *testing.T) {
+	_, err := dict("key")
+	assert.EqualError(t, err, "dict requires an even number of arguments")
+	_, err = dict(1, "value")
+	assert.EqualError(t, err, "dict keys must be strings, got int")
+}
+
+func TestEmpty(t *testing.T) {
+	assert.True(t, empty(nil))
+	assert.True(t, empty(""))
+	assert.True(t, empty(0))
+	assert.True(t, empty(false))
+	assert.True(t, empty([]string{}))
+	assert.False(t, empty("a"))
+	assert.False(t, empty(1))
+	assert.False(t, empty(true))
+	assert.False(t, empty(map[string]string{"a": "b"}))
+}
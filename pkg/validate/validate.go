@@ -0,0 +1,126 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package validate
+
+import (
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/buildtool/build-tools/pkg/args"
+	"github.com/buildtool/build-tools/pkg/ci"
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/deploy"
+	"github.com/buildtool/build-tools/pkg/policy"
+	"github.com/buildtool/build-tools/pkg/schema"
+	"github.com/buildtool/build-tools/pkg/version"
+)
+
+type Args struct {
+	args.Globals
+	Target         string            `arg:"" name:"target" help:"the target in the .buildtools.yaml"`
+	Tag            string            `name:"tag" help:"override the tag to render, not using the CI or VCS evaluated value" default:""`
+	APIVersionsFor string            `name:"api-versions-for" help:"override the Kubernetes version (e.g. 1.29) in which the apiVersions must be served" default:""`
+	Schemas        string            `name:"schemas" help:"override the directory with CustomResourceDefinitions used to validate custom resources" default:""`
+	Variables      map[string]string `name:"var" help:"set a variable (KEY=VALUE) for substitution in deployment descriptors, overriding the target configuration"`
+}
+
+func DoValidate(dir string, info version.Info, osArgs ...string) int {
+	var validateArgs Args
+	err := args.ParseArgs(dir, osArgs, info, &validateArgs)
+	if err != nil {
+		if err != args.ErrDone {
+			return -1
+		} else {
+			return 0
+		}
+	}
+
+	cfg, err := config.Load(dir)
+	if err != nil {
+		log.Error(err.Error())
+		return -1
+	}
+	variables, namespace := targetConfig(cfg, validateArgs.Target)
+	if validateArgs.APIVersionsFor != "" {
+		cfg.Validation.APIVersionsFor = validateArgs.APIVersionsFor
+	}
+	if validateArgs.Schemas != "" {
+		cfg.Validation.Schemas = validateArgs.Schemas
+	}
+	validator, err := schema.NewFromConfig(dir, cfg.Validation)
+	if err != nil {
+		log.Error(err.Error())
+		return -1
+	}
+	var checker *policy.Checker
+	if cfg.Policy.Configured() {
+		if checker, err = policy.New(cfg.Policy); err != nil {
+			log.Error(err.Error())
+			return -1
+		}
+	}
+	currentCI := cfg.CurrentCI()
+	if validateArgs.Tag == "" {
+		if !ci.IsValid(currentCI) {
+			log.Errorf("Commit and/or branch information is <red>missing</red>. Perhaps your not in a Git repository or forgot to set environment variables?")
+			return -3
+		}
+		validateArgs.Tag = currentCI.Commit()
+	}
+
+	content, err := deploy.Render(dir, cfg.CurrentRegistry().RegistryUrl(), currentCI.BuildName(), time.Now().Format(time.RFC3339), deploy.Args{
+		Target:    validateArgs.Target,
+		Namespace: namespace,
+		Tag:       validateArgs.Tag,
+		Variables: config.MergeVariables(validateArgs.Variables, variables),
+	}, cfg)
+	if err != nil {
+		log.Error(err.Error())
+		return -4
+	}
+	if err := validator.Validate(content); err != nil {
+		log.Error(err.Error())
+		return -4
+	}
+	if checker != nil {
+		if err := checker.Check(content); err != nil {
+			log.Error(err.Error())
+			return -4
+		}
+	}
+	log.Infof("Deployment descriptors for <green>%s</green> are valid\n", validateArgs.Target)
+	return 0
+}
+
+// targetConfig returns the variables and namespace of target, which is either a deploy or a gitops target
+func targetConfig(cfg *config.Config, target string) (map[string]string, string) {
+	if env, err := cfg.CurrentTarget(target); err == nil {
+		return env.Variables, env.Namespace
+	}
+	if gitops, err := cfg.CurrentGitops(target); err == nil {
+		return gitops.Variables, ""
+	}
+	log.Warnf("no target matching %s found, validating without target configuration\n", target)
+	return nil, ""
+}
@@ -0,0 +1,216 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg"
+	"github.com/buildtool/build-tools/pkg/version"
+)
+
+const buildtools = `
+targets:
+  prod:
+    context: prod-cluster
+    namespace: apps
+    variables:
+      REPLICAS: "3"
+validation:
+  apiVersionsFor: "1.29"
+`
+
+const deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: ${REPLICAS}
+  template:
+    spec:
+      containers:
+        - name: app
+          image: ${IMAGE}
+`
+
+func project(t *testing.T, files map[string]string) string {
+	t.Helper()
+	name := t.TempDir()
+	for file, content := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(name, file)), 0o777))
+		assert.NoError(t, os.WriteFile(filepath.Join(name, file), []byte(content), 0o666))
+	}
+	return name
+}
+
+func TestDoValidate(t *testing.T) {
+	defer pkg.UnsetGithubEnvironment()()
+	tests := []struct {
+		name       string
+		files      map[string]string
+		args       []string
+		want       int
+		wantLogged []string
+	}{
+		{
+			name:  "valid",
+			files: map[string]string{".buildtools.yaml": buildtools, "k8s/deploy.yaml": deployment},
+			args:  []string{"prod", "--tag", "abc123"},
+			want:  0,
+			wantLogged: []string{
+				"info: Deployment descriptors for <green>prod</green> are valid\n",
+			},
+		},
+		{
+			name:  "variable with wrong type",
+			files: map[string]string{".buildtools.yaml": buildtools, "k8s/deploy.yaml": deployment},
+			args:  []string{"prod", "--tag", "abc123", "--var", "REPLICAS=three"},
+			want:  -4,
+			wantLogged: []string{
+				"error: validation failed:\n  deployment/app: .spec.replicas: expected numeric (int or float), got string",
+			},
+		},
+		{
+			name:  "removed api",
+			files: map[string]string{".buildtools.yaml": buildtools, "k8s/cronjob.yaml": "apiVersion: batch/v1beta1\nkind: CronJob\nmetadata:\n  name: job\n"},
+			args:  []string{"prod", "--tag", "abc123", "--api-versions-for", "1.25"},
+			want:  -4,
+			wantLogged: []string{
+				"error: validation failed:\n  cronjob/job: batch/v1beta1 CronJob was removed in Kubernetes 1.25, use batch/v1 instead",
+			},
+		},
+		{
+			name: "custom resource",
+			files: map[string]string{
+				".buildtools.yaml":   buildtools,
+				"k8s/widget.yaml":    "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: small\nspec:\n  size: small\n",
+				"schemas/widget.yml": "apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: widgets.example.com\nspec:\n  group: example.com\n  names:\n    kind: Widget\n  versions:\n    - name: v1\n      schema:\n        openAPIV3Schema:\n          type: object\n          properties:\n            spec:\n              type: object\n              properties:\n                size:\n                  type: integer\n",
+			},
+			args: []string{"prod", "--tag", "abc123", "--schemas", "schemas"},
+			want: -4,
+			wantLogged: []string{
+				"error: validation failed:\n  widget/small: .spec.size: expected numeric (int or float), got string",
+			},
+		},
+		{
+			name: "policy violation",
+			files: map[string]string{
+				".buildtools.yaml": buildtools + "policy:\n  noLatestTag: true\n",
+				"k8s/deploy.yaml":  deployment,
+				"k8s/debug.yaml":   "apiVersion: v1\nkind: Pod\nmetadata:\n  name: debug\nspec:\n  containers:\n    - name: debug\n      image: busybox:latest\n",
+			},
+			args: []string{"prod", "--tag", "abc123"},
+			want: -4,
+			wantLogged: []string{
+				"error: policy check failed:\n  pod/debug: container 'debug' must use an image with a tag other than latest, not 'busybox:latest' (noLatestTag)",
+			},
+		},
+		{
+			name:  "invalid policy",
+			files: map[string]string{".buildtools.yaml": buildtools + "policy:\n  rules:\n    - name: broken\n      expression: \"'abc'\"\n"},
+			args:  []string{"prod", "--tag", "abc123"},
+			want:  -1,
+			wantLogged: []string{
+				"error: expression for policy rule 'broken' must evaluate to a bool, not string",
+			},
+		},
+		{
+			name:  "gitops target",
+			files: map[string]string{".buildtools.yaml": "gitops:\n  prod:\n    url: git@example.org:gitops.git\n    variables:\n      REPLICAS: \"2\"\n", "k8s/deploy.yaml": deployment},
+			args:  []string{"prod", "--tag", "abc123"},
+			want:  0,
+			wantLogged: []string{
+				"info: Deployment descriptors for <green>prod</green> are valid\n",
+			},
+		},
+		{
+			name:  "unknown target",
+			files: map[string]string{"k8s/config.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n"},
+			args:  []string{"dummy", "--tag", "abc123"},
+			want:  0,
+			wantLogged: []string{
+				"warn: no target matching dummy found, validating without target configuration\n",
+				"info: Deployment descriptors for <green>dummy</green> are valid\n",
+			},
+		},
+		{
+			name:  "no descriptors",
+			files: map[string]string{".buildtools.yaml": buildtools},
+			args:  []string{"prod", "--tag", "abc123"},
+			want:  -4,
+			wantLogged: []string{
+				"error: open <dir>/k8s: no such file or directory",
+			},
+		},
+		{
+			name:  "invalid kubernetes version",
+			files: map[string]string{".buildtools.yaml": buildtools},
+			args:  []string{"prod", "--api-versions-for", "2"},
+			want:  -1,
+			wantLogged: []string{
+				"error: invalid kubernetes version '2', must be like 1.29",
+			},
+		},
+		{
+			name:  "no CI",
+			files: map[string]string{".buildtools.yaml": buildtools, "k8s/deploy.yaml": deployment},
+			args:  []string{"prod"},
+			want:  -3,
+			wantLogged: []string{
+				"error: Commit and/or branch information is <red>missing</red>. Perhaps your not in a Git repository or forgot to set environment variables?",
+			},
+		},
+		{
+			name:  "missing target",
+			files: map[string]string{},
+			args:  []string{},
+			want:  -1,
+			wantLogged: []string{
+				"info: error: expected \"<target>\"\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := project(t, tt.files)
+			logMock := mocks.New()
+			log.SetHandler(logMock)
+			log.SetLevel(log.InfoLevel)
+
+			got := DoValidate(name, version.Info{}, tt.args...)
+
+			assert.Equal(t, tt.want, got)
+			for i, logged := range tt.wantLogged {
+				tt.wantLogged[i] = strings.ReplaceAll(logged, "<dir>", name)
+			}
+			logMock.Check(t, tt.wantLogged)
+		})
+	}
+}